@@ -143,6 +143,7 @@ func TestStation13(t *testing.T) {
 				"id":          tc.ID,
 				"subject":     tc.Subject,
 				"description": tc.Description,
+				"completed":   false,
 			}
 
 			now := time.Now().UTC()
@@ -110,6 +110,7 @@ func TestStation9(t *testing.T) {
 			want := map[string]interface{}{
 				"subject":     tc.Subject,
 				"description": tc.Description,
+				"completed":   false,
 			}
 
 			now := time.Now().UTC()
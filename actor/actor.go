@@ -0,0 +1,18 @@
+// Package actor carries who is making the request being served through a
+// context.Context, so that the changes made by the request can be attributed.
+package actor
+
+import "context"
+
+type contextKey struct{}
+
+// NewContext returns a copy of ctx that carries the actor name.
+func NewContext(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, contextKey{}, name)
+}
+
+// FromContext returns the actor carried by ctx, or "" if it is unknown.
+func FromContext(ctx context.Context) string {
+	name, _ := ctx.Value(contextKey{}).(string)
+	return name
+}
@@ -0,0 +1,52 @@
+// Package auth carries the authenticated user of the request being served
+// through a context.Context, so that the services only read and change the
+// data of that user.
+package auth
+
+import (
+	"context"
+
+	"github.com/TechBowl-japan/go-stations/actor"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+type (
+	contextKey struct{}
+	scopesKey  struct{}
+)
+
+// NewContext returns a copy of ctx that carries the user, who is also the
+// actor the changes made with ctx are attributed to.
+func NewContext(ctx context.Context, u *model.User) context.Context {
+	ctx = actor.NewContext(ctx, u.Name)
+	return context.WithValue(ctx, contextKey{}, u)
+}
+
+// FromContext returns the user carried by ctx, or nil if the request is not
+// authenticated.
+func FromContext(ctx context.Context) *model.User {
+	u, _ := ctx.Value(contextKey{}).(*model.User)
+	return u
+}
+
+// WithScopes returns a copy of ctx that only grants the scopes, as a request
+// authenticated with an API key does.
+func WithScopes(ctx context.Context, scopes []model.Scope) context.Context {
+	return context.WithValue(ctx, scopesKey{}, scopes)
+}
+
+// HasScope reports whether ctx grants the scope. A context that is not
+// restricted with WithScopes grants every scope, and model.ScopeAdmin grants
+// every other scope.
+func HasScope(ctx context.Context, scope model.Scope) bool {
+	scopes, ok := ctx.Value(scopesKey{}).([]model.Scope)
+	if !ok {
+		return true
+	}
+	for _, s := range scopes {
+		if s == scope || s == model.ScopeAdmin {
+			return true
+		}
+	}
+	return false
+}
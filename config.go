@@ -0,0 +1,293 @@
+package main
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/handler/router"
+	"github.com/TechBowl-japan/go-stations/jwt"
+	"github.com/TechBowl-japan/go-stations/oidc"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// routerConfig reads the settings of the router from the environment. Settings
+// that cannot be read are reported as an error, so that the server does not
+// start less protected than intended.
+func routerConfig() (*router.Config, error) {
+	var (
+		cfg router.Config
+		err error
+	)
+
+	if cfg.BasicAuth, err = basicAuthConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.JWTKeys, cfg.Session, err = jwtConfig(); err != nil {
+		return nil, err
+	}
+	if cfg.OIDC, err = oidcConfig(); err != nil {
+		return nil, err
+	}
+	// OpenID Connect でのログインはセッションを発行して終わる
+	if cfg.OIDC != nil && cfg.JWTKeys == nil {
+		return nil, errors.New("OIDC_ISSUER requires JWT_KEYS to issue sessions")
+	}
+	if cfg.UndoWindow, err = undoWindow(); err != nil {
+		return nil, err
+	}
+	return &cfg, nil
+}
+
+// basicAuthConfig reads the Basic authentication settings from the environment:
+//
+//	BASIC_AUTH_USERS  htpasswd lines with bcrypt hashes, separated by commas or newlines
+//	BASIC_AUTH_FILE   path of an htpasswd file with bcrypt hashes
+//	BASIC_AUTH_REALM  realm of the challenge, defaults to "TODO"
+//
+// It returns nil if neither BASIC_AUTH_USERS nor BASIC_AUTH_FILE is set.
+func basicAuthConfig() (*middleware.BasicAuthConfig, error) {
+	const defaultRealm = "TODO"
+
+	users, path := os.Getenv("BASIC_AUTH_USERS"), os.Getenv("BASIC_AUTH_FILE")
+	if users == "" && path == "" {
+		return nil, nil
+	}
+
+	cfg := &middleware.BasicAuthConfig{
+		Realm:       os.Getenv("BASIC_AUTH_REALM"),
+		Credentials: middleware.Credentials{},
+	}
+	if cfg.Realm == "" {
+		cfg.Realm = defaultRealm
+	}
+
+	if users != "" {
+		creds, err := middleware.ParseHtpasswd(strings.NewReader(strings.ReplaceAll(users, ",", "\n")))
+		if err != nil {
+			return nil, fmt.Errorf("invalid BASIC_AUTH_USERS: %v", err)
+		}
+		cfg.Credentials = creds
+	}
+	if path != "" {
+		creds, err := middleware.LoadHtpasswd(path)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read BASIC_AUTH_FILE %q: %v", path, err)
+		}
+		for name, hash := range creds {
+			if err := cfg.Credentials.Add(name, hash); err != nil {
+				return nil, fmt.Errorf("invalid BASIC_AUTH_FILE %q: %v", path, err)
+			}
+		}
+	}
+	return cfg, nil
+}
+
+// jwtConfig reads the session settings from the environment:
+//
+//	JWT_KEYS         signing keys as comma separated "kid:alg:key", where alg is
+//	                 HS256 with a secret of at least 32 bytes or EdDSA with an
+//	                 Ed25519 seed or private key, in standard base64. The first
+//	                 key signs, and the others only verify until they are removed.
+//	JWT_AUDIENCE     audience of the access tokens, defaults to "todo"
+//	JWT_ACCESS_TTL   lifetime of the access tokens, defaults to 15m
+//	JWT_REFRESH_TTL  lifetime of the refresh tokens, defaults to 720h
+//
+// It returns nil keys if JWT_KEYS is not set.
+func jwtConfig() (*jwt.KeySet, service.SessionConfig, error) {
+	const (
+		defaultAudience   = "todo"
+		defaultAccessTTL  = 15 * time.Minute
+		defaultRefreshTTL = 30 * 24 * time.Hour
+	)
+
+	cfg := service.SessionConfig{
+		Audience:   os.Getenv("JWT_AUDIENCE"),
+		AccessTTL:  defaultAccessTTL,
+		RefreshTTL: defaultRefreshTTL,
+	}
+	if cfg.Audience == "" {
+		cfg.Audience = defaultAudience
+	}
+
+	v := os.Getenv("JWT_KEYS")
+	if v == "" {
+		return nil, cfg, nil
+	}
+
+	var keys []*jwt.Key
+	for i, entry := range strings.Split(v, ",") {
+		key, err := parseJWTKey(strings.TrimSpace(entry))
+		if err != nil {
+			// 秘密がログに残らないよう、エントリは位置で示す
+			return nil, cfg, fmt.Errorf("invalid JWT_KEYS: key %d: %v", i+1, err)
+		}
+		keys = append(keys, key)
+	}
+	ks, err := jwt.NewKeySet(keys[0], keys[1:]...)
+	if err != nil {
+		return nil, cfg, fmt.Errorf("invalid JWT_KEYS: %v", err)
+	}
+
+	for _, s := range []struct {
+		name string
+		ttl  *time.Duration
+	}{
+		{"JWT_ACCESS_TTL", &cfg.AccessTTL},
+		{"JWT_REFRESH_TTL", &cfg.RefreshTTL},
+	} {
+		v := os.Getenv(s.name)
+		if v == "" {
+			continue
+		}
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			return nil, cfg, fmt.Errorf("invalid %s %q", s.name, v)
+		}
+		*s.ttl = d
+	}
+	return ks, cfg, nil
+}
+
+// oidcConfig reads the settings of signing in with an OpenID Connect provider
+// from the environment:
+//
+//	OIDC_ISSUER         issuer identifier of the provider
+//	OIDC_CLIENT_ID      client ID registered with the provider
+//	OIDC_CLIENT_SECRET  client secret, unset for a public client
+//	OIDC_REDIRECT_URL   registered redirect URL, which serves /auth/oidc/callback
+//	OIDC_SCOPES         scopes besides openid, defaults to "profile email"
+//
+// It returns nil if OIDC_ISSUER is not set.
+func oidcConfig() (*oidc.Config, error) {
+	const defaultScopes = "profile email"
+
+	cfg := &oidc.Config{
+		Issuer:       os.Getenv("OIDC_ISSUER"),
+		ClientID:     os.Getenv("OIDC_CLIENT_ID"),
+		ClientSecret: os.Getenv("OIDC_CLIENT_SECRET"),
+		RedirectURL:  os.Getenv("OIDC_REDIRECT_URL"),
+	}
+	if cfg.Issuer == "" {
+		return nil, nil
+	}
+	if cfg.ClientID == "" || cfg.RedirectURL == "" {
+		return nil, errors.New("OIDC_ISSUER requires OIDC_CLIENT_ID and OIDC_REDIRECT_URL")
+	}
+
+	scopes := os.Getenv("OIDC_SCOPES")
+	if scopes == "" {
+		scopes = defaultScopes
+	}
+	cfg.Scopes = strings.Fields(scopes)
+	return cfg, nil
+}
+
+// parseJWTKey parses a key of JWT_KEYS in the form "kid:alg:key".
+func parseJWTKey(entry string) (*jwt.Key, error) {
+	parts := strings.SplitN(entry, ":", 3)
+	if len(parts) != 3 || parts[0] == "" {
+		return nil, errors.New("not in the form kid:alg:key")
+	}
+	id, alg := parts[0], parts[1]
+
+	b, err := base64.StdEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("%q is not in base64: %v", id, err)
+	}
+
+	switch {
+	case alg == jwt.HS256:
+		return jwt.NewHS256Key(id, b)
+	case alg == jwt.EdDSA && len(b) == ed25519.SeedSize:
+		return jwt.NewEd25519Key(id, ed25519.NewKeyFromSeed(b)), nil
+	case alg == jwt.EdDSA && len(b) == ed25519.PrivateKeySize:
+		return jwt.NewEd25519Key(id, ed25519.PrivateKey(b)), nil
+	case alg == jwt.EdDSA:
+		return nil, fmt.Errorf("%q must be an Ed25519 seed or private key", id)
+	default:
+		return nil, fmt.Errorf("%q has the unsupported algorithm %q", id, alg)
+	}
+}
+
+// undoWindow reads from UNDO_WINDOW how long undo tokens are valid, 0 to
+// disable undo, defaulting to service.DefaultUndoWindow.
+func undoWindow() (time.Duration, error) {
+	v := os.Getenv("UNDO_WINDOW")
+	if v == "" {
+		return service.DefaultUndoWindow, nil
+	}
+
+	d, err := time.ParseDuration(v)
+	if err != nil || d < 0 {
+		return 0, fmt.Errorf("invalid UNDO_WINDOW %q", v)
+	}
+	return d, nil
+}
+
+// accessLogConfig reads the access log settings from the environment:
+//
+//	ACCESS_LOG              "stderr" (default), "stdout", "off" or a file path
+//	ACCESS_LOG_SAMPLE_RATE  fraction of requests to log, defaults to 1
+//	ACCESS_LOG_MAX_BYTES    size at which the log file is rotated, defaults to 10MiB
+//	ACCESS_LOG_MAX_BACKUPS  number of rotated files to keep, defaults to 5
+//
+// It returns nil if the access log is turned off. If it is written to a file,
+// it also returns the file, which the caller closes after the server has shut
+// down.
+func accessLogConfig() (*middleware.AccessLogConfig, io.Closer, error) {
+	const (
+		defaultMaxBytes   = 10 << 20
+		defaultMaxBackups = 5
+	)
+
+	cfg := &middleware.AccessLogConfig{}
+
+	if v := os.Getenv("ACCESS_LOG_SAMPLE_RATE"); v != "" {
+		rate, err := strconv.ParseFloat(v, 64)
+		if err != nil || rate < 0 || rate > 1 {
+			return nil, nil, fmt.Errorf("invalid ACCESS_LOG_SAMPLE_RATE %q", v)
+		}
+		cfg.SampleRate = rate
+	}
+
+	switch out := os.Getenv("ACCESS_LOG"); out {
+	case "off":
+		return nil, nil, nil
+	case "", "stderr":
+		cfg.Out = os.Stderr
+	case "stdout":
+		cfg.Out = os.Stdout
+	default:
+		maxBytes, maxBackups := int64(defaultMaxBytes), defaultMaxBackups
+		if v := os.Getenv("ACCESS_LOG_MAX_BYTES"); v != "" {
+			n, err := strconv.ParseInt(v, 10, 64)
+			if err != nil || n < 0 {
+				return nil, nil, fmt.Errorf("invalid ACCESS_LOG_MAX_BYTES %q", v)
+			}
+			maxBytes = n
+		}
+		if v := os.Getenv("ACCESS_LOG_MAX_BACKUPS"); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return nil, nil, fmt.Errorf("invalid ACCESS_LOG_MAX_BACKUPS %q", v)
+			}
+			maxBackups = n
+		}
+
+		f, err := middleware.NewRotatingFile(out, maxBytes, maxBackups)
+		if err != nil {
+			return nil, nil, fmt.Errorf("failed to open access log %q: %v", out, err)
+		}
+		cfg.Out = f
+		return cfg, f, nil
+	}
+	return cfg, nil, nil
+}
@@ -0,0 +1,201 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// pwHash is the bcrypt hash of "pw" with the minimum cost.
+const pwHash = "$2a$04$YFXXtWXKdqHtpK43rCuyD.YIjj/Lvy3UmyPRgTGeUrU7E8SLqN47y"
+
+// setenv sets the environment variable for the test, unsetting it if value
+// is empty, and restores it when the test ends.
+func setenv(t *testing.T, key, value string) {
+	old, ok := os.LookupEnv(key)
+	t.Cleanup(func() {
+		if ok {
+			os.Setenv(key, old)
+		} else {
+			os.Unsetenv(key)
+		}
+	})
+	if value == "" {
+		os.Unsetenv(key)
+	} else {
+		os.Setenv(key, value)
+	}
+}
+
+func TestBasicAuthConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "htpasswd")
+	if err := ioutil.WriteFile(file, []byte("Alice:"+pwHash+"\nbob:"+pwHash+"\n"), 0o600); err != nil {
+		t.Fatal("failed to write htpasswd, err =", err)
+	}
+
+	tests := []struct {
+		name      string
+		users     string
+		file      string
+		wantUsers int
+		wantErr   bool
+	}{
+		{name: "unset"},
+		{name: "users", users: "alice:" + pwHash + ",carol:" + pwHash, wantUsers: 2},
+		{name: "file", file: file, wantUsers: 2},
+		{name: "users and file", users: "carol:" + pwHash, file: file, wantUsers: 3},
+		{name: "invalid users", users: "alice", wantErr: true},
+		{name: "missing file", file: file + ".missing", wantErr: true},
+		{name: "same user in users and file", users: "alice:" + pwHash, file: file, wantErr: true},
+	}
+	for _, tt := range tests {
+		setenv(t, "BASIC_AUTH_USERS", tt.users)
+		setenv(t, "BASIC_AUTH_FILE", tt.file)
+
+		cfg, err := basicAuthConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		switch {
+		case tt.wantErr:
+		case tt.wantUsers == 0 && cfg != nil:
+			t.Errorf("%s: got %+v, want no Basic authentication", tt.name, cfg)
+		case tt.wantUsers > 0 && (cfg == nil || len(cfg.Credentials) != tt.wantUsers):
+			t.Errorf("%s: got %+v, want %d users", tt.name, cfg, tt.wantUsers)
+		}
+	}
+}
+
+func TestJWTConfig(t *testing.T) {
+	const secret = "YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE="
+
+	tests := []struct {
+		name      string
+		keys      string
+		accessTTL string
+		wantKeys  bool
+		wantErr   bool
+	}{
+		{name: "unset"},
+		{name: "keys", keys: "k1:HS256:" + secret + ", k0:HS256:" + secret, wantKeys: true},
+		{name: "access TTL", keys: "k1:HS256:" + secret, accessTTL: "5m", wantKeys: true},
+		{name: "not kid:alg:key", keys: secret, wantErr: true},
+		{name: "short secret", keys: "k1:HS256:YWFhYWFhYWFhYWFhYWFhYQ==", wantErr: true},
+		{name: "unsupported algorithm", keys: "k1:RS256:" + secret, wantErr: true},
+		{name: "invalid access TTL", keys: "k1:HS256:" + secret, accessTTL: "-5m", wantErr: true},
+	}
+	for _, tt := range tests {
+		setenv(t, "JWT_KEYS", tt.keys)
+		setenv(t, "JWT_ACCESS_TTL", tt.accessTTL)
+
+		keys, _, err := jwtConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if (keys != nil) != tt.wantKeys {
+			t.Errorf("%s: got keys %v, want keys %v", tt.name, keys != nil, tt.wantKeys)
+		}
+	}
+}
+
+func TestUndoWindow(t *testing.T) {
+	tests := []struct {
+		in      string
+		want    time.Duration
+		wantErr bool
+	}{
+		{in: "", want: service.DefaultUndoWindow},
+		{in: "0", want: 0},
+		{in: "1m", want: time.Minute},
+		{in: "-1m", wantErr: true},
+		{in: "soon", wantErr: true},
+	}
+	for _, tt := range tests {
+		setenv(t, "UNDO_WINDOW", tt.in)
+
+		got, err := undoWindow()
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("UNDO_WINDOW=%q: got %s, %v, want %s", tt.in, got, err, tt.want)
+		}
+	}
+}
+
+func TestRouterConfigOIDC(t *testing.T) {
+	const secret = "YWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWFhYWE="
+
+	for _, key := range []string{"BASIC_AUTH_USERS", "BASIC_AUTH_FILE", "UNDO_WINDOW", "OIDC_SCOPES"} {
+		setenv(t, key, "")
+	}
+
+	tests := []struct {
+		name     string
+		keys     string
+		clientID string
+		wantErr  bool
+	}{
+		{name: "with JWT keys", keys: "k1:HS256:" + secret, clientID: "todo"},
+		{name: "without JWT keys", clientID: "todo", wantErr: true},
+		{name: "without client ID", keys: "k1:HS256:" + secret, wantErr: true},
+	}
+	for _, tt := range tests {
+		setenv(t, "JWT_KEYS", tt.keys)
+		setenv(t, "OIDC_ISSUER", "https://idp.example")
+		setenv(t, "OIDC_CLIENT_ID", tt.clientID)
+		setenv(t, "OIDC_REDIRECT_URL", "https://todo.example/auth/oidc/callback")
+
+		cfg, err := routerConfig()
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if !tt.wantErr && (cfg.OIDC == nil || cfg.OIDC.ClientID != tt.clientID || len(cfg.OIDC.Scopes) != 2) {
+			t.Errorf("%s: got OIDC settings %+v", tt.name, cfg.OIDC)
+		}
+	}
+}
+
+func TestAccessLogConfig(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "access.log")
+
+	tests := []struct {
+		name       string
+		out        string
+		sampleRate string
+		maxBytes   string
+		wantLog    bool
+		wantFile   bool
+		wantErr    bool
+	}{
+		{name: "default", wantLog: true},
+		{name: "off", out: "off"},
+		{name: "file", out: file, maxBytes: "1024", wantLog: true, wantFile: true},
+		{name: "invalid sample rate", sampleRate: "2", wantErr: true},
+		{name: "invalid max bytes", out: file, maxBytes: "1k", wantErr: true},
+		{name: "unwritable file", out: filepath.Join(file, "access.log"), wantErr: true},
+	}
+	for _, tt := range tests {
+		setenv(t, "ACCESS_LOG", tt.out)
+		setenv(t, "ACCESS_LOG_SAMPLE_RATE", tt.sampleRate)
+		setenv(t, "ACCESS_LOG_MAX_BYTES", tt.maxBytes)
+
+		cfg, f, err := accessLogConfig()
+		if f != nil {
+			if err := f.Close(); err != nil {
+				t.Errorf("%s: failed to close the access log, err = %v", tt.name, err)
+			}
+		}
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+			continue
+		}
+		if (cfg != nil) != tt.wantLog || (f != nil) != tt.wantFile {
+			t.Errorf("%s: got access log %v and file %v, want %v and %v", tt.name, cfg != nil, f != nil, tt.wantLog, tt.wantFile)
+		}
+	}
+}
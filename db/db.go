@@ -3,23 +3,121 @@ package db
 import (
 	"database/sql"
 	_ "embed"
+	"strings"
 
 	_ "github.com/mattn/go-sqlite3"
 )
 
-//go:embed schema.sql
-var schema string
+// fts5Schema holds the full-text search index of todos. It is only applied
+// when the SQLite library is built with FTS5, e.g. `go build -tags sqlite_fts5`,
+// so it is kept out of the migrations and rebuilt from todos when created.
+// A build without FTS5 drops its triggers, which would fail every write of
+// todos, and a build with FTS5 rebuilds the index when they are missing.
+//go:embed fts5.sql
+var fts5Schema string
 
-// NewDB returns go-sqlite3 driver based *sql.DB.
+// NewDB returns go-sqlite3 driver based *sql.DB with all migrations applied.
 func NewDB(path string) (*sql.DB, error) {
-	db, err := sql.Open("sqlite3", path)
+	db, err := Open(path)
 	if err != nil {
 		return nil, err
 	}
 
-	if _, err := db.Exec(schema); err != nil {
+	if _, err := Migrate(db, false); err != nil {
+		db.Close()
+		return nil, err
+	}
+
+	if err := setupFTS5(db); err != nil {
+		db.Close()
 		return nil, err
 	}
 
 	return db, nil
 }
+
+// Open returns go-sqlite3 driver based *sql.DB without touching its schema.
+func Open(path string) (*sql.DB, error) {
+	// 条件付き更新が競合しないよう、トランザクションは BEGIN IMMEDIATE で開始する
+	// ON DELETE CASCADE が働くよう、外部キー制約も有効にする
+	const params = "_txlock=immediate&_foreign_keys=1"
+
+	dsn := path + "?" + params
+	if strings.Contains(path, "?") {
+		dsn = path + "&" + params
+	}
+
+	return sql.Open("sqlite3", dsn)
+}
+
+// HasFTS5 reports whether the full-text search index of todos is available:
+// FTS5 is compiled in and the index is created.
+func HasFTS5(db *sql.DB) (bool, error) {
+	const query = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'todos_fts'`
+
+	enabled, err := fts5Compiled(db)
+	if err != nil || !enabled {
+		return false, err
+	}
+
+	var n int
+	if err := db.QueryRow(query).Scan(&n); err != nil {
+		return false, err
+	}
+	return n > 0, nil
+}
+
+// fts5Compiled reports whether the SQLite library is built with FTS5.
+func fts5Compiled(db *sql.DB) (bool, error) {
+	const query = `SELECT sqlite_compileoption_used('ENABLE_FTS5')`
+
+	var enabled bool
+	if err := db.QueryRow(query).Scan(&enabled); err != nil {
+		return false, err
+	}
+	return enabled, nil
+}
+
+// setupFTS5 creates the full-text search index if FTS5 is compiled in, and
+// builds it from the existing todos unless its triggers have kept it up to
+// date. Without FTS5, it drops the triggers left by a build with FTS5.
+func setupFTS5(db *sql.DB) error {
+	const (
+		triggers = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'trigger' AND name IN
+			('trigger_todos_fts_insert', 'trigger_todos_fts_delete', 'trigger_todos_fts_update')`
+		drop = `DROP TRIGGER IF EXISTS trigger_todos_fts_insert;
+			DROP TRIGGER IF EXISTS trigger_todos_fts_delete;
+			DROP TRIGGER IF EXISTS trigger_todos_fts_update;`
+		rebuild = `INSERT INTO todos_fts(todos_fts) VALUES ('rebuild')`
+	)
+
+	enabled, err := fts5Compiled(db)
+	if err != nil {
+		return err
+	}
+
+	var n int
+	if err := db.QueryRow(triggers).Scan(&n); err != nil {
+		return err
+	}
+
+	if !enabled {
+		// FTS5 のないビルドではトリガーが todos_fts を更新できず、TODO を書き込めなくなる
+		if n > 0 {
+			_, err := db.Exec(drop)
+			return err
+		}
+		return nil
+	}
+
+	// トリガーがなかった間の変更は索引に反映されていないので、作り直す
+	if _, err := db.Exec(fts5Schema); err != nil {
+		return err
+	}
+	if n < 3 {
+		if _, err := db.Exec(rebuild); err != nil {
+			return err
+		}
+	}
+	return nil
+}
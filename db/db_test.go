@@ -3,6 +3,8 @@ package db_test
 import (
 	"errors"
 	"os"
+	"path/filepath"
+	"strings"
 	"testing"
 
 	"github.com/TechBowl-japan/go-stations/db"
@@ -44,3 +46,169 @@ func TestNewDB(t *testing.T) {
 		})
 	}
 }
+
+func TestMigrate(t *testing.T) {
+	t.Parallel()
+
+	const legacySchema = `
+CREATE TABLE todos (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  subject     TEXT     NOT NULL,
+  description TEXT     NOT NULL DEFAULT '',
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(subject <> '')
+);
+INSERT INTO todos(subject) VALUES('legacy');`
+
+	// db/schema.sql に完了状態が加わった後、マイグレーションより前のスキーマ
+	const completionSchema = `
+CREATE TABLE todos (
+  id           INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  subject      TEXT     NOT NULL,
+  description  TEXT     NOT NULL DEFAULT '',
+  completed    BOOLEAN  NOT NULL DEFAULT FALSE,
+  completed_at DATETIME,
+  created_at   DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at   DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(subject <> ''),
+  CHECK((completed = FALSE) = (completed_at IS NULL))
+);
+CREATE TRIGGER trigger_todos_updated_at AFTER UPDATE ON todos
+BEGIN
+  UPDATE todos SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
+INSERT INTO todos(subject) VALUES('open');
+INSERT INTO todos(subject) VALUES('legacy');`
+
+	migrations, err := db.Migrations()
+	if err != nil {
+		t.Fatal("failed to read migrations, err =", err)
+	}
+
+	cases := map[string]struct {
+		setup string
+		// adopted is the number of migrations the setup already has.
+		adopted int
+	}{
+		"Empty database":      {setup: ""},
+		"Legacy database":     {setup: legacySchema},
+		"Completion database": {setup: completionSchema, adopted: 2},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			d, err := db.Open(filepath.Join(t.TempDir(), "migrate_test.db"))
+			if err != nil {
+				t.Fatal("failed to open db, err =", err)
+			}
+			defer d.Close()
+
+			if c.setup != "" {
+				if _, err := d.Exec(c.setup); err != nil {
+					t.Fatal("failed to set up db, err =", err)
+				}
+			}
+
+			pending, err := db.Migrate(d, true)
+			if err != nil {
+				t.Fatal("failed to dry-run migrations, err =", err)
+			}
+			if len(pending) != len(migrations)-c.adopted {
+				t.Errorf("unexpected pending migrations, given = %d, expected = %d", len(pending), len(migrations)-c.adopted)
+			}
+
+			statuses, err := db.MigrationStatuses(d)
+			if err != nil {
+				t.Fatal("failed to read statuses, err =", err)
+			}
+			for _, s := range statuses {
+				if s.AppliedAt != nil {
+					t.Errorf("migration %d is applied by dry-run", s.Version)
+				}
+			}
+
+			if _, err := db.Migrate(d, false); err != nil {
+				t.Fatal("failed to migrate, err =", err)
+			}
+
+			pending, err = db.Migrate(d, false)
+			if err != nil {
+				t.Fatal("failed to migrate again, err =", err)
+			}
+			if len(pending) != 0 {
+				t.Errorf("unexpected pending migrations after migrate, given = %d, expected = 0", len(pending))
+			}
+
+			// 追加されたカラムが既存の行でも使えること
+			if _, err := d.Exec(`INSERT INTO todos(subject, completed, completed_at) VALUES('new', TRUE, DATETIME('now'))`); err != nil {
+				t.Error("failed to use migrated schema, err =", err)
+			}
+			var n int
+			if err := d.QueryRow(`SELECT COUNT(*) FROM todos WHERE completed = FALSE`).Scan(&n); err != nil {
+				t.Error("failed to read migrated rows, err =", err)
+			} else if want := strings.Count(c.setup, "INSERT"); n != want {
+				t.Errorf("unexpected open todos, given = %d, expected = %d", n, want)
+			}
+		})
+	}
+}
+
+// TestNewDBFTS5Triggers checks that a database keeps working when it is
+// opened alternately by builds with and without FTS5.
+func TestNewDBFTS5Triggers(t *testing.T) {
+	t.Parallel()
+
+	const (
+		leftover = `CREATE TRIGGER trigger_todos_fts_insert AFTER INSERT ON todos
+BEGIN
+  INSERT INTO todos_fts(rowid, subject, description) VALUES (NEW.id, NEW.subject, NEW.description);
+END`
+		drop = `DROP TRIGGER trigger_todos_fts_insert;
+DROP TRIGGER trigger_todos_fts_delete;
+DROP TRIGGER trigger_todos_fts_update;`
+		search = `SELECT COUNT(*) FROM todos_fts WHERE todos_fts MATCH 'unindexed'`
+	)
+
+	path := filepath.Join(t.TempDir(), "fts5_test.db")
+	d, err := db.NewDB(path)
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	fts5, err := db.HasFTS5(d)
+	if err != nil {
+		t.Fatal("failed to check FTS5, err =", err)
+	}
+
+	// もう一方のビルドでデータベースを開いたときの状態を作る
+	if fts5 {
+		if _, err := d.Exec(drop); err != nil {
+			t.Fatal("failed to drop triggers, err =", err)
+		}
+		if _, err := d.Exec(`INSERT INTO todos(subject) VALUES('unindexed')`); err != nil {
+			t.Fatal("failed to insert todo, err =", err)
+		}
+	} else if _, err := d.Exec(leftover); err != nil {
+		t.Fatal("failed to create trigger, err =", err)
+	}
+	d.Close()
+
+	d, err = db.NewDB(path)
+	if err != nil {
+		t.Fatal("failed to reopen db, err =", err)
+	}
+	defer d.Close()
+
+	if _, err := d.Exec(`INSERT INTO todos(subject) VALUES('new')`); err != nil {
+		t.Error("failed to insert todo, err =", err)
+	}
+	if fts5 {
+		var n int
+		if err := d.QueryRow(search).Scan(&n); err != nil || n != 1 {
+			t.Errorf("todo written without triggers is not indexed, n = %d, err = %v", n, err)
+		}
+	}
+}
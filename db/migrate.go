@@ -0,0 +1,291 @@
+package db
+
+import (
+	"context"
+	"database/sql"
+	"embed"
+	"fmt"
+	"path"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// migrationFS holds the forward migrations named "<version>_<name>.sql".
+// Once released, a migration must never be edited; add a new one instead.
+//go:embed migrations/*.sql
+var migrationFS embed.FS
+
+// A Migration is a forward schema change embedded from the migrations directory.
+type Migration struct {
+	Version int
+	Name    string
+	SQL     string
+}
+
+// A MigrationStatus reports whether a migration has been applied to a database.
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// Migrations returns the embedded migrations ordered by version.
+func Migrations() ([]Migration, error) {
+	files, err := migrationFS.ReadDir("migrations")
+	if err != nil {
+		return nil, err
+	}
+
+	migrations := make([]Migration, 0, len(files))
+	for _, f := range files {
+		base := strings.TrimSuffix(f.Name(), ".sql")
+		i := strings.Index(base, "_")
+		if i < 0 {
+			return nil, fmt.Errorf("invalid migration file name %q", f.Name())
+		}
+		version, err := strconv.Atoi(base[:i])
+		if err != nil {
+			return nil, fmt.Errorf("invalid migration file name %q: %w", f.Name(), err)
+		}
+
+		b, err := migrationFS.ReadFile(path.Join("migrations", f.Name()))
+		if err != nil {
+			return nil, err
+		}
+		migrations = append(migrations, Migration{Version: version, Name: base[i+1:], SQL: string(b)})
+	}
+
+	sort.Slice(migrations, func(i, j int) bool { return migrations[i].Version < migrations[j].Version })
+	for i := 1; i < len(migrations); i++ {
+		if migrations[i].Version == migrations[i-1].Version {
+			return nil, fmt.Errorf("duplicate migration version %d", migrations[i].Version)
+		}
+	}
+	return migrations, nil
+}
+
+// MigrationStatuses reports which of the embedded migrations have been applied to db.
+func MigrationStatuses(db *sql.DB) ([]MigrationStatus, error) {
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(db)
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, 0, len(migrations))
+	for _, m := range migrations {
+		status := MigrationStatus{Migration: m}
+		if at, ok := applied[m.Version]; ok {
+			status.AppliedAt = &at
+		}
+		statuses = append(statuses, status)
+	}
+	return statuses, nil
+}
+
+// Migrate applies the pending migrations to db in a single transaction, so
+// either all of them are applied or none. It returns the pending migrations;
+// with dryRun they are only reported and db is left untouched.
+//
+// A database created before the migrations, whose schema_migrations is
+// missing, may already have the schema of some of them; see legacyVersion.
+// Those migrations are recorded as applied without running them.
+//
+// The migrations run with foreign key enforcement off, so that they can
+// rebuild a table referenced by others as SQLite documents for schema changes
+// ALTER TABLE cannot make, and the foreign keys are checked before commit.
+func Migrate(db *sql.DB, dryRun bool) ([]Migration, error) {
+	const (
+		createTable = `CREATE TABLE IF NOT EXISTS schema_migrations (
+  version    INTEGER  NOT NULL PRIMARY KEY,
+  name       TEXT     NOT NULL,
+  applied_at DATETIME NOT NULL
+)`
+		insert = `INSERT INTO schema_migrations(version, name, applied_at) VALUES(?, ?, ?)`
+	)
+
+	migrations, err := Migrations()
+	if err != nil {
+		return nil, err
+	}
+
+	// PRAGMA foreign_keys はトランザクションの外でしか変えられないので、接続を固定する
+	ctx := context.Background()
+	conn, err := db.Conn(ctx)
+	if err != nil {
+		return nil, err
+	}
+	defer conn.Close()
+
+	var foreignKeys bool
+	if err := conn.QueryRowContext(ctx, `PRAGMA foreign_keys`).Scan(&foreignKeys); err != nil {
+		return nil, err
+	}
+	if foreignKeys {
+		if _, err := conn.ExecContext(ctx, `PRAGMA foreign_keys = OFF`); err != nil {
+			return nil, err
+		}
+		defer conn.ExecContext(ctx, `PRAGMA foreign_keys = ON`)
+	}
+
+	tx, err := conn.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.Exec(createTable); err != nil {
+		return nil, err
+	}
+
+	applied, err := appliedMigrations(tx)
+	if err != nil {
+		return nil, err
+	}
+
+	// バイナリより新しいスキーマのデータベースは扱えない
+	latest := 0
+	if len(migrations) > 0 {
+		latest = migrations[len(migrations)-1].Version
+	}
+	for version := range applied {
+		if version > latest {
+			return nil, fmt.Errorf("database schema version %d is newer than the latest known migration %d", version, latest)
+		}
+	}
+
+	baseline := 0
+	if len(applied) == 0 {
+		if baseline, err = legacyVersion(tx); err != nil {
+			return nil, err
+		}
+	}
+
+	var pending, adopted []Migration
+	for _, m := range migrations {
+		switch _, ok := applied[m.Version]; {
+		case ok:
+		case m.Version <= baseline:
+			adopted = append(adopted, m)
+		default:
+			pending = append(pending, m)
+		}
+	}
+
+	if dryRun || len(pending) == 0 && len(adopted) == 0 {
+		return pending, nil
+	}
+
+	now := time.Now().UTC()
+	for _, m := range adopted {
+		if _, err := tx.Exec(insert, m.Version, m.Name, now); err != nil {
+			return nil, err
+		}
+	}
+	for _, m := range pending {
+		if _, err := tx.Exec(m.SQL); err != nil {
+			return nil, fmt.Errorf("migration %04d_%s: %w", m.Version, m.Name, err)
+		}
+		if _, err := tx.Exec(insert, m.Version, m.Name, now); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := checkForeignKeys(tx); err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+	return pending, nil
+}
+
+// legacyVersion returns the latest migration whose schema a database created
+// before the migrations already has. Such a database was created by
+// db/schema.sql: its todos have the columns of 0002 if it was written after
+// completion was added, and only those of 0001 otherwise, which 0001 leaves
+// as they are.
+func legacyVersion(q querier) (int, error) {
+	const completed = `SELECT COUNT(*) FROM pragma_table_info('todos') WHERE name = 'completed'`
+
+	var n int
+	if err := q.QueryRow(completed).Scan(&n); err != nil {
+		return 0, err
+	}
+	if n > 0 {
+		return 2, nil
+	}
+	return 0, nil
+}
+
+// checkForeignKeys returns an error if a row references a row that does not
+// exist, as the migrations run without the foreign keys enforced.
+func checkForeignKeys(q querier) error {
+	rows, err := q.Query(`PRAGMA foreign_key_check`)
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	if rows.Next() {
+		var (
+			table, parent string
+			rowid         sql.NullInt64
+			fkid          int
+		)
+		if err := rows.Scan(&table, &rowid, &parent, &fkid); err != nil {
+			return err
+		}
+		return fmt.Errorf("foreign key violation: row %d of %s references a missing row of %s", rowid.Int64, table, parent)
+	}
+	return rows.Err()
+}
+
+// A querier is implemented by both *sql.DB and *sql.Tx.
+type querier interface {
+	Query(query string, args ...interface{}) (*sql.Rows, error)
+	QueryRow(query string, args ...interface{}) *sql.Row
+}
+
+// appliedMigrations returns the applied time of each applied migration version.
+// A database without schema_migrations has no migrations applied.
+func appliedMigrations(q querier) (map[int]time.Time, error) {
+	const (
+		exists = `SELECT COUNT(*) FROM sqlite_master WHERE type = 'table' AND name = 'schema_migrations'`
+		read   = `SELECT version, applied_at FROM schema_migrations`
+	)
+
+	var n int
+	if err := q.QueryRow(exists).Scan(&n); err != nil {
+		return nil, err
+	}
+
+	applied := map[int]time.Time{}
+	if n == 0 {
+		return applied, nil
+	}
+
+	rows, err := q.Query(read)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var (
+			version int
+			at      time.Time
+		)
+		if err := rows.Scan(&version, &at); err != nil {
+			return nil, err
+		}
+		applied[version] = at
+	}
+	return applied, rows.Err()
+}
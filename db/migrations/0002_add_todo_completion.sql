@@ -0,0 +1,2 @@
+ALTER TABLE todos ADD COLUMN completed BOOLEAN NOT NULL DEFAULT FALSE;
+ALTER TABLE todos ADD COLUMN completed_at DATETIME CHECK((completed = FALSE) = (completed_at IS NULL));
@@ -0,0 +1,3 @@
+-- due_at is always stored in UTC so that it compares correctly with bound time.Time values.
+ALTER TABLE todos ADD COLUMN due_at DATETIME;
+CREATE INDEX todos_due_at ON todos(due_at);
@@ -0,0 +1,13 @@
+CREATE TABLE tags (
+  id   INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  name TEXT    NOT NULL UNIQUE COLLATE NOCASE,
+  CHECK(name <> '')
+);
+
+CREATE TABLE todo_tags (
+  todo_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+  tag_id  INTEGER NOT NULL REFERENCES tags(id) ON DELETE CASCADE,
+  PRIMARY KEY (todo_id, tag_id)
+);
+
+CREATE INDEX todo_tags_tag_id ON todo_tags(tag_id);
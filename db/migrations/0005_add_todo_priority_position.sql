@@ -0,0 +1,26 @@
+-- priority is 0 (none), 1 (low), 2 (medium) or 3 (high).
+ALTER TABLE todos ADD COLUMN priority INTEGER NOT NULL DEFAULT 0 CHECK(priority BETWEEN 0 AND 3);
+
+-- position is the fractional index of the manual order, see service/position.go.
+-- Existing TODOs keep their newest-first order with 8 digit integer positions.
+-- The updated_at trigger is suspended so that the backfill does not touch updated_at.
+ALTER TABLE todos ADD COLUMN position TEXT NOT NULL DEFAULT '';
+
+DROP TRIGGER trigger_todos_updated_at;
+
+UPDATE todos SET position = 'h' || printf('%08d', (SELECT MAX(id) FROM todos) - id);
+
+CREATE TRIGGER trigger_todos_updated_at AFTER UPDATE ON todos
+BEGIN
+  UPDATE todos SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
+
+-- Rows inserted without a position, i.e. not through TODOService, are placed at
+-- the end of the manual order with integer positions far beyond the others.
+CREATE TRIGGER trigger_todos_position AFTER INSERT ON todos WHEN NEW.position = ''
+BEGIN
+  UPDATE todos SET position = 'y' || printf('%025d', NEW.id) WHERE id == NEW.id;
+END;
+
+CREATE UNIQUE INDEX todos_position ON todos(position);
+CREATE INDEX todos_priority ON todos(priority, id);
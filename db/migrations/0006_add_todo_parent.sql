@@ -0,0 +1,5 @@
+-- parent_id makes a TODO a subtask of another. Deleting a TODO with subtasks
+-- is handled by TODOService according to the requested policy, so the
+-- foreign key only guards against dangling references.
+ALTER TABLE todos ADD COLUMN parent_id INTEGER REFERENCES todos(id);
+CREATE INDEX todos_parent_id ON todos(parent_id);
@@ -0,0 +1,10 @@
+-- A row of todo_dependencies means the TODO blocked_id cannot start until the
+-- TODO blocker_id is completed. TODOService keeps the dependencies acyclic.
+CREATE TABLE todo_dependencies (
+  blocker_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+  blocked_id INTEGER NOT NULL REFERENCES todos(id) ON DELETE CASCADE,
+  PRIMARY KEY (blocked_id, blocker_id),
+  CHECK(blocker_id <> blocked_id)
+);
+
+CREATE INDEX todo_dependencies_blocker_id ON todo_dependencies(blocker_id);
@@ -0,0 +1,18 @@
+-- Lists group TODOs into projects. TODOs without list_id belong to no list.
+-- An archived list keeps its TODOs but hides them from the default queries.
+CREATE TABLE lists (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  name        TEXT     NOT NULL UNIQUE COLLATE NOCASE,
+  archived_at DATETIME,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(name <> '')
+);
+
+CREATE TRIGGER trigger_lists_updated_at AFTER UPDATE ON lists
+BEGIN
+  UPDATE lists SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
+
+ALTER TABLE todos ADD COLUMN list_id INTEGER REFERENCES lists(id);
+CREATE INDEX todos_list_id ON todos(list_id);
@@ -0,0 +1,4 @@
+-- Deleting a TODO moves it to the trash by setting deleted_at. Trashed TODOs
+-- are hidden everywhere but the trash until restored or purged for good.
+ALTER TABLE todos ADD COLUMN deleted_at DATETIME;
+CREATE INDEX todos_deleted_at ON todos(deleted_at);
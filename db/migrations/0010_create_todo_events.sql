@@ -0,0 +1,25 @@
+-- todo_events is the append-only history of every change to a TODO. Each
+-- event keeps the TODO as JSON before and after the change; the event id is
+-- the revision a TODO can be reverted to. Events outlive purged TODOs, so
+-- todo_id is deliberately not a foreign key.
+CREATE TABLE todo_events (
+  id         INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  todo_id    INTEGER  NOT NULL,
+  action     TEXT     NOT NULL,
+  old_value  TEXT,
+  new_value  TEXT,
+  actor      TEXT,
+  created_at DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX todo_events_todo_id ON todo_events(todo_id, id);
+
+CREATE TRIGGER trigger_todo_events_no_update BEFORE UPDATE ON todo_events
+BEGIN
+  SELECT RAISE(ABORT, 'todo_events is append-only');
+END;
+
+CREATE TRIGGER trigger_todo_events_no_delete BEFORE DELETE ON todo_events
+BEGIN
+  SELECT RAISE(ABORT, 'todo_events is append-only');
+END;
@@ -0,0 +1,21 @@
+-- todo_undos holds the tokens that undo a delete or an update of TODOs until
+-- they expire. A token undoes the events first_revision to last_revision of
+-- todo_events by restoring the TODOs as they were before them.
+CREATE TABLE todo_undos (
+  token          TEXT     NOT NULL PRIMARY KEY,
+  first_revision INTEGER  NOT NULL,
+  last_revision  INTEGER  NOT NULL,
+  expires_at     DATETIME NOT NULL,
+  created_at     DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX todo_undos_expires_at ON todo_undos(expires_at);
+
+-- Undoing restores the original updated_at, which moves it backwards; only
+-- updates that do not do so touch updated_at.
+DROP TRIGGER trigger_todos_updated_at;
+
+CREATE TRIGGER trigger_todos_updated_at AFTER UPDATE ON todos WHEN NEW.updated_at >= OLD.updated_at
+BEGIN
+  UPDATE todos SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
@@ -0,0 +1,7 @@
+-- A recurring TODO carries an RFC 5545 RRULE, and completing it creates the
+-- next occurrence of its series. series_id is the id of the first TODO of the
+-- series, NULL for the first itself, and occurrence counts them from 1.
+ALTER TABLE todos ADD COLUMN rrule TEXT;
+ALTER TABLE todos ADD COLUMN series_id INTEGER;
+ALTER TABLE todos ADD COLUMN occurrence INTEGER NOT NULL DEFAULT 1;
+CREATE INDEX todos_series ON todos(series_id, occurrence);
@@ -0,0 +1,22 @@
+-- users are the accounts TODOs belong to. A TODO without owner_id belongs to
+-- no user and is only visible to requests made without one, as every TODO
+-- was before users existed. The history and undo tokens of a TODO carry the
+-- owner too, so that they outlive a purged TODO without leaking to others.
+CREATE TABLE users (
+  id         INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  name       TEXT     NOT NULL UNIQUE COLLATE NOCASE,
+  created_at DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(name <> '')
+);
+
+CREATE TRIGGER trigger_users_updated_at AFTER UPDATE ON users
+BEGIN
+  UPDATE users SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
+
+ALTER TABLE todos ADD COLUMN owner_id INTEGER REFERENCES users(id);
+CREATE INDEX todos_owner_id ON todos(owner_id);
+
+ALTER TABLE todo_events ADD COLUMN owner_id INTEGER;
+ALTER TABLE todo_undos ADD COLUMN owner_id INTEGER;
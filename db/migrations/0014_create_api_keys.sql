@@ -0,0 +1,24 @@
+-- api_keys lets scripts act as a user without a password. Only the SHA-256
+-- of a key is stored; prefix is its first characters, kept to tell keys
+-- apart. scopes is a space-separated list such as "todos:read todos:write".
+-- A revoked key stays listed but no longer authenticates.
+CREATE TABLE api_keys (
+  id           INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  user_id      INTEGER  NOT NULL REFERENCES users(id),
+  name         TEXT     NOT NULL DEFAULT '',
+  prefix       TEXT     NOT NULL,
+  key_hash     TEXT     NOT NULL UNIQUE,
+  scopes       TEXT     NOT NULL,
+  last_used_at DATETIME,
+  revoked_at   DATETIME,
+  created_at   DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at   DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX api_keys_user_id ON api_keys(user_id);
+
+-- Using a key only touches last_used_at, which is not a change to the key.
+CREATE TRIGGER trigger_api_keys_updated_at AFTER UPDATE OF name, key_hash, scopes, revoked_at ON api_keys
+BEGIN
+  UPDATE api_keys SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
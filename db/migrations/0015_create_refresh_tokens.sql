@@ -0,0 +1,17 @@
+-- refresh_tokens keeps the sessions of the JWT access tokens. Only the
+-- SHA-256 of a token is stored. A token is used once: refreshing marks it
+-- used_at and issues the next token of the same family, and presenting a used
+-- token again revokes the whole family, as it means the token has leaked.
+CREATE TABLE refresh_tokens (
+  id         INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  user_id    INTEGER  NOT NULL REFERENCES users(id),
+  family     TEXT     NOT NULL,
+  token_hash TEXT     NOT NULL UNIQUE,
+  expires_at DATETIME NOT NULL,
+  used_at    DATETIME,
+  revoked_at DATETIME,
+  created_at DATETIME NOT NULL DEFAULT (DATETIME('now'))
+);
+
+CREATE INDEX refresh_tokens_family ON refresh_tokens(family);
+CREATE INDEX refresh_tokens_expires_at ON refresh_tokens(expires_at);
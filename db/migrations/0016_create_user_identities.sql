@@ -0,0 +1,13 @@
+-- user_identities link the accounts of OpenID Connect providers to users. An
+-- account is identified by its issuer and subject, never by a name or email
+-- the provider lets its users change.
+CREATE TABLE user_identities (
+  id         INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  user_id    INTEGER  NOT NULL REFERENCES users(id),
+  issuer     TEXT     NOT NULL,
+  subject    TEXT     NOT NULL,
+  created_at DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  UNIQUE(issuer, subject)
+);
+
+CREATE INDEX user_identities_user_id ON user_identities(user_id);
@@ -0,0 +1,67 @@
+-- Lists and tags belong to the owner of their TODOs, and their names are
+-- unique per owner. A list or tag shared by TODOs of several owners is split
+-- into one for each owner, the one of its oldest TODO keeping the id, and one
+-- without TODOs belongs to no user. SQLite cannot change a UNIQUE constraint,
+-- so both tables are rebuilt.
+CREATE TABLE lists_new (
+  id          INTEGER  NOT NULL PRIMARY KEY AUTOINCREMENT,
+  owner_id    INTEGER  REFERENCES users(id),
+  name        TEXT     NOT NULL COLLATE NOCASE,
+  archived_at DATETIME,
+  created_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  updated_at  DATETIME NOT NULL DEFAULT (DATETIME('now')),
+  CHECK(name <> '')
+);
+
+INSERT INTO lists_new(id, owner_id, name, archived_at, created_at, updated_at)
+  SELECT id, (SELECT owner_id FROM todos WHERE list_id = lists.id ORDER BY id LIMIT 1),
+    name, archived_at, created_at, updated_at
+  FROM lists;
+
+INSERT INTO lists_new(owner_id, name, archived_at, created_at, updated_at)
+  SELECT DISTINCT todos.owner_id, lists.name, lists.archived_at, lists.created_at, lists.updated_at
+  FROM lists JOIN todos ON todos.list_id = lists.id
+  WHERE todos.owner_id IS NOT (SELECT owner_id FROM lists_new WHERE id = lists.id);
+
+UPDATE todos SET list_id = (
+  SELECT lists_new.id FROM lists_new JOIN lists ON lists.name = lists_new.name
+  WHERE lists.id = todos.list_id AND lists_new.owner_id IS todos.owner_id
+) WHERE list_id IS NOT NULL AND owner_id IS NOT (SELECT owner_id FROM lists_new WHERE id = todos.list_id);
+
+DROP TABLE lists;
+ALTER TABLE lists_new RENAME TO lists;
+CREATE UNIQUE INDEX lists_owner_id_name ON lists(IFNULL(owner_id, 0), name);
+
+CREATE TRIGGER trigger_lists_updated_at AFTER UPDATE ON lists
+BEGIN
+  UPDATE lists SET updated_at = DATETIME('now') WHERE id == NEW.id;
+END;
+
+CREATE TABLE tags_new (
+  id       INTEGER NOT NULL PRIMARY KEY AUTOINCREMENT,
+  owner_id INTEGER REFERENCES users(id),
+  name     TEXT    NOT NULL COLLATE NOCASE,
+  CHECK(name <> '')
+);
+
+INSERT INTO tags_new(id, owner_id, name)
+  SELECT id, (
+    SELECT todos.owner_id FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id
+    WHERE todo_tags.tag_id = tags.id ORDER BY todos.id LIMIT 1
+  ), name
+  FROM tags;
+
+INSERT INTO tags_new(owner_id, name)
+  SELECT DISTINCT todos.owner_id, tags.name
+  FROM tags JOIN todo_tags ON todo_tags.tag_id = tags.id JOIN todos ON todos.id = todo_tags.todo_id
+  WHERE todos.owner_id IS NOT (SELECT owner_id FROM tags_new WHERE id = tags.id);
+
+UPDATE todo_tags SET tag_id = (
+  SELECT tags_new.id FROM tags_new JOIN tags ON tags.name = tags_new.name
+  WHERE tags.id = todo_tags.tag_id
+    AND tags_new.owner_id IS (SELECT owner_id FROM todos WHERE id = todo_tags.todo_id)
+) WHERE (SELECT owner_id FROM todos WHERE id = todo_tags.todo_id) IS NOT (SELECT owner_id FROM tags_new WHERE id = todo_tags.tag_id);
+
+DROP TABLE tags;
+ALTER TABLE tags_new RENAME TO tags;
+CREATE UNIQUE INDEX tags_owner_id_name ON tags(IFNULL(owner_id, 0), name);
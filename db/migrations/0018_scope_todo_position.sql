@@ -0,0 +1,4 @@
+-- Each owner has a manual order of its own, so positions are unique per owner
+-- and TODOs of different owners may share a position.
+DROP INDEX todos_position;
+CREATE UNIQUE INDEX todos_position ON todos(IFNULL(owner_id, 0), position);
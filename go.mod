@@ -3,7 +3,8 @@ module github.com/TechBowl-japan/go-stations
 go 1.16
 
 require (
-	github.com/google/go-cmp v0.5.9 // indirect
+	github.com/google/go-cmp v0.5.9
 	github.com/jstemmer/go-junit-report v0.9.1
 	github.com/mattn/go-sqlite3 v1.14.7
+	golang.org/x/crypto v0.0.0-20210322153248-0c34fe9e7dc2
 )
@@ -0,0 +1,149 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// An APIKeyHandler implements handling REST endpoints of API keys.
+type APIKeyHandler struct {
+	svc *service.APIKeyService
+}
+
+// NewAPIKeyHandler returns APIKeyHandler based http.Handler.
+func NewAPIKeyHandler(svc *service.APIKeyService) *APIKeyHandler {
+	return &APIKeyHandler{
+		svc: svc,
+	}
+}
+
+// Create handles the endpoint that creates the APIKey.
+func (h *APIKeyHandler) Create(ctx context.Context, req *model.CreateAPIKeyRequest) (*model.CreateAPIKeyResponse, error) {
+	apiKey, key, err := h.svc.CreateAPIKey(ctx, req.Name, req.Scopes)
+	if err != nil {
+		return nil, err
+	}
+	return &model.CreateAPIKeyResponse{APIKey: apiKey, Key: key}, nil
+}
+
+// Read handles the endpoint that reads the APIKeys.
+func (h *APIKeyHandler) Read(ctx context.Context, req *model.ReadAPIKeysRequest) (*model.ReadAPIKeysResponse, error) {
+	keys, err := h.svc.ReadAPIKeys(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ReadAPIKeysResponse{APIKeys: keys}, nil
+}
+
+// Rotate handles the endpoint that replaces the key of the APIKey.
+func (h *APIKeyHandler) Rotate(ctx context.Context, req *model.RotateAPIKeyRequest) (*model.RotateAPIKeyResponse, error) {
+	apiKey, key, err := h.svc.RotateAPIKey(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RotateAPIKeyResponse{APIKey: apiKey, Key: key}, nil
+}
+
+// Revoke handles the endpoint that revokes the APIKey.
+func (h *APIKeyHandler) Revoke(ctx context.Context, req *model.RevokeAPIKeyRequest) (*model.RevokeAPIKeyResponse, error) {
+	apiKey, err := h.svc.RevokeAPIKey(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RevokeAPIKeyResponse{APIKey: apiKey}, nil
+}
+
+// ServeHTTP implements http.Handler interface.
+// It serves the collection "/api-keys", the items "/api-keys/{id}", which
+// revokes the APIKey with DELETE, and "/api-keys/{id}/rotate".
+func (h *APIKeyHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/api-keys")
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			h.handleRead(w, r)
+		case http.MethodPost:
+			h.handleCreate(w, r)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil || id <= 0 || len(segments) > 2 || len(segments) == 2 && segments[1] != "rotate" {
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+
+	switch {
+	case len(segments) == 2 && r.Method == http.MethodPost:
+		h.handleRotate(w, r, id)
+	case len(segments) == 1 && r.Method == http.MethodDelete:
+		h.handleRevoke(w, r, id)
+	default:
+		WriteError(w, r, errMethodNotAllowed(r))
+	}
+}
+
+func (h *APIKeyHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req model.CreateAPIKeyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Create(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	// 鍵は一度しか返さないので、キャッシュさせない
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *APIKeyHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.Read(r.Context(), &model.ReadAPIKeysRequest{})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *APIKeyHandler) handleRotate(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Rotate(r.Context(), &model.RotateAPIKeyRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *APIKeyHandler) handleRevoke(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Revoke(r.Context(), &model.RevokeAPIKeyRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
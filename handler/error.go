@@ -0,0 +1,215 @@
+package handler
+
+import (
+	"encoding/json"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/requestid"
+	"github.com/mattn/go-sqlite3"
+)
+
+// problemMediaType is the media type of RFC 7807 problem details.
+const problemMediaType = "application/problem+json"
+
+// Problem types identify the kind of a failure independently of its status code.
+// Failures that mean no more than their status code use "about:blank".
+const (
+	problemTypeMalformedRequest   = "/problems/malformed-request"
+	problemTypeValidation         = "/problems/validation-error"
+	problemTypeNotFound           = "/problems/not-found"
+	problemTypeUnauthorized       = "/problems/unauthorized"
+	problemTypeForbidden          = "/problems/forbidden"
+	problemTypeConflict           = "/problems/conflict"
+	problemTypePreconditionFailed = "/problems/precondition-failed"
+	problemTypeSearchUnavailable  = "/problems/search-unavailable"
+	problemTypeInternal           = "/problems/internal-error"
+)
+
+// WriteError writes err as an application/problem+json response carrying the request ID.
+// Errors that are not one of the model errors are reported as internal errors
+// without exposing their message. The cause of an ErrInternal is logged with
+// the request ID instead.
+func WriteError(w http.ResponseWriter, r *http.Request, err error) {
+	var ierr *model.ErrInternal
+	if errors.As(err, &ierr) {
+		log.Printf("request_id=%s internal error: %v\n", requestid.FromContext(r.Context()), ierr.Err)
+	}
+
+	p := newProblem(err)
+	p.Instance = r.URL.RequestURI()
+	p.RequestID = requestid.FromContext(r.Context())
+
+	w.Header().Set("Content-Type", problemMediaType)
+	w.WriteHeader(p.Status)
+	json.NewEncoder(w).Encode(p)
+}
+
+// newProblem returns the problem details that report err.
+func newProblem(err error) *model.Problem {
+	var (
+		herr *httpError
+		verr *model.ErrValidation
+		cerr *model.ErrConflict
+		ferr *model.ErrForbidden
+		serr sqlite3.Error
+	)
+	switch {
+	case errors.As(err, &herr):
+		typ := herr.typ
+		if typ == "" {
+			typ = "about:blank"
+		}
+		return &model.Problem{
+			Type:   typ,
+			Title:  http.StatusText(herr.status),
+			Status: herr.status,
+			Detail: herr.detail,
+		}
+	case errors.As(err, &verr):
+		return &model.Problem{
+			Type:          problemTypeValidation,
+			Title:         "Validation Failed",
+			Status:        http.StatusBadRequest,
+			Detail:        "One or more request fields are invalid.",
+			InvalidParams: verr.Fields,
+		}
+	case errors.Is(err, &model.ErrNotFound{}):
+		return &model.Problem{
+			Type:   problemTypeNotFound,
+			Title:  "Not Found",
+			Status: http.StatusNotFound,
+			Detail: "The requested resource does not exist.",
+		}
+	case errors.Is(err, &model.ErrUnauthorized{}):
+		return &model.Problem{
+			Type:   problemTypeUnauthorized,
+			Title:  "Unauthorized",
+			Status: http.StatusUnauthorized,
+			Detail: "The request requires valid credentials.",
+		}
+	case errors.As(err, &ferr):
+		return &model.Problem{
+			Type:   problemTypeForbidden,
+			Title:  "Forbidden",
+			Status: http.StatusForbidden,
+			Detail: ferr.Reason,
+		}
+	case errors.As(err, &cerr):
+		return &model.Problem{
+			Type:   problemTypeConflict,
+			Title:  "Conflict",
+			Status: http.StatusConflict,
+			Detail: cerr.Reason,
+		}
+	case errors.Is(err, &model.ErrPreconditionFailed{}):
+		return &model.Problem{
+			Type:   problemTypePreconditionFailed,
+			Title:  "Precondition Failed",
+			Status: http.StatusPreconditionFailed,
+			Detail: "The resource does not match the If-Match header.",
+		}
+	case errors.Is(err, &model.ErrSearchUnavailable{}):
+		return &model.Problem{
+			Type:   problemTypeSearchUnavailable,
+			Title:  "Search Unavailable",
+			Status: http.StatusNotImplemented,
+			Detail: "Full-text search is not supported by this server.",
+		}
+	case errors.As(err, &serr) && serr.Code == sqlite3.ErrConstraint:
+		// 入力の検証をすり抜けた制約違反は、一意性なら競合、それ以外は不正な値として扱う
+		switch serr.ExtendedCode {
+		case sqlite3.ErrConstraintUnique, sqlite3.ErrConstraintPrimaryKey:
+			return newProblem(&model.ErrConflict{Reason: "The resource already exists."})
+		}
+		return &model.Problem{
+			Type:   problemTypeValidation,
+			Title:  "Validation Failed",
+			Status: http.StatusBadRequest,
+			Detail: "The request violates a constraint of the resource.",
+		}
+	default:
+		return &model.Problem{
+			Type:   problemTypeInternal,
+			Title:  "Internal Server Error",
+			Status: http.StatusInternalServerError,
+			Detail: "The server failed to process the request.",
+		}
+	}
+}
+
+// An httpError reports a request rejected at the HTTP level before it reaches
+// the service, e.g. an unsupported method or a body that is not JSON.
+type httpError struct {
+	status int
+	typ    string
+	detail string
+}
+
+func (e *httpError) Error() string {
+	return e.detail
+}
+
+func errMethodNotAllowed(r *http.Request) error {
+	return &httpError{
+		status: http.StatusMethodNotAllowed,
+		detail: r.Method + " is not allowed on " + r.URL.Path + ".",
+	}
+}
+
+func errRouteNotFound(r *http.Request) error {
+	return &httpError{
+		status: http.StatusNotFound,
+		detail: "No resource is served at " + r.URL.Path + ".",
+	}
+}
+
+func errUnsupportedMediaType(want string) error {
+	return &httpError{
+		status: http.StatusUnsupportedMediaType,
+		detail: "The request body must be " + want + ".",
+	}
+}
+
+func errMalformedRequest(detail string) error {
+	return &httpError{
+		status: http.StatusBadRequest,
+		typ:    problemTypeMalformedRequest,
+		detail: detail,
+	}
+}
+
+// invalidParam returns a model.ErrValidation reporting a single invalid field.
+func invalidParam(name, reason string) error {
+	verr := &model.ErrValidation{}
+	verr.Add(name, reason)
+	return verr
+}
+
+// decodeJSON decodes the JSON request body into v. A member of the wrong type
+// is reported as an invalid field, anything else that does not decode as a
+// malformed request.
+func decodeJSON(r *http.Request, v interface{}) error {
+	return decodeError(json.NewDecoder(r.Body).Decode(v))
+}
+
+// decodeError converts the error of decoding a request body as decodeJSON does.
+func decodeError(err error) error {
+	if err == nil {
+		return nil
+	}
+
+	var terr *json.UnmarshalTypeError
+	if errors.As(err, &terr) && terr.Field != "" {
+		return invalidParam(terr.Field, "must be of type "+terr.Type.String())
+	}
+
+	var perr *time.ParseError
+	if errors.As(err, &perr) {
+		return errMalformedRequest("The request body contains an invalid date-time: " + perr.Value + ".")
+	}
+	return errMalformedRequest("The request body is not valid JSON.")
+}
@@ -0,0 +1,229 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A ListHandler implements handling REST endpoints of lists.
+type ListHandler struct {
+	svc *service.ListService
+}
+
+// NewListHandler returns ListHandler based http.Handler.
+func NewListHandler(svc *service.ListService) *ListHandler {
+	return &ListHandler{
+		svc: svc,
+	}
+}
+
+// Create handles the endpoint that creates the List.
+func (h *ListHandler) Create(ctx context.Context, req *model.CreateListRequest) (*model.CreateListResponse, error) {
+	list, err := h.svc.CreateList(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &model.CreateListResponse{List: list}, nil
+}
+
+// Get handles the endpoint that reads a single List.
+func (h *ListHandler) Get(ctx context.Context, req *model.GetListRequest) (*model.GetListResponse, error) {
+	list, err := h.svc.GetList(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GetListResponse{List: list}, nil
+}
+
+// Read handles the endpoint that reads the Lists.
+func (h *ListHandler) Read(ctx context.Context, req *model.ReadListRequest) (*model.ReadListResponse, error) {
+	lists, err := h.svc.ReadLists(ctx, req.IncludeArchived)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.ReadListResponse{
+		Lists: []model.List{},
+	}
+	for _, list := range lists {
+		response.Lists = append(response.Lists, *list)
+	}
+	return response, nil
+}
+
+// Update handles the endpoint that renames the List.
+func (h *ListHandler) Update(ctx context.Context, req *model.UpdateListRequest) (*model.UpdateListResponse, error) {
+	list, err := h.svc.RenameList(ctx, req.ID, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UpdateListResponse{List: list}, nil
+}
+
+// Archive handles the endpoints that archive and unarchive the List.
+func (h *ListHandler) Archive(ctx context.Context, req *model.ArchiveListRequest) (*model.ArchiveListResponse, error) {
+	list, err := h.svc.ArchiveList(ctx, req.ID, req.Archived)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ArchiveListResponse{List: list}, nil
+}
+
+// Delete handles the endpoint that deletes the List.
+func (h *ListHandler) Delete(ctx context.Context, req *model.DeleteListRequest) (*model.DeleteListResponse, error) {
+	if err := h.svc.DeleteList(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	return &model.DeleteListResponse{}, nil
+}
+
+// ServeHTTP implements http.Handler interface.
+// It serves the collection "/lists", the items "/lists/{id}" and
+// "/lists/{id}/archive", which archives the List with POST and unarchives it with DELETE.
+func (h *ListHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/lists")
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			h.handleRead(w, r)
+		case http.MethodPost:
+			h.handleCreate(w, r)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil || id <= 0 || len(segments) > 2 {
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+
+	if len(segments) == 2 {
+		if segments[1] != "archive" {
+			WriteError(w, r, errRouteNotFound(r))
+			return
+		}
+		switch r.Method {
+		case http.MethodPost:
+			h.handleArchive(w, r, id, true)
+		case http.MethodDelete:
+			h.handleArchive(w, r, id, false)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGet(w, r, id)
+	case http.MethodPut:
+		h.handleUpdate(w, r, id)
+	case http.MethodDelete:
+		h.handleDelete(w, r, id)
+	default:
+		WriteError(w, r, errMethodNotAllowed(r))
+	}
+}
+
+func (h *ListHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req model.CreateListRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Create(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *ListHandler) handleGet(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Get(r.Context(), &model.GetListRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *ListHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+	var req model.ReadListRequest
+	if archived := r.URL.Query().Get("include_archived"); archived != "" {
+		b, err := strconv.ParseBool(archived)
+		if err != nil {
+			WriteError(w, r, invalidParam("include_archived", "must be true or false"))
+			return
+		}
+		req.IncludeArchived = b
+	}
+
+	resp, err := h.Read(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *ListHandler) handleUpdate(w http.ResponseWriter, r *http.Request, id int64) {
+	var req model.UpdateListRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	req.ID = id
+
+	resp, err := h.Update(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *ListHandler) handleArchive(w http.ResponseWriter, r *http.Request, id int64, archived bool) {
+	resp, err := h.Archive(r.Context(), &model.ArchiveListRequest{ID: id, Archived: archived})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *ListHandler) handleDelete(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Delete(r.Context(), &model.DeleteListRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
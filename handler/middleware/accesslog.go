@@ -0,0 +1,110 @@
+package middleware
+
+import (
+	"encoding/json"
+	"io"
+	"math/rand"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/requestid"
+)
+
+// An AccessLogEntry is a line of the access log.
+type AccessLogEntry struct {
+	Time       time.Time `json:"time"`
+	Method     string    `json:"method"`
+	Path       string    `json:"path"`
+	Status     int       `json:"status"`
+	Bytes      int64     `json:"bytes"`
+	LatencyMS  float64   `json:"latency_ms"`
+	RemoteAddr string    `json:"remote_addr"`
+	UserAgent  string    `json:"user_agent"`
+	RequestID  string    `json:"request_id,omitempty"`
+}
+
+// An AccessLogConfig configures AccessLog.
+type AccessLogConfig struct {
+	// Out is where the JSON lines are written. It defaults to os.Stderr.
+	Out io.Writer
+
+	// SampleRate is the fraction of requests logged, in (0, 1].
+	// 0 logs every request. Server errors are always logged.
+	SampleRate float64
+}
+
+// AccessLog returns a middleware that writes an AccessLogEntry as a JSON line for each request.
+func AccessLog(h http.Handler, cfg AccessLogConfig) http.Handler {
+	out := cfg.Out
+	if out == nil {
+		out = os.Stderr
+	}
+
+	// 複数のリクエストの行が混ざらないよう書き込みを直列化する
+	var mu sync.Mutex
+	enc := json.NewEncoder(out)
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		start := time.Now()
+		rec := &responseRecorder{ResponseWriter: w}
+
+		h.ServeHTTP(rec, r)
+
+		if rec.status == 0 {
+			rec.status = http.StatusOK
+		}
+		if cfg.SampleRate > 0 && cfg.SampleRate < 1 && rec.status < 500 && rand.Float64() >= cfg.SampleRate {
+			return
+		}
+
+		entry := AccessLogEntry{
+			Time:       start.UTC(),
+			Method:     r.Method,
+			Path:       r.URL.Path,
+			Status:     rec.status,
+			Bytes:      rec.bytes,
+			LatencyMS:  float64(time.Since(start).Microseconds()) / 1000,
+			RemoteAddr: r.RemoteAddr,
+			UserAgent:  r.UserAgent(),
+			RequestID:  requestid.FromContext(r.Context()),
+		}
+
+		mu.Lock()
+		defer mu.Unlock()
+		enc.Encode(entry)
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// A responseRecorder records the status code and the size of the response it writes.
+type responseRecorder struct {
+	http.ResponseWriter
+	status int
+	bytes  int64
+}
+
+func (r *responseRecorder) WriteHeader(status int) {
+	if r.status == 0 {
+		r.status = status
+	}
+	r.ResponseWriter.WriteHeader(status)
+}
+
+func (r *responseRecorder) Write(b []byte) (int, error) {
+	if r.status == 0 {
+		r.status = http.StatusOK
+	}
+	n, err := r.ResponseWriter.Write(b)
+	r.bytes += int64(n)
+	return n, err
+}
+
+// Flush implements http.Flusher if the underlying ResponseWriter does.
+func (r *responseRecorder) Flush() {
+	if f, ok := r.ResponseWriter.(http.Flusher); ok {
+		f.Flush()
+	}
+}
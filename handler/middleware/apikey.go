@@ -0,0 +1,75 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// APIKeyHeader is the HTTP header that carries an API key.
+const APIKeyHeader = "X-API-Key"
+
+// An APIKeyAuthenticator returns the user of an API key and the scopes the
+// key grants. *service.APIKeyService implements it.
+type APIKeyAuthenticator interface {
+	AuthenticateAPIKey(ctx context.Context, key string) (*model.User, []model.Scope, error)
+}
+
+// APIKey returns a middleware that serves the requests carrying an API key in
+// X-API-Key as the user of the key, restricted to its scopes. A request with
+// an invalid key is rejected with 401, and a request without one is passed
+// on as it is, e.g. to BasicAuth. A request with an Authorization header too
+// is rejected with 400, so that its user and scopes come from a single
+// credential.
+func APIKey(h http.Handler, keys APIKeyAuthenticator) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		key := r.Header.Get(APIKeyHeader)
+		if key == "" {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		if r.Header.Get("Authorization") != "" {
+			verr := &model.ErrValidation{}
+			verr.Add(APIKeyHeader, "must not be sent with an Authorization header")
+			handler.WriteError(w, r, verr)
+			return
+		}
+
+		u, scopes, err := keys.AuthenticateAPIKey(r.Context(), key)
+		if err != nil {
+			handler.WriteError(w, r, err)
+			return
+		}
+
+		ctx := auth.WithScopes(auth.NewContext(r.Context(), u), scopes)
+		h.ServeHTTP(w, r.WithContext(ctx))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// Authorize returns a middleware that requires the scope read for the safe
+// methods GET, HEAD and OPTIONS, and the scope write for the others. A request
+// whose credentials do not grant it is rejected with 403.
+func Authorize(h http.Handler, read, write model.Scope) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		scope := write
+		switch r.Method {
+		case http.MethodGet, http.MethodHead, http.MethodOptions:
+			scope = read
+		}
+
+		if !auth.HasScope(r.Context(), scope) {
+			handler.WriteError(w, r, &model.ErrForbidden{Reason: "The credentials do not grant the scope " + string(scope) + "."})
+			return
+		}
+
+		h.ServeHTTP(w, r)
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,88 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// fakeKeys authenticates the API keys named after the scope they grant as
+// the user alice.
+type fakeKeys struct{}
+
+func (fakeKeys) AuthenticateAPIKey(_ context.Context, key string) (*model.User, []model.Scope, error) {
+	switch scope := model.Scope(key); scope {
+	case model.ScopeTODOsRead, model.ScopeTODOsWrite, model.ScopeAdmin:
+		return &model.User{ID: 1, Name: "alice"}, []model.Scope{scope}, nil
+	}
+	return nil, nil, &model.ErrUnauthorized{}
+}
+
+// fakeTokens authenticates the access token "bob" as the user bob.
+type fakeTokens struct{}
+
+func (fakeTokens) AuthenticateAccessToken(_ context.Context, token string) (*model.User, error) {
+	if token != "bob" {
+		return nil, &model.ErrUnauthorized{}
+	}
+	return &model.User{ID: 2, Name: "bob"}, nil
+}
+
+func TestAPIKeyScopes(t *testing.T) {
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := auth.FromContext(r.Context()); u != nil {
+			w.Write([]byte(u.Name))
+		}
+	})
+
+	// ルーターと同じく、TODO は読み書きのスコープで、API キーは admin で守る
+	mux := http.NewServeMux()
+	mux.Handle("/todos", Authorize(next, model.ScopeTODOsRead, model.ScopeTODOsWrite))
+	mux.Handle("/api-keys", Authorize(next, model.ScopeAdmin, model.ScopeAdmin))
+	h := APIKey(Bearer(mux, fakeTokens{}), fakeKeys{})
+
+	tests := []struct {
+		name       string
+		method     string
+		path       string
+		key        string
+		bearer     string
+		wantStatus int
+		wantUser   string
+	}{
+		{"read key reads", http.MethodGet, "/todos", "todos:read", "", http.StatusOK, "alice"},
+		{"read key cannot write", http.MethodPost, "/todos", "todos:read", "", http.StatusForbidden, ""},
+		{"write key writes", http.MethodPost, "/todos", "todos:write", "", http.StatusOK, "alice"},
+		{"write key cannot read", http.MethodGet, "/todos", "todos:write", "", http.StatusForbidden, ""},
+		{"write key cannot manage keys", http.MethodGet, "/api-keys", "todos:write", "", http.StatusForbidden, ""},
+		{"admin key reads", http.MethodGet, "/todos", "admin", "", http.StatusOK, "alice"},
+		{"admin key writes", http.MethodDelete, "/todos", "admin", "", http.StatusOK, "alice"},
+		{"admin key manages keys", http.MethodPost, "/api-keys", "admin", "", http.StatusOK, "alice"},
+		{"invalid key", http.MethodGet, "/todos", "unknown", "", http.StatusUnauthorized, ""},
+		{"bearer token is not restricted", http.MethodPost, "/api-keys", "", "bob", http.StatusOK, "bob"},
+		{"key with bearer token", http.MethodGet, "/todos", "todos:read", "bob", http.StatusBadRequest, ""},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(tt.method, tt.path, nil)
+		if tt.key != "" {
+			r.Header.Set(APIKeyHeader, tt.key)
+		}
+		if tt.bearer != "" {
+			r.Header.Set("Authorization", "Bearer "+tt.bearer)
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		if got := w.Body.String(); tt.wantStatus == http.StatusOK && got != tt.wantUser {
+			t.Errorf("%s: got user %q, want %q", tt.name, got, tt.wantUser)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package middleware
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+	"golang.org/x/crypto/bcrypt"
+)
+
+// A UserResolver returns the user of an authenticated user name, creating
+// it on first use. *service.UserService implements it.
+type UserResolver interface {
+	EnsureUser(ctx context.Context, name string) (*model.User, error)
+}
+
+// BasicAuthConfig configures BasicAuth.
+type BasicAuthConfig struct {
+	// Realm is sent in the WWW-Authenticate challenge.
+	Realm string
+	// Credentials are the user names and passwords that are accepted.
+	Credentials Credentials
+	// Users resolves the authenticated user name to the user stored in the
+	// request context.
+	Users UserResolver
+	// Public lists the paths served without authentication. A path ending
+	// with "/" matches every path under it, as in http.ServeMux.
+	Public []string
+	// Challenges are sent along with the Basic challenge, for the other
+	// authentication schemes the server accepts, e.g. "Bearer".
+	Challenges []string
+}
+
+// BasicAuth returns a middleware that requires HTTP Basic authentication with
+// cfg.Credentials on every path but cfg.Public, and serves the request as the
+// authenticated user. Other requests are rejected with 401 and a challenge,
+// unless an outer middleware such as APIKey has authenticated them already.
+func BasicAuth(h http.Handler, cfg BasicAuthConfig) http.Handler {
+	challenge := `Basic realm=` + strconv.Quote(cfg.Realm) + `, charset="UTF-8"`
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if isPublic(r.URL.Path, cfg.Public) || auth.FromContext(r.Context()) != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		name, password, ok := r.BasicAuth()
+		if !ok || !cfg.Credentials.Verify(name, password) {
+			w.Header().Set("WWW-Authenticate", challenge)
+			for _, c := range cfg.Challenges {
+				w.Header().Add("WWW-Authenticate", c)
+			}
+			handler.WriteError(w, r, &model.ErrUnauthorized{})
+			return
+		}
+
+		u, err := cfg.Users.EnsureUser(r.Context(), name)
+		if err != nil {
+			handler.WriteError(w, r, err)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), u)))
+	}
+
+	return http.HandlerFunc(fn)
+}
+
+// isPublic reports whether the path is one of the public paths.
+func isPublic(path string, public []string) bool {
+	for _, p := range public {
+		if path == p || strings.HasSuffix(p, "/") && strings.HasPrefix(path, p) {
+			return true
+		}
+	}
+	return false
+}
+
+// Credentials maps user names to the bcrypt hashes of their passwords.
+type Credentials map[string][]byte
+
+// Add adds the user name with the bcrypt hash of its password. Users are
+// stored by name ignoring case, so it returns an error if a name that differs
+// only in case is already added, rather than let both sign in as one user.
+func (c Credentials) Add(name string, hash []byte) error {
+	for n := range c {
+		if strings.EqualFold(n, name) {
+			return fmt.Errorf("user %s is defined more than once, as %s", name, n)
+		}
+	}
+	c[name] = hash
+	return nil
+}
+
+var (
+	dummyHashOnce sync.Once
+	dummyHash     []byte
+)
+
+// Verify reports whether the password is the one of the user name. The
+// password is compared in constant time, and against a dummy hash for an
+// unknown user, so that the time taken reveals neither the password nor
+// whether the user exists.
+func (c Credentials) Verify(name, password string) bool {
+	hash, ok := c[name]
+	if !ok {
+		dummyHashOnce.Do(func() {
+			dummyHash, _ = bcrypt.GenerateFromPassword([]byte("dummy"), bcrypt.DefaultCost)
+		})
+		hash = dummyHash
+	}
+	return bcrypt.CompareHashAndPassword(hash, []byte(password)) == nil && ok
+}
+
+// ParseHtpasswd reads credentials in the htpasswd format: a "name:hash" line
+// per user, where the hash is bcrypt as generated by "htpasswd -B". Blank
+// lines and lines starting with "#" are skipped. A user name must not appear
+// twice, even in a different case.
+func ParseHtpasswd(r io.Reader) (Credentials, error) {
+	creds := Credentials{}
+
+	s := bufio.NewScanner(r)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+
+		i := strings.IndexByte(line, ':')
+		if i <= 0 {
+			return nil, fmt.Errorf("line %d: not in the form name:hash", n)
+		}
+		name, hash := line[:i], []byte(line[i+1:])
+		// bcrypt 以外 (MD5, SHA1, crypt) のハッシュは受け付けない
+		if _, err := bcrypt.Cost(hash); err != nil {
+			return nil, fmt.Errorf("line %d: the hash of %s is not bcrypt: %v", n, name, err)
+		}
+		if err := creds.Add(name, hash); err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+	}
+	if err := s.Err(); err != nil {
+		return nil, err
+	}
+	return creds, nil
+}
+
+// LoadHtpasswd reads the credentials in the htpasswd file at path.
+func LoadHtpasswd(path string) (Credentials, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	return ParseHtpasswd(f)
+}
@@ -0,0 +1,127 @@
+package middleware
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// pwHash is the bcrypt hash of "pw" with the minimum cost.
+const pwHash = "$2a$04$YFXXtWXKdqHtpK43rCuyD.YIjj/Lvy3UmyPRgTGeUrU7E8SLqN47y"
+
+// fakeUsers resolves every user name to a user with the id 1.
+type fakeUsers struct{}
+
+func (fakeUsers) EnsureUser(_ context.Context, name string) (*model.User, error) {
+	return &model.User{ID: 1, Name: name}, nil
+}
+
+func TestBasicAuth(t *testing.T) {
+	creds, err := ParseHtpasswd(strings.NewReader("alice:" + pwHash))
+	if err != nil {
+		t.Fatal("failed to parse htpasswd, err =", err)
+	}
+
+	// 認証されたユーザーの名前を返す
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if u := auth.FromContext(r.Context()); u != nil {
+			w.Write([]byte(u.Name))
+		}
+	})
+	h := BasicAuth(next, BasicAuthConfig{
+		Realm:       "TODO",
+		Credentials: creds,
+		Users:       fakeUsers{},
+		Public:      []string{"/healthz", "/auth/"},
+	})
+
+	tests := []struct {
+		name       string
+		path       string
+		user, pass string
+		ctxUser    *model.User
+		wantStatus int
+		wantBody   string
+	}{
+		{name: "valid credentials", path: "/todos", user: "alice", pass: "pw", wantStatus: http.StatusOK, wantBody: "alice"},
+		{name: "no credentials", path: "/todos", wantStatus: http.StatusUnauthorized},
+		{name: "wrong password", path: "/todos", user: "alice", pass: "wrong", wantStatus: http.StatusUnauthorized},
+		{name: "other case", path: "/todos", user: "ALICE", pass: "pw", wantStatus: http.StatusUnauthorized},
+		{name: "unknown user", path: "/todos", user: "mallory", pass: "pw", wantStatus: http.StatusUnauthorized},
+		{name: "public path", path: "/healthz", wantStatus: http.StatusOK},
+		{name: "public prefix", path: "/auth/login", wantStatus: http.StatusOK},
+		{name: "already authenticated", path: "/todos", ctxUser: &model.User{ID: 2, Name: "bob"}, wantStatus: http.StatusOK, wantBody: "bob"},
+	}
+	for _, tt := range tests {
+		r := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, tt.pass)
+		}
+		if tt.ctxUser != nil {
+			r = r.WithContext(auth.NewContext(r.Context(), tt.ctxUser))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.wantStatus)
+			continue
+		}
+		challenge := w.Header().Get("WWW-Authenticate")
+		if tt.wantStatus == http.StatusUnauthorized {
+			if challenge != `Basic realm="TODO", charset="UTF-8"` {
+				t.Errorf("%s: got challenge %q", tt.name, challenge)
+			}
+			continue
+		}
+		if challenge != "" {
+			t.Errorf("%s: got challenge %q, want none", tt.name, challenge)
+		}
+		if got := w.Body.String(); got != tt.wantBody {
+			t.Errorf("%s: got user %q, want %q", tt.name, got, tt.wantBody)
+		}
+	}
+}
+
+func TestCredentialsVerify(t *testing.T) {
+	creds := Credentials{"alice": []byte(pwHash)}
+
+	if !creds.Verify("alice", "pw") {
+		t.Error("Verify rejected the right password")
+	}
+	if creds.Verify("alice", "wrong") {
+		t.Error("Verify accepted a wrong password")
+	}
+	// 存在しないユーザーもダミーのハッシュと比較して時間をかける
+	if creds.Verify("mallory", "dummy") {
+		t.Error("Verify accepted an unknown user with the password of the dummy hash")
+	}
+	if dummyHash == nil {
+		t.Error("Verify did not compare an unknown user with the dummy hash")
+	}
+}
+
+func TestParseHtpasswd(t *testing.T) {
+	tests := []struct {
+		name    string
+		in      string
+		wantErr bool
+	}{
+		{name: "users", in: "# comment\n\nalice:" + pwHash + "\nbob:" + pwHash + "\n"},
+		{name: "not bcrypt", in: "alice:{SHA}W6ph5Mm5Pz8GgiULbPgzG37mj9g=", wantErr: true},
+		{name: "no hash", in: "alice", wantErr: true},
+		{name: "duplicate", in: "alice:" + pwHash + "\nalice:" + pwHash, wantErr: true},
+		{name: "duplicate ignoring case", in: "alice:" + pwHash + "\nAlice:" + pwHash, wantErr: true},
+	}
+	for _, tt := range tests {
+		_, err := ParseHtpasswd(strings.NewReader(tt.in))
+		if (err != nil) != tt.wantErr {
+			t.Errorf("%s: got error %v, want error %v", tt.name, err, tt.wantErr)
+		}
+	}
+}
@@ -0,0 +1,49 @@
+package middleware
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// An AccessTokenAuthenticator returns the user of a JWT access token.
+// *service.SessionService implements it.
+type AccessTokenAuthenticator interface {
+	AuthenticateAccessToken(ctx context.Context, token string) (*model.User, error)
+}
+
+// Bearer returns a middleware that serves the requests carrying an access
+// token in "Authorization: Bearer" (RFC 6750) as the user of the token. A
+// request with an invalid or expired token is rejected with 401, so that the
+// client refreshes its session, and a request without one is passed on as it
+// is, e.g. to BasicAuth or RequireUser.
+func Bearer(h http.Handler, tokens AccessTokenAuthenticator) http.Handler {
+	const scheme = "bearer "
+
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		v := r.Header.Get("Authorization")
+		// 認証スキーム名は大文字小文字を区別しない
+		if len(v) < len(scheme) || !strings.EqualFold(v[:len(scheme)], scheme) {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		u, err := tokens.AuthenticateAccessToken(r.Context(), strings.TrimSpace(v[len(scheme):]))
+		if err != nil {
+			if errors.Is(err, &model.ErrUnauthorized{}) {
+				w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			}
+			handler.WriteError(w, r, err)
+			return
+		}
+
+		h.ServeHTTP(w, r.WithContext(auth.NewContext(r.Context(), u)))
+	}
+
+	return http.HandlerFunc(fn)
+}
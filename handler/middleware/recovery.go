@@ -1,24 +1,25 @@
 package middleware
 
 import (
-	"log"
+	"fmt"
 	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
 )
 
 func Recovery(h http.Handler) http.Handler {
-        fn := func(w http.ResponseWriter, r *http.Request) {
-            //deferで仕込む
-            defer func() {
-                if err := recover(); err != nil {
-
-                    log.Printf("[PANIC RECOVERED] err=%v\n", err)
-
-                    http.Error(w,"Internal Server Error", http.StatusInternalServerError)
-                }
-            }()
-        h.ServeHTTP(w, r)
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		//deferで仕込む
+		defer func() {
+			if err := recover(); err != nil {
+				// WriteError がリクエスト ID とともにログに残す
+				handler.WriteError(w, r, &model.ErrInternal{Err: fmt.Errorf("panic: %v", err)})
+			}
+		}()
+		h.ServeHTTP(w, r)
 
-    }
+	}
 
-    return http.HandlerFunc(fn)
-}
\ No newline at end of file
+	return http.HandlerFunc(fn)
+}
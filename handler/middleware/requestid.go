@@ -0,0 +1,23 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/requestid"
+)
+
+// RequestID returns a middleware that accepts the X-Request-ID of the request
+// or generates one, stores it in the request context and echoes it in the response.
+func RequestID(h http.Handler) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		id := r.Header.Get(requestid.Header)
+		if !requestid.Valid(id) {
+			id = requestid.New()
+		}
+
+		w.Header().Set(requestid.Header, id)
+		h.ServeHTTP(w, r.WithContext(requestid.NewContext(r.Context(), id)))
+	}
+
+	return http.HandlerFunc(fn)
+}
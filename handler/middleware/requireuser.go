@@ -0,0 +1,29 @@
+package middleware
+
+import (
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// RequireUser returns a middleware that rejects the requests to every path
+// but public with 401 and the challenges in WWW-Authenticate, unless an outer
+// middleware such as APIKey or Bearer has authenticated them. A path ending
+// with "/" in public matches every path under it, as in http.ServeMux.
+func RequireUser(h http.Handler, public []string, challenges ...string) http.Handler {
+	fn := func(w http.ResponseWriter, r *http.Request) {
+		if isPublic(r.URL.Path, public) || auth.FromContext(r.Context()) != nil {
+			h.ServeHTTP(w, r)
+			return
+		}
+
+		for _, c := range challenges {
+			w.Header().Add("WWW-Authenticate", c)
+		}
+		handler.WriteError(w, r, &model.ErrUnauthorized{})
+	}
+
+	return http.HandlerFunc(fn)
+}
@@ -0,0 +1,201 @@
+package handler
+
+import (
+	"context"
+	"crypto/subtle"
+	"errors"
+	"log"
+	"net/http"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/jwt"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/oidc"
+	"github.com/TechBowl-japan/go-stations/requestid"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// The cookies that keep a login with an OpenID Connect provider in progress
+// between the redirect to the provider and the callback.
+const (
+	oidcStateCookie    = "oidc_state"
+	oidcNonceCookie    = "oidc_nonce"
+	oidcVerifierCookie = "oidc_verifier"
+	oidcCookiePath     = "/auth/oidc/"
+	// oidcLoginTimeout is how long the user has to sign in with the provider.
+	oidcLoginTimeout = 10 * time.Minute
+)
+
+// An OIDCHandler implements signing in with an OpenID Connect provider by the
+// authorization code flow with PKCE, starting a Session of the local user
+// linked to the account of the provider.
+type OIDCHandler struct {
+	client   *oidc.Client
+	users    *service.UserService
+	sessions *service.SessionService
+	// secure marks the cookies Secure, for a redirect URL served over HTTPS.
+	secure bool
+}
+
+// NewOIDCHandler returns OIDCHandler based http.Handler.
+func NewOIDCHandler(client *oidc.Client, users *service.UserService, sessions *service.SessionService, secure bool) *OIDCHandler {
+	return &OIDCHandler{
+		client:   client,
+		users:    users,
+		sessions: sessions,
+		secure:   secure,
+	}
+}
+
+// Callback handles the endpoint the provider sends the user back to. It
+// exchanges the code for an ID token, and starts a Session of the user linked
+// to its subject, creating the user on first login.
+func (h *OIDCHandler) Callback(ctx context.Context, req *model.OIDCCallbackRequest) (*model.OIDCCallbackResponse, error) {
+	switch {
+	case req.Error != "":
+		// 利用者が拒否したなど、プロバイダーがログインさせなかった
+		return nil, &model.ErrUnauthorized{}
+	case req.LoginState == "" || subtle.ConstantTimeCompare([]byte(req.State), []byte(req.LoginState)) != 1:
+		return nil, invalidParam("state", "does not match the login started by the browser")
+	case req.Code == "":
+		return nil, invalidParam("code", "must not be empty")
+	}
+
+	token, err := h.client.Exchange(ctx, req.Code, req.Verifier)
+	if err != nil {
+		return nil, providerError(ctx, err)
+	}
+	claims, err := h.client.VerifyIDToken(ctx, token.IDToken, req.Nonce)
+	if err != nil {
+		return nil, providerError(ctx, err)
+	}
+
+	u, err := h.users.EnsureIdentity(ctx, claims.Issuer, claims.Subject, identityName(claims))
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.sessions.StartSession(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return &model.OIDCCallbackResponse{Session: *session}, nil
+}
+
+// ServeHTTP implements http.Handler interface.
+// It serves "/auth/oidc/login", which redirects the browser to the provider,
+// and "/auth/oidc/callback", both with GET.
+func (h *OIDCHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/auth/oidc")
+	if len(segments) != 1 || segments[0] != "login" && segments[0] != "callback" {
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+	if r.Method != http.MethodGet {
+		WriteError(w, r, errMethodNotAllowed(r))
+		return
+	}
+
+	if segments[0] == "login" {
+		h.handleLogin(w, r)
+	} else {
+		h.handleCallback(w, r)
+	}
+}
+
+func (h *OIDCHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var state, nonce, verifier string
+	for _, v := range []*string{&state, &nonce, &verifier} {
+		var err error
+		if *v, err = oidc.RandomValue(); err != nil {
+			WriteError(w, r, err)
+			return
+		}
+	}
+
+	u, err := h.client.AuthCodeURL(r.Context(), state, nonce, verifier)
+	if err != nil {
+		WriteError(w, r, providerError(r.Context(), err))
+		return
+	}
+
+	h.setCookie(w, oidcStateCookie, state, int(oidcLoginTimeout/time.Second))
+	h.setCookie(w, oidcNonceCookie, nonce, int(oidcLoginTimeout/time.Second))
+	h.setCookie(w, oidcVerifierCookie, verifier, int(oidcLoginTimeout/time.Second))
+	http.Redirect(w, r, u, http.StatusFound)
+}
+
+func (h *OIDCHandler) handleCallback(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := model.OIDCCallbackRequest{
+		Code:       query.Get("code"),
+		State:      query.Get("state"),
+		Error:      query.Get("error"),
+		LoginState: cookieValue(r, oidcStateCookie),
+		Nonce:      cookieValue(r, oidcNonceCookie),
+		Verifier:   cookieValue(r, oidcVerifierCookie),
+	}
+
+	// ログインの状態は一度しか使わせない
+	for _, name := range []string{oidcStateCookie, oidcNonceCookie, oidcVerifierCookie} {
+		h.setCookie(w, name, "", -1)
+	}
+
+	resp, err := h.Callback(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeSession(w, resp)
+}
+
+// setCookie sets a cookie of the login in progress, deleting it if maxAge is
+// negative. SameSite=Lax sends it along with the top-level navigation back
+// from the provider, but not with requests other sites make.
+func (h *OIDCHandler) setCookie(w http.ResponseWriter, name, value string, maxAge int) {
+	http.SetCookie(w, &http.Cookie{
+		Name:     name,
+		Value:    value,
+		Path:     oidcCookiePath,
+		MaxAge:   maxAge,
+		Secure:   h.secure,
+		HttpOnly: true,
+		SameSite: http.SameSiteLaxMode,
+	})
+}
+
+func cookieValue(r *http.Request, name string) string {
+	c, err := r.Cookie(name)
+	if err != nil {
+		return ""
+	}
+	return c.Value
+}
+
+// identityName returns the name a new user signed in with the ID token is
+// named after.
+func identityName(c *jwt.Claims) string {
+	for _, name := range []string{c.PreferredUsername, c.Email, c.Name} {
+		if name != "" {
+			return name
+		}
+	}
+	return c.Subject
+}
+
+// providerError logs the failure of signing in with the provider and returns
+// the error reported for it: 401 if the user is not authenticated, e.g. the
+// code has expired, and 502 if the provider cannot be used.
+func providerError(ctx context.Context, err error) error {
+	log.Printf("request_id=%s OIDC login failed: %v\n", requestid.FromContext(ctx), err)
+
+	var oerr *oidc.Error
+	if errors.Is(err, oidc.ErrInvalidIDToken) || errors.As(err, &oerr) && oerr.Code == "invalid_grant" {
+		return &model.ErrUnauthorized{}
+	}
+	return &httpError{
+		status: http.StatusBadGateway,
+		detail: "The identity provider cannot be used to sign in now.",
+	}
+}
@@ -2,25 +2,139 @@ package router
 
 import (
 	"database/sql"
+	"errors"
 	"net/http"
+	"strings"
+	"time"
 
 	"github.com/TechBowl-japan/go-stations/handler"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/jwt"
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/oidc"
 	"github.com/TechBowl-japan/go-stations/service"
 )
 
-func NewRouter(todoDB *sql.DB) *http.ServeMux {
+// bearerChallenge asks for an access token of a session (RFC 6750).
+const bearerChallenge = "Bearer"
+
+// Config configures the router. realMain reads it from the environment, and
+// the zero value serves every request without authentication.
+type Config struct {
+	// BasicAuth requires HTTP Basic authentication if not nil. Its Users and
+	// Public are set by the router.
+	BasicAuth *middleware.BasicAuthConfig
+	// JWTKeys enables the sessions with JWT access tokens if not nil, which
+	// are signed and verified with these keys.
+	JWTKeys *jwt.KeySet
+	// Session configures the sessions when JWTKeys is set.
+	Session service.SessionConfig
+	// OIDC enables signing in with an OpenID Connect provider if not nil. It
+	// requires JWTKeys to issue the sessions.
+	OIDC *oidc.Config
+	// UndoWindow is how long undo tokens are valid, 0 to disable undo.
+	UndoWindow time.Duration
+	// AccessLog enables the access log if not nil. The router does not close
+	// its Out.
+	AccessLog *middleware.AccessLogConfig
+}
+
+// NewRouter returns the router with the default settings, which serves every
+// request without authentication.
+func NewRouter(todoDB *sql.DB) http.Handler {
+	h, err := NewRouterWithConfig(todoDB, &Config{UndoWindow: service.DefaultUndoWindow})
+	if err != nil {
+		// 既定の設定は常に正しい
+		panic(err)
+	}
+	return h
+}
+
+// NewRouterWithConfig returns the router configured by cfg. Once Basic
+// authentication or sessions are enabled, only /healthz and /auth/ are served
+// without credentials. It returns an error if the settings do not work
+// together.
+func NewRouterWithConfig(todoDB *sql.DB, cfg *Config) (http.Handler, error) {
 	mux := http.NewServeMux()
-	
+
 	// Register health check endpoint
 	healthzHandler := handler.NewHealthzHandler()
 	mux.Handle("/healthz", healthzHandler)
 
+	// 認証を設定していなければ、すべてのリクエストをユーザーのいない TODO に対して処理する
+	requireUser := cfg.BasicAuth != nil || cfg.JWTKeys != nil
+
 	todoService := service.NewTODOService(todoDB)
-	todoHandler := handler.NewTODOHandler(todoService)
+	todoService.SetUndoWindow(cfg.UndoWindow)
+	todoService.SetRequireUser(requireUser)
+	// API キーのスコープで、タグやリストを含めた TODO の読み書きを制限する
+	todoHandler := middleware.Authorize(handler.NewTODOHandler(todoService), model.ScopeTODOsRead, model.ScopeTODOsWrite)
 	mux.Handle("/todos", todoHandler)
+	mux.Handle("/todos/", todoHandler)
+
+	tagService := service.NewTagService(todoDB)
+	tagService.SetRequireUser(requireUser)
+	tagHandler := middleware.Authorize(handler.NewTagHandler(tagService), model.ScopeTODOsRead, model.ScopeTODOsWrite)
+	mux.Handle("/tags", tagHandler)
+	mux.Handle("/tags/", tagHandler)
+
+	listService := service.NewListService(todoDB)
+	listService.SetRequireUser(requireUser)
+	listHandler := middleware.Authorize(handler.NewListHandler(listService), model.ScopeTODOsRead, model.ScopeTODOsWrite)
+	mux.Handle("/lists", listHandler)
+	mux.Handle("/lists/", listHandler)
+
+	apiKeyService := service.NewAPIKeyService(todoDB)
+	apiKeyHandler := middleware.Authorize(handler.NewAPIKeyHandler(apiKeyService), model.ScopeAdmin, model.ScopeAdmin)
+	mux.Handle("/api-keys", apiKeyHandler)
+	mux.Handle("/api-keys/", apiKeyHandler)
+
+	userService := service.NewUserService(todoDB)
+
+	var sessionService *service.SessionService
+	if cfg.JWTKeys != nil {
+		sessionService = service.NewSessionService(todoDB, cfg.JWTKeys, cfg.Session)
+		// パスワードでのログインは Basic 認証の資格情報があるときだけ受け付ける
+		var passwords handler.PasswordVerifier
+		if cfg.BasicAuth != nil {
+			passwords = cfg.BasicAuth.Credentials
+		}
+		mux.Handle("/auth/", handler.NewSessionHandler(sessionService, userService, passwords))
+	}
+	if cfg.OIDC != nil {
+		if sessionService == nil {
+			return nil, errors.New("signing in with OpenID Connect requires JWT keys to issue sessions")
+		}
+		secure := strings.HasPrefix(cfg.OIDC.RedirectURL, "https://")
+		mux.Handle("/auth/oidc/", handler.NewOIDCHandler(oidc.NewClient(*cfg.OIDC), userService, sessionService, secure))
+	}
 
 	mux.Handle("/do-panic", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-        panic("意図的にpanicを起こすテスト")
-    }))
-	return mux
-}
\ No newline at end of file
+		panic("意図的にpanicを起こすテスト")
+	}))
+
+	// 認証を設定したら、ログインとヘルスチェック以外は認証されたユーザーにだけ提供する
+	public := []string{"/healthz", "/auth/"}
+	var h http.Handler = mux
+	switch {
+	case cfg.BasicAuth != nil:
+		basicAuth := *cfg.BasicAuth
+		basicAuth.Users = userService
+		basicAuth.Public = public
+		if sessionService != nil {
+			basicAuth.Challenges = []string{bearerChallenge}
+		}
+		h = middleware.BasicAuth(h, basicAuth)
+	case sessionService != nil:
+		h = middleware.RequireUser(h, public, bearerChallenge)
+	}
+	if sessionService != nil {
+		h = middleware.Bearer(h, sessionService)
+	}
+	h = middleware.APIKey(h, apiKeyService)
+	h = middleware.Recovery(h)
+	if cfg.AccessLog != nil {
+		h = middleware.AccessLog(h, *cfg.AccessLog)
+	}
+	return middleware.RequestID(h), nil
+}
@@ -0,0 +1,173 @@
+package router
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/handler/middleware"
+	"github.com/TechBowl-japan/go-stations/jwt"
+	"github.com/TechBowl-japan/go-stations/oidc"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// pwHash is the bcrypt hash of "pw" with the minimum cost.
+const pwHash = "$2a$04$YFXXtWXKdqHtpK43rCuyD.YIjj/Lvy3UmyPRgTGeUrU7E8SLqN47y"
+
+// TestBasicAuthOptIn checks that Basic authentication is only required when
+// credentials are configured.
+func TestBasicAuthOptIn(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "router_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	tests := []struct {
+		name       string
+		users      string
+		user       string
+		wantStatus int
+	}{
+		{name: "unset", wantStatus: http.StatusOK},
+		{name: "no credentials", users: "alice:" + pwHash, wantStatus: http.StatusUnauthorized},
+		{name: "valid credentials", users: "alice:" + pwHash, user: "alice", wantStatus: http.StatusOK},
+	}
+	for _, tt := range tests {
+		var cfg Config
+		if tt.users != "" {
+			creds, err := middleware.ParseHtpasswd(strings.NewReader(tt.users))
+			if err != nil {
+				t.Fatal("failed to parse htpasswd, err =", err)
+			}
+			cfg.BasicAuth = &middleware.BasicAuthConfig{Realm: "TODO", Credentials: creds}
+		}
+		h, err := NewRouterWithConfig(todoDB, &cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to create router, err = %v", tt.name, err)
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		if tt.user != "" {
+			r.SetBasicAuth(tt.user, "pw")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != tt.wantStatus {
+			t.Errorf("%s: got status %d, want %d", tt.name, w.Code, tt.wantStatus)
+		}
+	}
+}
+
+// TestOIDCRequiresSessions checks that the router reports signing in with
+// OpenID Connect without the keys to issue sessions as an error.
+func TestOIDCRequiresSessions(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "router_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	cfg := &Config{OIDC: &oidc.Config{Issuer: "https://idp.example", ClientID: "todo", RedirectURL: "https://todo.example/auth/oidc/callback"}}
+	if _, err := NewRouterWithConfig(todoDB, cfg); err == nil {
+		t.Error("NewRouterWithConfig accepted OIDC without JWT keys")
+	}
+}
+
+// TestRequireCredentials checks that once authentication is configured, only
+// the health check and the login endpoints are served without credentials.
+func TestRequireCredentials(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "router_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	key, err := jwt.NewHS256Key("k1", []byte(strings.Repeat("k", 32)))
+	if err != nil {
+		t.Fatal("failed to create key, err =", err)
+	}
+	keys, err := jwt.NewKeySet(key)
+	if err != nil {
+		t.Fatal("failed to create key set, err =", err)
+	}
+	sessionCfg := service.SessionConfig{Audience: "todo", AccessTTL: time.Minute, RefreshTTL: time.Hour}
+
+	// 匿名で作られた TODO は、認証を設定した後は誰にも見えない
+	if _, err := service.NewTODOService(todoDB).InsertTODO(context.Background(), &service.TODOInput{Subject: "legacy"}); err != nil {
+		t.Fatal("failed to create TODO, err =", err)
+	}
+	u, err := service.NewUserService(todoDB).EnsureUser(context.Background(), "alice")
+	if err != nil {
+		t.Fatal("failed to create user, err =", err)
+	}
+	session, err := service.NewSessionService(todoDB, keys, sessionCfg).StartSession(context.Background(), u)
+	if err != nil {
+		t.Fatal("failed to start session, err =", err)
+	}
+	creds, err := middleware.ParseHtpasswd(strings.NewReader("alice:" + pwHash))
+	if err != nil {
+		t.Fatal("failed to parse htpasswd, err =", err)
+	}
+
+	configs := map[string]*Config{
+		"JWT":           {JWTKeys: keys, Session: sessionCfg},
+		"Basic":         {BasicAuth: &middleware.BasicAuthConfig{Realm: "TODO", Credentials: creds}},
+		"JWT and Basic": {JWTKeys: keys, Session: sessionCfg, BasicAuth: &middleware.BasicAuthConfig{Realm: "TODO", Credentials: creds}},
+	}
+	for name, cfg := range configs {
+		h, err := NewRouterWithConfig(todoDB, cfg)
+		if err != nil {
+			t.Fatalf("%s: failed to create router, err = %v", name, err)
+		}
+
+		for _, path := range []string{"/todos", "/todos/1", "/todos/trash", "/tags", "/lists", "/api-keys"} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code != http.StatusUnauthorized {
+				t.Errorf("%s: GET %s without credentials: got status %d, want %d", name, path, w.Code, http.StatusUnauthorized)
+				continue
+			}
+			challenges := strings.Join(w.Header().Values("WWW-Authenticate"), ", ")
+			if cfg.JWTKeys != nil && !strings.Contains(challenges, "Bearer") {
+				t.Errorf("%s: GET %s: got challenges %q, want Bearer", name, path, challenges)
+			}
+			if cfg.BasicAuth != nil && !strings.Contains(challenges, "Basic") {
+				t.Errorf("%s: GET %s: got challenges %q, want Basic", name, path, challenges)
+			}
+		}
+
+		for _, path := range []string{"/healthz", "/auth/login"} {
+			r := httptest.NewRequest(http.MethodGet, path, nil)
+			w := httptest.NewRecorder()
+			h.ServeHTTP(w, r)
+
+			if w.Code == http.StatusUnauthorized {
+				t.Errorf("%s: GET %s: got status %d, want it served without credentials", name, path, w.Code)
+			}
+		}
+
+		r := httptest.NewRequest(http.MethodGet, "/todos", nil)
+		if cfg.JWTKeys != nil {
+			r.Header.Set("Authorization", "Bearer "+session.AccessToken)
+		} else {
+			r.SetBasicAuth("alice", "pw")
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, r)
+
+		if w.Code != http.StatusOK {
+			t.Errorf("%s: GET /todos with credentials: got status %d, want %d", name, w.Code, http.StatusOK)
+		} else if strings.Contains(w.Body.String(), "legacy") {
+			t.Errorf("%s: GET /todos with credentials: got the TODOs of no user, body = %s", name, w.Body.String())
+		}
+	}
+}
@@ -0,0 +1,180 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A PasswordVerifier checks the password of a user name.
+// middleware.Credentials implements it.
+type PasswordVerifier interface {
+	Verify(name, password string) bool
+}
+
+// A SessionHandler implements handling REST endpoints of sessions, which
+// sign users in with JWT access tokens and refresh tokens.
+type SessionHandler struct {
+	sessions  *service.SessionService
+	users     *service.UserService
+	passwords PasswordVerifier
+}
+
+// NewSessionHandler returns SessionHandler based http.Handler. Signing in
+// with a password is served only if passwords is non-nil.
+func NewSessionHandler(sessions *service.SessionService, users *service.UserService, passwords PasswordVerifier) *SessionHandler {
+	return &SessionHandler{
+		sessions:  sessions,
+		users:     users,
+		passwords: passwords,
+	}
+}
+
+// Login handles the endpoint that starts a Session with a user name and password.
+func (h *SessionHandler) Login(ctx context.Context, req *model.LoginRequest) (*model.LoginResponse, error) {
+	verr := &model.ErrValidation{}
+	if req.Username == "" {
+		verr.Add("username", "must not be empty")
+	}
+	if req.Password == "" {
+		verr.Add("password", "must not be empty")
+	}
+	if len(verr.Fields) > 0 {
+		return nil, verr
+	}
+
+	if !h.passwords.Verify(req.Username, req.Password) {
+		return nil, &model.ErrUnauthorized{}
+	}
+
+	u, err := h.users.EnsureUser(ctx, req.Username)
+	if err != nil {
+		return nil, err
+	}
+
+	session, err := h.sessions.StartSession(ctx, u)
+	if err != nil {
+		return nil, err
+	}
+	return &model.LoginResponse{Session: *session}, nil
+}
+
+// Refresh handles the endpoint that exchanges a refresh token for the next Session.
+func (h *SessionHandler) Refresh(ctx context.Context, req *model.RefreshSessionRequest) (*model.RefreshSessionResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, invalidParam("refresh_token", "must not be empty")
+	}
+
+	session, err := h.sessions.RefreshSession(ctx, req.RefreshToken)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RefreshSessionResponse{Session: *session}, nil
+}
+
+// Logout handles the endpoint that ends the Session of a refresh token.
+func (h *SessionHandler) Logout(ctx context.Context, req *model.LogoutRequest) (*model.LogoutResponse, error) {
+	if req.RefreshToken == "" {
+		return nil, invalidParam("refresh_token", "must not be empty")
+	}
+
+	if err := h.sessions.EndSession(ctx, req.RefreshToken); err != nil {
+		return nil, err
+	}
+	return &model.LogoutResponse{}, nil
+}
+
+// ServeHTTP implements http.Handler interface.
+// It serves "/auth/login", "/auth/refresh" and "/auth/logout", all with POST.
+func (h *SessionHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/auth")
+	if len(segments) != 1 {
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+
+	var serve func(http.ResponseWriter, *http.Request)
+	switch segments[0] {
+	case "login":
+		if h.passwords == nil {
+			WriteError(w, r, errRouteNotFound(r))
+			return
+		}
+		serve = h.handleLogin
+	case "refresh":
+		serve = h.handleRefresh
+	case "logout":
+		serve = h.handleLogout
+	default:
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+
+	if r.Method != http.MethodPost {
+		WriteError(w, r, errMethodNotAllowed(r))
+		return
+	}
+	serve(w, r)
+}
+
+func (h *SessionHandler) handleLogin(w http.ResponseWriter, r *http.Request) {
+	var req model.LoginRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Login(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeSession(w, resp)
+}
+
+func (h *SessionHandler) handleRefresh(w http.ResponseWriter, r *http.Request) {
+	var req model.RefreshSessionRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Refresh(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	writeSession(w, resp)
+}
+
+func (h *SessionHandler) handleLogout(w http.ResponseWriter, r *http.Request) {
+	var req model.LogoutRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Logout(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// writeSession writes the response carrying a Session, which must not be
+// cached as RFC 6749 requires of token responses.
+func writeSession(w http.ResponseWriter, resp interface{}) {
+	w.Header().Set("Cache-Control", "no-store")
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
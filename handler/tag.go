@@ -0,0 +1,183 @@
+package handler
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// A TagHandler implements handling REST endpoints of tags.
+type TagHandler struct {
+	svc *service.TagService
+}
+
+// NewTagHandler returns TagHandler based http.Handler.
+func NewTagHandler(svc *service.TagService) *TagHandler {
+	return &TagHandler{
+		svc: svc,
+	}
+}
+
+// Create handles the endpoint that creates the Tag.
+func (h *TagHandler) Create(ctx context.Context, req *model.CreateTagRequest) (*model.CreateTagResponse, error) {
+	tag, err := h.svc.CreateTag(ctx, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &model.CreateTagResponse{Tag: tag}, nil
+}
+
+// Get handles the endpoint that reads a single Tag.
+func (h *TagHandler) Get(ctx context.Context, req *model.GetTagRequest) (*model.GetTagResponse, error) {
+	tag, err := h.svc.GetTag(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GetTagResponse{Tag: tag}, nil
+}
+
+// Read handles the endpoint that reads all Tags.
+func (h *TagHandler) Read(ctx context.Context, req *model.ReadTagRequest) (*model.ReadTagResponse, error) {
+	tags, err := h.svc.ReadTags(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.ReadTagResponse{
+		Tags: []model.Tag{},
+	}
+	for _, tag := range tags {
+		response.Tags = append(response.Tags, *tag)
+	}
+	return response, nil
+}
+
+// Update handles the endpoint that renames the Tag.
+func (h *TagHandler) Update(ctx context.Context, req *model.UpdateTagRequest) (*model.UpdateTagResponse, error) {
+	tag, err := h.svc.RenameTag(ctx, req.ID, req.Name)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UpdateTagResponse{Tag: tag}, nil
+}
+
+// Delete handles the endpoint that deletes the Tag.
+func (h *TagHandler) Delete(ctx context.Context, req *model.DeleteTagRequest) (*model.DeleteTagResponse, error) {
+	if err := h.svc.DeleteTag(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	return &model.DeleteTagResponse{}, nil
+}
+
+// ServeHTTP implements http.Handler interface.
+// It serves both the collection "/tags" and the items "/tags/{id}".
+func (h *TagHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/tags")
+	switch len(segments) {
+	case 0:
+		switch r.Method {
+		case http.MethodGet:
+			h.handleRead(w, r)
+		case http.MethodPost:
+			h.handleCreate(w, r)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+	case 1:
+		id, err := strconv.ParseInt(segments[0], 10, 64)
+		if err != nil || id <= 0 {
+			WriteError(w, r, errRouteNotFound(r))
+			return
+		}
+
+		switch r.Method {
+		case http.MethodGet:
+			h.handleGet(w, r, id)
+		case http.MethodPut:
+			h.handleUpdate(w, r, id)
+		case http.MethodDelete:
+			h.handleDelete(w, r, id)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+	default:
+		WriteError(w, r, errRouteNotFound(r))
+	}
+}
+
+func (h *TagHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
+	var req model.CreateTagRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Create(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TagHandler) handleGet(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Get(r.Context(), &model.GetTagRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TagHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.Read(r.Context(), &model.ReadTagRequest{})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TagHandler) handleUpdate(w http.ResponseWriter, r *http.Request, id int64) {
+	var req model.UpdateTagRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	req.ID = id
+
+	resp, err := h.Update(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TagHandler) handleDelete(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Delete(r.Context(), &model.DeleteTagRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
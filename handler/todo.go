@@ -1,10 +1,16 @@
 package handler
 
 import (
+	"bytes"
 	"context"
+	"database/sql"
 	"encoding/json"
+	"fmt"
+	"mime"
 	"net/http"
 	"strconv"
+	"strings"
+	"time"
 
 	"github.com/TechBowl-japan/go-stations/model"
 	"github.com/TechBowl-japan/go-stations/service"
@@ -24,106 +30,620 @@ func NewTODOHandler(svc *service.TODOService) *TODOHandler {
 
 // Create handles the endpoint that creates the TODO.
 func (h *TODOHandler) Create(ctx context.Context, req *model.CreateTODORequest) (*model.CreateTODOResponse, error) {
-	_, _ = h.svc.CreateTODO(ctx, "", "")
-	return &model.CreateTODOResponse{}, nil
+	todo, err := h.svc.InsertTODO(ctx, &service.TODOInput{
+		Subject:     req.Subject,
+		Description: req.Description,
+		DueAt:       req.DueAt,
+		Tags:        req.Tags,
+		Priority:    req.Priority,
+		ParentID:    req.ParentID,
+		ListID:      req.ListID,
+		RRule:       req.RRule,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &model.CreateTODOResponse{TODO: todo}, nil
+}
+
+// Get handles the endpoint that reads a single TODO.
+func (h *TODOHandler) Get(ctx context.Context, req *model.GetTODORequest) (*model.GetTODOResponse, error) {
+	todo, err := h.svc.GetTODO(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GetTODOResponse{TODO: todo}, nil
 }
 
 // Read handles the endpoint that reads the TODOs.
 func (h *TODOHandler) Read(ctx context.Context, req *model.ReadTODORequest) (*model.ReadTODOResponse, error) {
-	_, _ = h.svc.ReadTODO(ctx, 0, 0)
-	return &model.ReadTODOResponse{}, nil
+	todos, err := h.svc.FilterTODO(ctx, &service.TODOFilter{
+		PrevID:    req.PrevID,
+		Size:      int64(req.Size),
+		Status:    service.TODOStatus(req.Status),
+		Due:       service.TODODue(req.Due),
+		DueWithin: req.DueWithin,
+		Sort:      service.TODOSort(req.Sort),
+		Tags:      req.Tags,
+		TagMatch:  service.TODOTagMatch(req.TagMatch),
+		ParentID:  req.ParentID,
+		Ready:     req.Ready,
+		ListID:    req.ListID,
+
+		IncludeArchived: req.IncludeArchived,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.ReadTODOResponse{
+		TODOs: []model.TODO{},
+	}
+	for _, todo := range todos {
+		response.TODOs = append(response.TODOs, *todo)
+	}
+	return response, nil
+}
+
+// Search handles the endpoint that searches the TODOs.
+func (h *TODOHandler) Search(ctx context.Context, req *model.SearchTODORequest) (*model.SearchTODOResponse, error) {
+	hits, err := h.svc.SearchTODO(ctx, req.Query, req.PrevID, int64(req.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	response := &model.SearchTODOResponse{
+		Hits: []model.SearchTODOHit{},
+	}
+	for _, hit := range hits {
+		response.Hits = append(response.Hits, *hit)
+	}
+	return response, nil
 }
 
 // Update handles the endpoint that updates the TODO.
 func (h *TODOHandler) Update(ctx context.Context, req *model.UpdateTODORequest) (*model.UpdateTODOResponse, error) {
-	_, _ = h.svc.UpdateTODO(ctx, 0, "", "")
-	return &model.UpdateTODOResponse{}, nil
+	// completed や due_at などは指定された場合のみ変更する
+	p := &service.TODOPatch{
+		Subject:     &req.Subject,
+		Description: &req.Description,
+		Completed:   req.Completed,
+		Priority:    req.Priority,
+		RRule:       req.RRule,
+		IfMatch:     req.IfMatch,
+	}
+	if req.DueAt != nil {
+		p.DueAt = &sql.NullTime{Time: *req.DueAt, Valid: true}
+	}
+	if req.Tags != nil {
+		p.Tags = &req.Tags
+	}
+	if req.ParentID != nil {
+		p.ParentID = &sql.NullInt64{Int64: *req.ParentID, Valid: true}
+	}
+	if req.ListID != nil {
+		p.ListID = &sql.NullInt64{Int64: *req.ListID, Valid: true}
+	}
+
+	todo, token, err := h.svc.PatchTODOUndoable(ctx, int64(req.ID), p)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UpdateTODOResponse{TODO: *todo, UndoToken: token}, nil
+}
+
+// Patch handles the endpoint that partially updates the TODO.
+func (h *TODOHandler) Patch(ctx context.Context, req *model.PatchTODORequest) (*model.PatchTODOResponse, error) {
+	p, err := newTODOPatch(req)
+	if err != nil {
+		return nil, err
+	}
+
+	p.IfMatch = req.IfMatch
+	todo, token, err := h.svc.PatchTODOUndoable(ctx, req.ID, p)
+	if err != nil {
+		return nil, err
+	}
+	return &model.PatchTODOResponse{TODO: *todo, UndoToken: token}, nil
+}
+
+// Tree handles the endpoint that reads the TODO with all its subtasks.
+func (h *TODOHandler) Tree(ctx context.Context, req *model.GetTODOTreeRequest) (*model.GetTODOTreeResponse, error) {
+	node, err := h.svc.GetTODOTree(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GetTODOTreeResponse{TODO: *node}, nil
+}
+
+// Move handles the endpoint that moves the TODO in the manual order.
+func (h *TODOHandler) Move(ctx context.Context, req *model.MoveTODORequest) (*model.MoveTODOResponse, error) {
+	todo, token, err := h.svc.MoveTODOUndoable(ctx, req.ID, req.Before, req.After)
+	if err != nil {
+		return nil, err
+	}
+	return &model.MoveTODOResponse{TODO: *todo, UndoToken: token}, nil
+}
+
+// Complete handles the endpoint that marks the TODO as completed.
+func (h *TODOHandler) Complete(ctx context.Context, req *model.CompleteTODORequest) (*model.CompleteTODOResponse, error) {
+	todo, err := h.svc.CompleteTODO(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.CompleteTODOResponse{TODO: *todo}, nil
+}
+
+// Reopen handles the endpoint that marks the TODO as not completed.
+func (h *TODOHandler) Reopen(ctx context.Context, req *model.ReopenTODORequest) (*model.ReopenTODOResponse, error) {
+	todo, err := h.svc.ReopenTODO(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.ReopenTODOResponse{TODO: *todo}, nil
+}
+
+// Dependencies handles the endpoint that reads the dependencies of the TODO.
+func (h *TODOHandler) Dependencies(ctx context.Context, req *model.GetTODODependenciesRequest) (*model.GetTODODependenciesResponse, error) {
+	blockedBy, blocks, err := h.svc.GetTODODependencies(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.GetTODODependenciesResponse{
+		BlockedBy: todoValues(blockedBy),
+		Blocks:    todoValues(blocks),
+	}, nil
+}
+
+// AddDependency handles the endpoint that makes the TODO blocked by another.
+func (h *TODOHandler) AddDependency(ctx context.Context, req *model.AddTODODependencyRequest) (*model.AddTODODependencyResponse, error) {
+	blockedBy, blocks, err := h.svc.AddTODODependency(ctx, req.ID, req.BlockedBy)
+	if err != nil {
+		return nil, err
+	}
+	return &model.AddTODODependencyResponse{
+		BlockedBy: todoValues(blockedBy),
+		Blocks:    todoValues(blocks),
+	}, nil
+}
+
+// RemoveDependency handles the endpoint that makes the TODO no longer blocked by another.
+func (h *TODOHandler) RemoveDependency(ctx context.Context, req *model.RemoveTODODependencyRequest) (*model.RemoveTODODependencyResponse, error) {
+	if err := h.svc.RemoveTODODependency(ctx, req.ID, req.BlockerID); err != nil {
+		return nil, err
+	}
+	return &model.RemoveTODODependencyResponse{}, nil
 }
 
-// Delete handles the endpoint that deletes the TODOs.
+// Sort handles the endpoint that exports all the TODOs in dependency order.
+func (h *TODOHandler) Sort(ctx context.Context, req *model.SortTODORequest) (*model.SortTODOResponse, error) {
+	todos, deps, err := h.svc.SortTODO(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &model.SortTODOResponse{
+		TODOs:        todoValues(todos),
+		Dependencies: deps,
+	}, nil
+}
+
+// Occurrences handles the endpoint that previews the next occurrences of the recurring TODO.
+func (h *TODOHandler) Occurrences(ctx context.Context, req *model.PreviewTODOOccurrencesRequest) (*model.PreviewTODOOccurrencesResponse, error) {
+	occurrences, err := h.svc.PreviewOccurrences(ctx, req.ID, req.N)
+	if err != nil {
+		return nil, err
+	}
+	return &model.PreviewTODOOccurrencesResponse{Occurrences: occurrences}, nil
+}
+
+// History handles the endpoint that reads the changes to the TODO.
+func (h *TODOHandler) History(ctx context.Context, req *model.GetTODOHistoryRequest) (*model.GetTODOHistoryResponse, error) {
+	events, err := h.svc.GetTODOHistory(ctx, req.ID, req.PrevRevision, int64(req.Size))
+	if err != nil {
+		return nil, err
+	}
+
+	resp := &model.GetTODOHistoryResponse{Events: make([]model.TODOEvent, 0, len(events))}
+	for _, event := range events {
+		resp.Events = append(resp.Events, *event)
+	}
+	return resp, nil
+}
+
+// Revert handles the endpoint that reverts the TODO to a revision of its history.
+func (h *TODOHandler) Revert(ctx context.Context, req *model.RevertTODORequest) (*model.RevertTODOResponse, error) {
+	todo, err := h.svc.RevertTODO(ctx, req.ID, req.Revision, req.IfMatch)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RevertTODOResponse{TODO: *todo}, nil
+}
+
+// ReadTrash handles the endpoint that reads the trashed TODOs.
+func (h *TODOHandler) ReadTrash(ctx context.Context, req *model.ReadTrashRequest) (*model.ReadTrashResponse, error) {
+	todos, err := h.svc.ReadTrash(ctx, req.PrevID, int64(req.Size))
+	if err != nil {
+		return nil, err
+	}
+	return &model.ReadTrashResponse{TODOs: todoValues(todos)}, nil
+}
+
+// Restore handles the endpoint that takes the TODO out of the trash.
+func (h *TODOHandler) Restore(ctx context.Context, req *model.RestoreTODORequest) (*model.RestoreTODOResponse, error) {
+	todo, err := h.svc.RestoreTODO(ctx, req.ID)
+	if err != nil {
+		return nil, err
+	}
+	return &model.RestoreTODOResponse{TODO: *todo}, nil
+}
+
+// Purge handles the endpoint that permanently deletes the trashed TODO.
+func (h *TODOHandler) Purge(ctx context.Context, req *model.PurgeTODORequest) (*model.PurgeTODOResponse, error) {
+	if err := h.svc.PurgeTODO(ctx, req.ID); err != nil {
+		return nil, err
+	}
+	return &model.PurgeTODOResponse{}, nil
+}
+
+// EmptyTrash handles the endpoint that permanently deletes all the trashed TODOs.
+func (h *TODOHandler) EmptyTrash(ctx context.Context, req *model.EmptyTrashRequest) (*model.EmptyTrashResponse, error) {
+	n, err := h.svc.EmptyTrash(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return &model.EmptyTrashResponse{Purged: n}, nil
+}
+
+// Delete handles the endpoint that moves the TODOs to the trash.
 func (h *TODOHandler) Delete(ctx context.Context, req *model.DeleteTODORequest) (*model.DeleteTODOResponse, error) {
-	_ = h.svc.DeleteTODO(ctx, nil)
-	return &model.DeleteTODOResponse{}, nil
+	token, err := h.svc.DeleteTODOWith(ctx, &service.TODODeletion{
+		IDs:      req.IDs,
+		Children: service.ChildPolicy(req.Children),
+		IfMatch:  req.IfMatch,
+	})
+	if err != nil {
+		return nil, err
+	}
+	return &model.DeleteTODOResponse{UndoToken: token}, nil
+}
+
+// Undo handles the endpoint that reverses a delete or an update by its undo token.
+func (h *TODOHandler) Undo(ctx context.Context, req *model.UndoTODORequest) (*model.UndoTODOResponse, error) {
+	todos, err := h.svc.UndoTODO(ctx, req.UndoToken)
+	if err != nil {
+		return nil, err
+	}
+	return &model.UndoTODOResponse{TODOs: todoValues(todos)}, nil
 }
 
+// ServeHTTP implements http.Handler interface.
+// It serves the collection "/todos", the items "/todos/{id}" and their
+// sub-resources such as "/todos/{id}/move".
 func (h *TODOHandler) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	segments := pathSegments(r.URL.Path, "/todos")
+	if len(segments) == 0 {
+		h.serveCollection(w, r)
+		return
+	}
+
+	if len(segments) == 1 && segments[0] == "search" {
+		if r.Method != http.MethodGet {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleSearch(w, r)
+		return
+	}
+
+	if segments[0] == "trash" {
+		h.serveTrash(w, r, segments[1:])
+		return
+	}
+
+	if len(segments) == 1 && segments[0] == "undo" {
+		if r.Method != http.MethodPost {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleUndo(w, r)
+		return
+	}
+
+	if len(segments) == 1 && segments[0] == "topological" {
+		if r.Method != http.MethodGet {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleSort(w, r)
+		return
+	}
+
+	id, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil || id <= 0 {
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+
+	switch strings.Join(segments[1:], "/") {
+	case "":
+		h.serveItem(w, r, id)
+	case "children":
+		if r.Method != http.MethodGet {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleRead(w, r, id)
+	case "tree":
+		if r.Method != http.MethodGet {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleTree(w, r, id)
+	case "move":
+		if r.Method != http.MethodPost {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleMove(w, r, id)
+	case "complete", "reopen":
+		if r.Method != http.MethodPost {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		if segments[1] == "complete" {
+			h.handleComplete(w, r, id)
+		} else {
+			h.handleReopen(w, r, id)
+		}
+	case "dependencies":
+		switch r.Method {
+		case http.MethodGet:
+			h.handleDependencies(w, r, id)
+		case http.MethodPost:
+			h.handleAddDependency(w, r, id)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+	case "occurrences":
+		if r.Method != http.MethodGet {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleOccurrences(w, r, id)
+	case "history":
+		if r.Method != http.MethodGet {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleHistory(w, r, id)
+	case "revert":
+		if r.Method != http.MethodPost {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleRevert(w, r, id)
+	default:
+		// "/todos/{id}/dependencies/{blocker_id}" は依存関係そのものを表す
+		if len(segments) == 3 && segments[1] == "dependencies" {
+			blockerID, err := strconv.ParseInt(segments[2], 10, 64)
+			if err != nil || blockerID <= 0 {
+				WriteError(w, r, errRouteNotFound(r))
+				return
+			}
+			if r.Method != http.MethodDelete {
+				WriteError(w, r, errMethodNotAllowed(r))
+				return
+			}
+			h.handleRemoveDependency(w, r, id, blockerID)
+			return
+		}
+		WriteError(w, r, errRouteNotFound(r))
+	}
+}
+
+// serveTrash serves "/todos/trash", "/todos/trash/{id}" and "/todos/trash/{id}/restore".
+func (h *TODOHandler) serveTrash(w http.ResponseWriter, r *http.Request, segments []string) {
+	if len(segments) == 0 {
+		switch r.Method {
+		case http.MethodGet:
+			h.handleReadTrash(w, r)
+		case http.MethodDelete:
+			h.handleEmptyTrash(w, r)
+		default:
+			WriteError(w, r, errMethodNotAllowed(r))
+		}
+		return
+	}
+
+	id, err := strconv.ParseInt(segments[0], 10, 64)
+	if err != nil || id <= 0 {
+		WriteError(w, r, errRouteNotFound(r))
+		return
+	}
+
+	switch strings.Join(segments[1:], "/") {
+	case "":
+		if r.Method != http.MethodDelete {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handlePurge(w, r, id)
+	case "restore":
+		if r.Method != http.MethodPost {
+			WriteError(w, r, errMethodNotAllowed(r))
+			return
+		}
+		h.handleRestore(w, r, id)
+	default:
+		WriteError(w, r, errRouteNotFound(r))
+	}
+}
+
+func (h *TODOHandler) serveCollection(w http.ResponseWriter, r *http.Request) {
 	switch r.Method {
 	case http.MethodPost:
 		h.handleCreate(w, r)
 	case http.MethodPut:
-		h.handleUpdate(w, r)
+		h.handleUpdate(w, r, 0)
 	case http.MethodGet:
-		h.handleRead(w, r)
+		h.handleRead(w, r, 0)
 	case http.MethodDelete:
 		h.handleDelete(w, r)
 	default:
-		w.WriteHeader(http.StatusMethodNotAllowed)
+		WriteError(w, r, errMethodNotAllowed(r))
+	}
+}
+
+func (h *TODOHandler) serveItem(w http.ResponseWriter, r *http.Request, id int64) {
+	switch r.Method {
+	case http.MethodGet:
+		h.handleGet(w, r, id)
+	case http.MethodPut:
+		h.handleUpdate(w, r, id)
+	case http.MethodPatch:
+		h.handlePatch(w, r, id)
+	case http.MethodDelete:
+		h.handleDeleteOne(w, r, id)
+	default:
+		WriteError(w, r, errMethodNotAllowed(r))
+	}
+}
+
+func (h *TODOHandler) handleGet(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Get(r.Context(), &model.GetTODORequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	etag := resp.TODO.ETag()
+	w.Header().Set("ETag", etag)
+	if inm := r.Header.Get("If-None-Match"); inm != "" && matchWeakETag(parseETags(inm), etag) {
+		w.WriteHeader(http.StatusNotModified)
+		return
 	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
 }
 
 func (h *TODOHandler) handleCreate(w http.ResponseWriter, r *http.Request) {
 	var req model.CreateTODORequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
 	// Subject is required
 	if req.Subject == "" {
-		w.WriteHeader(http.StatusBadRequest)
+		WriteError(w, r, invalidParam("subject", "is required"))
 		return
 	}
 
-	createdTodo, err := h.svc.CreateTODO(r.Context(), req.Subject, req.Description)
+	resp, err := h.Create(r.Context(), &req)
 	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+		WriteError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", resp.TODO.ETag())
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(model.CreateTODOResponse{TODO: createdTodo})
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (h *TODOHandler) handleUpdate(w http.ResponseWriter, r *http.Request) {
+// handleUpdate updates the TODO identified by id, or by the request body when id is 0.
+func (h *TODOHandler) handleUpdate(w http.ResponseWriter, r *http.Request, id int64) {
 	var req model.UpdateTODORequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
-	if req.ID == 0 || req.Subject == "" {
-		w.WriteHeader(http.StatusBadRequest)
+	// /todos/{id} の場合はパスの ID を使い、ボディの ID と矛盾していればエラーにする
+	var verr model.ErrValidation
+	if id != 0 {
+		if req.ID != 0 && int64(req.ID) != id {
+			verr.Add("id", "does not match the path")
+		}
+		req.ID = int(id)
+	}
+
+	if req.ID == 0 {
+		verr.Add("id", "is required")
+	}
+	if req.Subject == "" {
+		verr.Add("subject", "is required")
+	}
+	if len(verr.Fields) > 0 {
+		WriteError(w, r, &verr)
 		return
 	}
 
-	updatedTodo, err := h.svc.UpdateTODO(r.Context(), int64(req.ID), req.Subject, req.Description)
+	req.IfMatch = parseETags(r.Header.Get("If-Match"))
+	resp, err := h.Update(r.Context(), &req)
 	if err != nil {
-		if _, ok := err.(*model.ErrNotFound); ok {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
-		}
+		WriteError(w, r, err)
 		return
 	}
 
+	w.Header().Set("ETag", resp.TODO.ETag())
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(model.UpdateTODOResponse{TODO: *updatedTodo})
+	json.NewEncoder(w).Encode(resp)
 }
 
-func (h *TODOHandler) handleRead(w http.ResponseWriter, r *http.Request) {
+func (h *TODOHandler) handlePatch(w http.ResponseWriter, r *http.Request, id int64) {
+	mediaType, _, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != mergePatchMediaType {
+		w.Header().Set("Accept-Patch", mergePatchMediaType)
+		WriteError(w, r, errUnsupportedMediaType(mergePatchMediaType))
+		return
+	}
+
+	// Merge Patch のドキュメントは JSON オブジェクトでなければならない
+	var body json.RawMessage
+	if err := decodeJSON(r, &body); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	if !bytes.HasPrefix(body, []byte("{")) {
+		WriteError(w, r, errMalformedRequest("The merge patch document must be a JSON object."))
+		return
+	}
+
+	req := model.PatchTODORequest{ID: id, IfMatch: parseETags(r.Header.Get("If-Match"))}
+	if err := decodeError(json.Unmarshal(body, &req)); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Patch(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.TODO.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// handleRead reads the TODOs, or the subtasks of the TODO parentID if it is not 0.
+func (h *TODOHandler) handleRead(w http.ResponseWriter, r *http.Request, parentID int64) {
 	//URLのクエリパラメータを取得しTODORequestに値を代入
 	query := r.URL.Query()
 	prevID := query.Get("prev_id")
 	size := query.Get("size")
 
-	var req model.ReadTODORequest
+	req := model.ReadTODORequest{ParentID: parentID}
 
 	if prevID != "" {
 		parsedPrevID, err := strconv.ParseInt(prevID, 10, 64)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			WriteError(w, r, invalidParam("prev_id", "must be an integer"))
 			return
 		}
 		req.PrevID = parsedPrevID
@@ -132,7 +652,7 @@ func (h *TODOHandler) handleRead(w http.ResponseWriter, r *http.Request) {
 	if size != "" {
 		parsedSize, err := strconv.Atoi(size)
 		if err != nil {
-			w.WriteHeader(http.StatusBadRequest)
+			WriteError(w, r, invalidParam("size", "must be an integer"))
 			return
 		}
 		req.Size = parsedSize
@@ -140,51 +660,665 @@ func (h *TODOHandler) handleRead(w http.ResponseWriter, r *http.Request) {
 		req.Size = 10
 	}
 
-	// ReadTODO メソッドを呼び出し
-	todos, err := h.svc.ReadTODO(r.Context(), req.PrevID, int64(req.Size))
-	if err != nil {
-		w.WriteHeader(http.StatusInternalServerError)
+	// status は all / open / completed のいずれか
+	switch status := query.Get("status"); service.TODOStatus(status) {
+	case "all":
+		req.Status = string(service.StatusAll)
+	case service.StatusAll, service.StatusOpen, service.StatusCompleted:
+		req.Status = status
+	default:
+		WriteError(w, r, invalidParam("status", "must be one of all, open or completed"))
 		return
 	}
 
-	// ReadTODOResponse を構築
-	response := model.ReadTODOResponse{
-		TODOs: []model.TODO{},
-	}
-	for _, todo := range todos {
-		response.TODOs = append(response.TODOs, *todo)
+	// due は overdue / today のいずれか、due_within は 48h のような期間
+	switch due := query.Get("due"); service.TODODue(due) {
+	case service.DueAny, service.DueOverdue, service.DueToday:
+		req.Due = due
+	default:
+		WriteError(w, r, invalidParam("due", "must be one of overdue or today"))
+		return
 	}
 
-	// JSON Encode を行い HTTP Response を返す
-	w.Header().Set("Content-Type", "application/json")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(response)
-
-}
+	if within := query.Get("due_within"); within != "" {
+		d, err := time.ParseDuration(within)
+		if err != nil || d <= 0 {
+			WriteError(w, r, invalidParam("due_within", "must be a positive duration such as 48h"))
+			return
+		}
+		req.DueWithin = d
+	}
 
-func (h *TODOHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
-	var req model.DeleteTODORequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		w.WriteHeader(http.StatusBadRequest)
+	switch sort := query.Get("sort"); sort {
+	case "id":
+		req.Sort = string(service.SortID)
+	case string(service.SortID), string(service.SortDue), string(service.SortPriority), string(service.SortPosition):
+		req.Sort = sort
+	default:
+		WriteError(w, r, invalidParam("sort", "must be one of id, due, priority or position"))
 		return
 	}
 
-	if len(req.IDs) == 0 {
-		w.WriteHeader(http.StatusBadRequest)
+	// tag は複数指定でき、tag_match で any / all のどちらで一致させるかを選ぶ
+	req.Tags = query["tag"]
+	switch match := query.Get("tag_match"); service.TODOTagMatch(match) {
+	case "":
+		req.TagMatch = string(service.TagMatchAny)
+	case service.TagMatchAny, service.TagMatchAll:
+		req.TagMatch = match
+	default:
+		WriteError(w, r, invalidParam("tag_match", "must be one of any or all"))
 		return
 	}
 
-	err := h.svc.DeleteTODO(r.Context(), req.IDs)
-	if err != nil {
-		if _, ok := err.(*model.ErrNotFound); ok {
-			w.WriteHeader(http.StatusNotFound)
-		} else {
-			w.WriteHeader(http.StatusInternalServerError)
+	// list_id でリストを絞り込む。アーカイブされたリストの TODO は include_archived=true で含める
+	if listID := query.Get("list_id"); listID != "" {
+		id, err := strconv.ParseInt(listID, 10, 64)
+		if err != nil || id <= 0 {
+			WriteError(w, r, invalidParam("list_id", "must be a positive integer"))
+			return
+		}
+		req.ListID = id
+	}
+
+	if archived := query.Get("include_archived"); archived != "" {
+		b, err := strconv.ParseBool(archived)
+		if err != nil {
+			WriteError(w, r, invalidParam("include_archived", "must be true or false"))
+			return
+		}
+		req.IncludeArchived = b
+	}
+
+	// ready=true はすべてのブロッカーが完了した TODO、ready=false はブロックされている TODO
+	if ready := query.Get("ready"); ready != "" {
+		b, err := strconv.ParseBool(ready)
+		if err != nil {
+			WriteError(w, r, invalidParam("ready", "must be true or false"))
+			return
 		}
+		req.Ready = &b
+	}
+
+	// Read メソッドを呼び出し ReadTODOResponse を構築
+	response, err := h.Read(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
 		return
 	}
 
+	// JSON Encode を行い HTTP Response を返す
 	w.Header().Set("Content-Type", "application/json")
 	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(model.DeleteTODOResponse{})
+	json.NewEncoder(w).Encode(response)
+
+}
+
+func (h *TODOHandler) handleSearch(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := model.SearchTODORequest{
+		Query: strings.TrimSpace(query.Get("q")),
+		Size:  10,
+	}
+
+	// q is required
+	if req.Query == "" {
+		WriteError(w, r, invalidParam("q", "is required"))
+		return
+	}
+
+	if prevID := query.Get("prev_id"); prevID != "" {
+		parsedPrevID, err := strconv.ParseInt(prevID, 10, 64)
+		if err != nil {
+			WriteError(w, r, invalidParam("prev_id", "must be an integer"))
+			return
+		}
+		req.PrevID = parsedPrevID
+	}
+
+	if size := query.Get("size"); size != "" {
+		parsedSize, err := strconv.Atoi(size)
+		if err != nil {
+			WriteError(w, r, invalidParam("size", "must be an integer"))
+			return
+		}
+		req.Size = parsedSize
+	}
+
+	response, err := h.Search(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(response)
+}
+
+func (h *TODOHandler) handleTree(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Tree(r.Context(), &model.GetTODOTreeRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleMove(w http.ResponseWriter, r *http.Request, id int64) {
+	var req model.MoveTODORequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	req.ID = id
+
+	// before と after はどちらか一方だけを指定する
+	if (req.Before == 0) == (req.After == 0) {
+		var verr model.ErrValidation
+		verr.Add("before", "exactly one of before and after is required")
+		verr.Add("after", "exactly one of before and after is required")
+		WriteError(w, r, &verr)
+		return
+	}
+
+	resp, err := h.Move(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.TODO.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleComplete(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Complete(r.Context(), &model.CompleteTODORequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.TODO.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleReopen(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Reopen(r.Context(), &model.ReopenTODORequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.TODO.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleDependencies(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Dependencies(r.Context(), &model.GetTODODependenciesRequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleAddDependency(w http.ResponseWriter, r *http.Request, id int64) {
+	var req model.AddTODODependencyRequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	req.ID = id
+
+	if req.BlockedBy <= 0 {
+		WriteError(w, r, invalidParam("blocked_by", "must be a positive integer"))
+		return
+	}
+
+	resp, err := h.AddDependency(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleRemoveDependency(w http.ResponseWriter, r *http.Request, id, blockerID int64) {
+	resp, err := h.RemoveDependency(r.Context(), &model.RemoveTODODependencyRequest{ID: id, BlockerID: blockerID})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleSort(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.Sort(r.Context(), &model.SortTODORequest{})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// maxOccurrences is the most occurrences of a recurring TODO previewed at once.
+const maxOccurrences = 100
+
+func (h *TODOHandler) handleOccurrences(w http.ResponseWriter, r *http.Request, id int64) {
+	req := model.PreviewTODOOccurrencesRequest{ID: id, N: 5}
+
+	if n := r.URL.Query().Get("n"); n != "" {
+		v, err := strconv.Atoi(n)
+		if err != nil || v < 1 || v > maxOccurrences {
+			WriteError(w, r, invalidParam("n", fmt.Sprintf("must be an integer from 1 to %d", maxOccurrences)))
+			return
+		}
+		req.N = v
+	}
+
+	resp, err := h.Occurrences(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleHistory(w http.ResponseWriter, r *http.Request, id int64) {
+	query := r.URL.Query()
+	req := model.GetTODOHistoryRequest{ID: id, Size: 10}
+
+	if prev := query.Get("prev_revision"); prev != "" {
+		n, err := strconv.ParseInt(prev, 10, 64)
+		if err != nil {
+			WriteError(w, r, invalidParam("prev_revision", "must be an integer"))
+			return
+		}
+		req.PrevRevision = n
+	}
+
+	if size := query.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			WriteError(w, r, invalidParam("size", "must be an integer"))
+			return
+		}
+		req.Size = n
+	}
+
+	resp, err := h.History(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleRevert(w http.ResponseWriter, r *http.Request, id int64) {
+	var req model.RevertTODORequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	req.ID = id
+
+	if req.Revision <= 0 {
+		WriteError(w, r, invalidParam("revision", "must be a positive integer"))
+		return
+	}
+
+	req.IfMatch = parseETags(r.Header.Get("If-Match"))
+	resp, err := h.Revert(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.TODO.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleReadTrash(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	req := model.ReadTrashRequest{Size: 10}
+
+	if prevID := query.Get("prev_id"); prevID != "" {
+		id, err := strconv.ParseInt(prevID, 10, 64)
+		if err != nil {
+			WriteError(w, r, invalidParam("prev_id", "must be an integer"))
+			return
+		}
+		req.PrevID = id
+	}
+
+	if size := query.Get("size"); size != "" {
+		n, err := strconv.Atoi(size)
+		if err != nil {
+			WriteError(w, r, invalidParam("size", "must be an integer"))
+			return
+		}
+		req.Size = n
+	}
+
+	resp, err := h.ReadTrash(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleRestore(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Restore(r.Context(), &model.RestoreTODORequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("ETag", resp.TODO.ETag())
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handlePurge(w http.ResponseWriter, r *http.Request, id int64) {
+	resp, err := h.Purge(r.Context(), &model.PurgeTODORequest{ID: id})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleEmptyTrash(w http.ResponseWriter, r *http.Request) {
+	resp, err := h.EmptyTrash(r.Context(), &model.EmptyTrashRequest{})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleDelete(w http.ResponseWriter, r *http.Request) {
+	var req model.DeleteTODORequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	children, err := childPolicy(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+	req.Children = children
+
+	if len(req.IDs) == 0 {
+		WriteError(w, r, invalidParam("ids", "must not be empty"))
+		return
+	}
+
+	resp, err := h.Delete(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleDeleteOne(w http.ResponseWriter, r *http.Request, id int64) {
+	children, err := childPolicy(r)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	resp, err := h.Delete(r.Context(), &model.DeleteTODORequest{
+		IDs:      []int64{id},
+		Children: children,
+		IfMatch:  parseETags(r.Header.Get("If-Match")),
+	})
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+func (h *TODOHandler) handleUndo(w http.ResponseWriter, r *http.Request) {
+	var req model.UndoTODORequest
+	if err := decodeJSON(r, &req); err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	if req.UndoToken == "" {
+		WriteError(w, r, invalidParam("undo_token", "is required"))
+		return
+	}
+
+	resp, err := h.Undo(r.Context(), &req)
+	if err != nil {
+		WriteError(w, r, err)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(http.StatusOK)
+	json.NewEncoder(w).Encode(resp)
+}
+
+// childPolicy returns the children query parameter of a delete request,
+// which tells what to do with the subtasks of the deleted TODOs.
+func childPolicy(r *http.Request) (string, error) {
+	switch children := r.URL.Query().Get("children"); service.ChildPolicy(children) {
+	case "":
+		return string(service.ChildrenReject), nil
+	case service.ChildrenReject, service.ChildrenCascade, service.ChildrenOrphan:
+		return children, nil
+	default:
+		return "", invalidParam("children", "must be one of reject, cascade or orphan")
+	}
+}
+
+// todoValues returns the TODOs as values for a response payload.
+func todoValues(todos []*model.TODO) []model.TODO {
+	values := make([]model.TODO, 0, len(todos))
+	for _, todo := range todos {
+		values = append(values, *todo)
+	}
+	return values
+}
+
+// parseETags parses the comma separated entity tags of an If-Match or If-None-Match header.
+func parseETags(header string) []string {
+	var etags []string
+	for _, etag := range strings.Split(header, ",") {
+		if etag = strings.TrimSpace(etag); etag != "" {
+			etags = append(etags, etag)
+		}
+	}
+	return etags
+}
+
+// matchWeakETag reports whether etag matches one of etags using the weak comparison.
+func matchWeakETag(etags []string, etag string) bool {
+	etag = strings.TrimPrefix(etag, "W/")
+	for _, e := range etags {
+		if e == "*" || strings.TrimPrefix(e, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// mergePatchMediaType is the media type of JSON Merge Patch documents.
+const mergePatchMediaType = "application/merge-patch+json"
+
+// newTODOPatch converts the merge patch into the changes to apply.
+// An absent member is left untouched and null resets the member to its default.
+// All invalid members are reported together in a model.ErrValidation.
+func newTODOPatch(req *model.PatchTODORequest) (*service.TODOPatch, error) {
+	var (
+		p    service.TODOPatch
+		verr model.ErrValidation
+	)
+
+	if req.Subject != nil {
+		// subject は必須項目のため null や空文字にはできない
+		var subject string
+		if isJSONNull(req.Subject) || json.Unmarshal(req.Subject, &subject) != nil || subject == "" {
+			verr.Add("subject", "must be a non-empty string")
+		} else {
+			p.Subject = &subject
+		}
+	}
+
+	if req.Description != nil {
+		var description string
+		if !isJSONNull(req.Description) && json.Unmarshal(req.Description, &description) != nil {
+			verr.Add("description", "must be a string or null")
+		}
+		p.Description = &description
+	}
+
+	if req.Completed != nil {
+		var completed bool
+		if !isJSONNull(req.Completed) && json.Unmarshal(req.Completed, &completed) != nil {
+			verr.Add("completed", "must be a boolean or null")
+		}
+		p.Completed = &completed
+	}
+
+	if req.DueAt != nil {
+		var due sql.NullTime
+		if !isJSONNull(req.DueAt) {
+			if json.Unmarshal(req.DueAt, &due.Time) != nil {
+				verr.Add("due_at", "must be an RFC 3339 date-time or null")
+			}
+			due.Valid = true
+		}
+		p.DueAt = &due
+	}
+
+	if req.Tags != nil {
+		// null はすべてのタグを外す
+		var tags []string
+		if !isJSONNull(req.Tags) && json.Unmarshal(req.Tags, &tags) != nil {
+			verr.Add("tags", "must be an array of strings or null")
+		}
+		if tags == nil {
+			tags = []string{}
+		}
+		p.Tags = &tags
+	}
+
+	if req.Priority != nil {
+		// null は優先度を外す
+		var priority model.Priority
+		if !isJSONNull(req.Priority) && json.Unmarshal(req.Priority, &priority) != nil {
+			verr.Add("priority", "must be a string or null")
+		}
+		p.Priority = &priority
+	}
+
+	if req.ParentID != nil {
+		// null は TODO を最上位に戻す
+		var parentID sql.NullInt64
+		if !isJSONNull(req.ParentID) {
+			if json.Unmarshal(req.ParentID, &parentID.Int64) != nil || parentID.Int64 <= 0 {
+				verr.Add("parent_id", "must be a positive integer or null")
+			}
+			parentID.Valid = true
+		}
+		p.ParentID = &parentID
+	}
+
+	if req.ListID != nil {
+		// null は TODO をリストから外す
+		var listID sql.NullInt64
+		if !isJSONNull(req.ListID) {
+			if json.Unmarshal(req.ListID, &listID.Int64) != nil || listID.Int64 <= 0 {
+				verr.Add("list_id", "must be a positive integer or null")
+			}
+			listID.Valid = true
+		}
+		p.ListID = &listID
+	}
+
+	if req.RRule != nil {
+		// null は繰り返しをやめる
+		var rule string
+		if !isJSONNull(req.RRule) && json.Unmarshal(req.RRule, &rule) != nil {
+			verr.Add("rrule", "must be a string or null")
+		}
+		p.RRule = &rule
+	}
+
+	if len(verr.Fields) > 0 {
+		return nil, &verr
+	}
+	return &p, nil
+}
+
+// isJSONNull reports whether the raw JSON value is null.
+func isJSONNull(raw json.RawMessage) bool {
+	return bytes.Equal(bytes.TrimSpace(raw), []byte("null"))
+}
+
+// pathSegments returns the non-empty segments of path below prefix.
+// For example, pathSegments("/todos/1/", "/todos") returns ["1"].
+func pathSegments(path, prefix string) []string {
+	var segments []string
+	for _, s := range strings.Split(strings.TrimPrefix(path, prefix), "/") {
+		if s != "" {
+			segments = append(segments, s)
+		}
+	}
+	return segments
 }
@@ -0,0 +1,97 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rsa"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+)
+
+// jwk is a JSON Web Key (RFC 7517) with the members of RSA and OKP keys.
+type jwk struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	// RSA (RFC 7518)
+	N string `json:"n"`
+	E string `json:"e"`
+	// OKP (RFC 8037)
+	Crv string `json:"crv"`
+	X   string `json:"x"`
+}
+
+// ParseJWKS returns the keys of a JWK Set (RFC 7517) that verify signatures:
+// RSA keys for RS256 and Ed25519 keys for EdDSA. Keys for encryption, of
+// other types and algorithms, malformed or too weak are skipped, so that one
+// such key does not take down the others of the set. It is an error if no key
+// remains.
+func ParseJWKS(data []byte) ([]*Key, error) {
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := json.Unmarshal(data, &set); err != nil {
+		return nil, fmt.Errorf("jwt: malformed JWK Set: %v", err)
+	}
+
+	var (
+		keys []*Key
+		errs []string
+	)
+	for _, k := range set.Keys {
+		if k.Use != "" && k.Use != "sig" {
+			continue
+		}
+
+		key, err := k.key()
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if key != nil {
+			keys = append(keys, key)
+		}
+	}
+
+	if len(keys) == 0 {
+		if len(errs) > 0 {
+			return nil, fmt.Errorf("jwt: no usable key in the JWK Set: %s", strings.Join(errs, "; "))
+		}
+		return nil, errors.New("jwt: no key in the JWK Set verifies signatures with RS256 or EdDSA")
+	}
+	return keys, nil
+}
+
+// key returns the key k describes, nil if it is of a type or an algorithm that
+// is not supported, or an error if it is malformed or too weak.
+func (k *jwk) key() (*Key, error) {
+	switch {
+	case k.Kty == "RSA" && (k.Alg == "" || k.Alg == RS256):
+		n, err := encoding.DecodeString(k.N)
+		if err != nil {
+			return nil, fmt.Errorf("malformed modulus of key %q: %v", k.Kid, err)
+		}
+		e, err := encoding.DecodeString(k.E)
+		if err != nil || len(e) == 0 || len(e) > 4 {
+			return nil, fmt.Errorf("malformed exponent of key %q", k.Kid)
+		}
+		public := &rsa.PublicKey{
+			N: new(big.Int).SetBytes(n),
+			E: int(new(big.Int).SetBytes(e).Int64()),
+		}
+		return NewRS256PublicKey(k.Kid, public)
+
+	case k.Kty == "OKP" && k.Crv == "Ed25519" && (k.Alg == "" || k.Alg == EdDSA):
+		x, err := encoding.DecodeString(k.X)
+		if err != nil || len(x) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("malformed Ed25519 key %q", k.Kid)
+		}
+		return NewEd25519PublicKey(k.Kid, ed25519.PublicKey(x)), nil
+
+	default:
+		return nil, nil
+	}
+}
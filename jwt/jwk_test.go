@@ -0,0 +1,71 @@
+package jwt
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestParseJWKS(t *testing.T) {
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := NewRS256Key("rs", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, ed := newKeys(t)
+
+	n := encoding.EncodeToString(private.N.Bytes())
+	e := encoding.EncodeToString(big.NewInt(int64(private.E)).Bytes())
+	x := encoding.EncodeToString(ed.public)
+	jwks := fmt.Sprintf(`{"keys":[
+		{"kty":"RSA","kid":"rs","use":"sig","alg":"RS256","n":%q,"e":%q},
+		{"kty":"OKP","kid":"ed","crv":"Ed25519","x":%q},
+		{"kty":"RSA","kid":"enc","use":"enc","n":%[1]q,"e":%[2]q},
+		{"kty":"EC","kid":"ec","crv":"P-256","x":"AA","y":"AA"},
+		{"kty":"RSA","kid":"short","n":"AQAB","e":"AQAB"},
+		{"kty":"OKP","kid":"malformed","crv":"Ed25519","x":"AAAA"}
+	]}`, n, e, x)
+
+	keys, err := ParseJWKS([]byte(jwks))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(keys) != 2 {
+		t.Fatalf("got %d keys, want 2", len(keys))
+	}
+
+	// 公開鍵だけで、それぞれの秘密鍵で署名したトークンを検証できる
+	for i, signing := range []*Key{rs, ed} {
+		ks, err := NewKeySet(signing)
+		if err != nil {
+			t.Fatal(err)
+		}
+		token, err := ks.Sign(validClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		byID := func(kid string) (*Key, error) { return keys[i], nil }
+		if _, err := Verify(token, byID, "todo", now); err != nil {
+			t.Errorf("%s: Verify with the parsed key: %v", signing.ID, err)
+		}
+	}
+
+	// 使える鍵が 1 つも残らなければエラーになる
+	unusable := []string{
+		`{"keys":[{"kty":"OKP","kid":"ed","crv":"Ed25519","x":"AAAA"}]}`,
+		`{"keys":[{"kty":"RSA","kid":"short","n":"AQAB","e":"AQAB"}]}`,
+		`{"keys":[{"kty":"EC","kid":"ec","crv":"P-256","x":"AA","y":"AA"}]}`,
+		`{"keys":[]}`,
+		`{"keys":{}}`,
+	}
+	for _, jwks := range unusable {
+		if _, err := ParseJWKS([]byte(jwks)); err == nil {
+			t.Errorf("ParseJWKS(%s) succeeded", jwks)
+		}
+	}
+}
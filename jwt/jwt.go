@@ -0,0 +1,333 @@
+// Package jwt issues and verifies the JSON Web Tokens (RFC 7519) the server
+// uses as access tokens, signed with HS256 or EdDSA (Ed25519, RFC 8037) in
+// the JWS compact serialization. Each key has an ID carried as "kid" in the
+// header, so that the signing key can be rotated while tokens signed with the
+// previous ones stay valid until they expire. It also verifies RS256, which
+// identity providers commonly sign ID tokens with.
+package jwt
+
+import (
+	"crypto"
+	"crypto/ed25519"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// The supported signing algorithms, as named in the "alg" header.
+const (
+	HS256 = "HS256"
+	RS256 = "RS256"
+	EdDSA = "EdDSA"
+)
+
+const (
+	// minHS256SecretLength is the minimum size of an HS256 secret, the size of
+	// the hash output as RFC 7518 requires.
+	minHS256SecretLength = 32
+	// minRSABits is the minimum size of an RS256 key RFC 7518 requires.
+	minRSABits = 2048
+)
+
+// Errors returned by KeySet.Verify for tokens that are well-formed and
+// correctly signed but not valid now or not meant for the audience.
+var (
+	ErrExpired     = errors.New("jwt: token is expired")
+	ErrNotValidYet = errors.New("jwt: token is not valid yet")
+	ErrAudience    = errors.New("jwt: token is not meant for the audience")
+)
+
+var encoding = base64.RawURLEncoding
+
+// A Key signs and verifies tokens with a single algorithm.
+type Key struct {
+	// ID is carried as "kid" in the header of the tokens the key signs.
+	ID  string
+	alg string
+
+	secret     []byte
+	private    ed25519.PrivateKey
+	public     ed25519.PublicKey
+	rsaPrivate *rsa.PrivateKey
+	rsaPublic  *rsa.PublicKey
+}
+
+// NewHS256Key returns the HMAC SHA-256 key with the secret, which must be at
+// least 32 bytes.
+func NewHS256Key(id string, secret []byte) (*Key, error) {
+	if len(secret) < minHS256SecretLength {
+		return nil, fmt.Errorf("jwt: HS256 secret of %q must be at least %d bytes", id, minHS256SecretLength)
+	}
+	return &Key{ID: id, alg: HS256, secret: secret}, nil
+}
+
+// NewEd25519Key returns the Ed25519 key with the private key.
+func NewEd25519Key(id string, private ed25519.PrivateKey) *Key {
+	return &Key{
+		ID:      id,
+		alg:     EdDSA,
+		private: private,
+		public:  private.Public().(ed25519.PublicKey),
+	}
+}
+
+// NewEd25519PublicKey returns the Ed25519 key that only verifies tokens, e.g.
+// a retired key whose private key has been discarded.
+func NewEd25519PublicKey(id string, public ed25519.PublicKey) *Key {
+	return &Key{ID: id, alg: EdDSA, public: public}
+}
+
+// NewRS256Key returns the RSASSA-PKCS1-v1_5 SHA-256 key with the private key,
+// which must be at least 2048 bits.
+func NewRS256Key(id string, private *rsa.PrivateKey) (*Key, error) {
+	k, err := NewRS256PublicKey(id, &private.PublicKey)
+	if err != nil {
+		return nil, err
+	}
+	k.rsaPrivate = private
+	return k, nil
+}
+
+// NewRS256PublicKey returns the RS256 key that only verifies tokens, e.g. a
+// key published by an identity provider.
+func NewRS256PublicKey(id string, public *rsa.PublicKey) (*Key, error) {
+	if public.N.BitLen() < minRSABits {
+		return nil, fmt.Errorf("jwt: RS256 key %q must be at least %d bits", id, minRSABits)
+	}
+	return &Key{ID: id, alg: RS256, rsaPublic: public}, nil
+}
+
+// Algorithm returns the name of the algorithm of the key.
+func (k *Key) Algorithm() string {
+	return k.alg
+}
+
+// Public returns the public key of an RS256 or EdDSA key, e.g. to publish it
+// in a JWK Set, or nil for an HS256 key, which has none.
+func (k *Key) Public() crypto.PublicKey {
+	switch k.alg {
+	case RS256:
+		return k.rsaPublic
+	case EdDSA:
+		return k.public
+	default:
+		return nil
+	}
+}
+
+// canSign reports whether the key holds what signing needs.
+func (k *Key) canSign() bool {
+	return k.alg == HS256 || k.private != nil || k.rsaPrivate != nil
+}
+
+func (k *Key) sign(input []byte) ([]byte, error) {
+	switch k.alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return mac.Sum(nil), nil
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.SignPKCS1v15(rand.Reader, k.rsaPrivate, crypto.SHA256, digest[:])
+	default:
+		return ed25519.Sign(k.private, input), nil
+	}
+}
+
+func (k *Key) verify(input, signature []byte) bool {
+	switch k.alg {
+	case HS256:
+		mac := hmac.New(sha256.New, k.secret)
+		mac.Write(input)
+		return hmac.Equal(mac.Sum(nil), signature)
+	case RS256:
+		digest := sha256.Sum256(input)
+		return rsa.VerifyPKCS1v15(k.rsaPublic, crypto.SHA256, digest[:], signature) == nil
+	default:
+		return ed25519.Verify(k.public, input, signature)
+	}
+}
+
+// Claims are the claims of a token. The times are in seconds since the Unix
+// epoch, and a zero value leaves the claim out.
+type Claims struct {
+	Issuer    string   `json:"iss,omitempty"`
+	Subject   string   `json:"sub,omitempty"`
+	Audience  Audience `json:"aud,omitempty"`
+	ExpiresAt int64    `json:"exp,omitempty"`
+	NotBefore int64    `json:"nbf,omitempty"`
+	IssuedAt  int64    `json:"iat,omitempty"`
+	ID        string   `json:"jti,omitempty"`
+	// The claims below are those of OpenID Connect ID tokens.
+	AuthorizedParty   string `json:"azp,omitempty"`
+	Nonce             string `json:"nonce,omitempty"`
+	Name              string `json:"name,omitempty"`
+	PreferredUsername string `json:"preferred_username,omitempty"`
+	Email             string `json:"email,omitempty"`
+}
+
+// Audience is the "aud" claim, which is either a single string or an array
+// of strings.
+type Audience []string
+
+// MarshalJSON encodes a single audience as a string.
+func (a Audience) MarshalJSON() ([]byte, error) {
+	if len(a) == 1 {
+		return json.Marshal(a[0])
+	}
+	return json.Marshal([]string(a))
+}
+
+// UnmarshalJSON decodes either form of the audience.
+func (a *Audience) UnmarshalJSON(b []byte) error {
+	var s string
+	if err := json.Unmarshal(b, &s); err == nil {
+		*a = Audience{s}
+		return nil
+	}
+	return json.Unmarshal(b, (*[]string)(a))
+}
+
+// Contains reports whether the audience includes aud.
+func (a Audience) Contains(aud string) bool {
+	for _, s := range a {
+		if s == aud {
+			return true
+		}
+	}
+	return false
+}
+
+type header struct {
+	Alg  string   `json:"alg"`
+	Typ  string   `json:"typ,omitempty"`
+	Kid  string   `json:"kid,omitempty"`
+	Crit []string `json:"crit,omitempty"`
+}
+
+// A KeySet signs tokens with its signing key and verifies tokens signed with
+// any of its keys.
+type KeySet struct {
+	signing *Key
+	keys    map[string]*Key
+}
+
+// NewKeySet returns the key set that signs with the key signing and also
+// verifies with the others, e.g. the keys it replaced. Key IDs must be unique.
+func NewKeySet(signing *Key, others ...*Key) (*KeySet, error) {
+	if !signing.canSign() {
+		return nil, fmt.Errorf("jwt: key %q cannot sign", signing.ID)
+	}
+
+	ks := &KeySet{signing: signing, keys: map[string]*Key{}}
+	for _, k := range append([]*Key{signing}, others...) {
+		if _, ok := ks.keys[k.ID]; ok {
+			return nil, fmt.Errorf("jwt: key ID %q is not unique", k.ID)
+		}
+		ks.keys[k.ID] = k
+	}
+	return ks, nil
+}
+
+// Sign returns the token with the claims signed with the signing key.
+func (ks *KeySet) Sign(c *Claims) (string, error) {
+	h, err := json.Marshal(&header{Alg: ks.signing.alg, Typ: "JWT", Kid: ks.signing.ID})
+	if err != nil {
+		return "", err
+	}
+	payload, err := json.Marshal(c)
+	if err != nil {
+		return "", err
+	}
+
+	input := encoding.EncodeToString(h) + "." + encoding.EncodeToString(payload)
+	signature, err := ks.signing.sign([]byte(input))
+	if err != nil {
+		return "", err
+	}
+	return input + "." + encoding.EncodeToString(signature), nil
+}
+
+// Verify returns the claims of the token if it is signed with one of the
+// keys, carries an expiry and is valid at the time now, and its audience
+// includes aud unless aud is "".
+func (ks *KeySet) Verify(token, aud string, now time.Time) (*Claims, error) {
+	return Verify(token, ks.key, aud, now)
+}
+
+func (ks *KeySet) key(kid string) (*Key, error) {
+	key, ok := ks.keys[kid]
+	if !ok {
+		return nil, fmt.Errorf("jwt: unknown key ID %q", kid)
+	}
+	return key, nil
+}
+
+// A KeyFunc returns the key with the ID kid, which is "" if the token
+// header has none.
+type KeyFunc func(kid string) (*Key, error)
+
+// Verify returns the claims of the token if it is signed with the key that
+// key returns for it, carries an expiry and is valid at the time now, and its
+// audience includes aud unless aud is "".
+func Verify(token string, key KeyFunc, aud string, now time.Time) (*Claims, error) {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		return nil, errors.New("jwt: token is not in the compact serialization")
+	}
+
+	var h header
+	if err := decodeSegment(parts[0], &h); err != nil {
+		return nil, fmt.Errorf("jwt: malformed header: %v", err)
+	}
+	if len(h.Crit) > 0 {
+		return nil, fmt.Errorf("jwt: critical header parameters %v are not supported", h.Crit)
+	}
+
+	k, err := key(h.Kid)
+	if err != nil {
+		return nil, err
+	}
+	// alg はヘッダーではなく鍵で決める (alg=none や HS256 への差し替えを防ぐ)
+	if h.Alg != k.alg {
+		return nil, fmt.Errorf("jwt: algorithm %q does not match key %q", h.Alg, h.Kid)
+	}
+
+	signature, err := encoding.DecodeString(parts[2])
+	if err != nil || !k.verify([]byte(parts[0]+"."+parts[1]), signature) {
+		return nil, errors.New("jwt: invalid signature")
+	}
+
+	var c Claims
+	if err := decodeSegment(parts[1], &c); err != nil {
+		return nil, fmt.Errorf("jwt: malformed claims: %v", err)
+	}
+
+	t := now.Unix()
+	switch {
+	case c.ExpiresAt == 0:
+		return nil, errors.New("jwt: token has no expiry")
+	case t >= c.ExpiresAt:
+		return nil, ErrExpired
+	case t < c.NotBefore:
+		return nil, ErrNotValidYet
+	case aud != "" && !c.Audience.Contains(aud):
+		return nil, ErrAudience
+	}
+	return &c, nil
+}
+
+func decodeSegment(s string, v interface{}) error {
+	b, err := encoding.DecodeString(s)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(b, v)
+}
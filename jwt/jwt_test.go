@@ -0,0 +1,171 @@
+package jwt
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"strings"
+	"testing"
+	"time"
+)
+
+var now = time.Date(2026, 10, 5, 9, 30, 0, 0, time.UTC)
+
+func newKeys(t *testing.T) (hs, ed *Key) {
+	t.Helper()
+
+	hs, err := NewHS256Key("hs", []byte(strings.Repeat("s", 32)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	ed = NewEd25519Key("ed", ed25519.NewKeyFromSeed(make([]byte, ed25519.SeedSize)))
+	return hs, ed
+}
+
+func validClaims() *Claims {
+	return &Claims{
+		Subject:   "1",
+		Audience:  Audience{"todo"},
+		IssuedAt:  now.Unix(),
+		NotBefore: now.Unix(),
+		ExpiresAt: now.Add(time.Minute).Unix(),
+	}
+}
+
+func TestSignAndVerify(t *testing.T) {
+	hs, ed := newKeys(t)
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rs, err := NewRS256Key("rs", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []*Key{hs, rs, ed} {
+		ks, err := NewKeySet(key)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		token, err := ks.Sign(validClaims())
+		if err != nil {
+			t.Fatal(err)
+		}
+		c, err := ks.Verify(token, "todo", now)
+		if err != nil {
+			t.Errorf("%s: Verify: %v", key.Algorithm(), err)
+			continue
+		}
+		if c.Subject != "1" {
+			t.Errorf("%s: subject = %q, want %q", key.Algorithm(), c.Subject, "1")
+		}
+	}
+}
+
+func TestVerifyClaims(t *testing.T) {
+	hs, _ := newKeys(t)
+	ks, err := NewKeySet(hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		edit  func(c *Claims)
+		at    time.Time
+		valid bool
+	}{
+		{"valid", func(c *Claims) {}, now, true},
+		{"expired", func(c *Claims) {}, now.Add(time.Minute), false},
+		{"not yet valid", func(c *Claims) {}, now.Add(-time.Second), false},
+		{"no expiry", func(c *Claims) { c.ExpiresAt = 0 }, now, false},
+		{"other audience", func(c *Claims) { c.Audience = Audience{"other"} }, now, false},
+		{"one of the audiences", func(c *Claims) { c.Audience = Audience{"other", "todo"} }, now, true},
+		{"no audience", func(c *Claims) { c.Audience = nil }, now, false},
+	}
+	for _, tt := range tests {
+		c := validClaims()
+		tt.edit(c)
+		token, err := ks.Sign(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := ks.Verify(token, "todo", tt.at); (err == nil) != tt.valid {
+			t.Errorf("%s: Verify error = %v, want valid %v", tt.name, err, tt.valid)
+		}
+	}
+}
+
+func TestVerifyRejectsForgedTokens(t *testing.T) {
+	hs, ed := newKeys(t)
+	ks, err := NewKeySet(ed, hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := ks.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+	parts := strings.Split(token, ".")
+
+	encode := func(s string) string { return base64.RawURLEncoding.EncodeToString([]byte(s)) }
+	tests := map[string]string{
+		"tampered claims": parts[0] + "." + encode(`{"sub":"2","aud":"todo","exp":9999999999}`) + "." + parts[2],
+		"alg none":        encode(`{"alg":"none","kid":"ed"}`) + "." + parts[1] + ".",
+		// 公開鍵を HMAC の秘密として使わせる攻撃
+		"alg confusion": encode(`{"alg":"HS256","kid":"ed"}`) + "." + parts[1] + "." + parts[2],
+		"unknown kid":   encode(`{"alg":"EdDSA","kid":"gone"}`) + "." + parts[1] + "." + parts[2],
+		"crit":          encode(`{"alg":"EdDSA","kid":"ed","crit":["exp"]}`) + "." + parts[1] + "." + parts[2],
+		"two segments":  parts[0] + "." + parts[1],
+	}
+	for name, forged := range tests {
+		if _, err := ks.Verify(forged, "todo", now); err == nil {
+			t.Errorf("%s: Verify succeeded", name)
+		}
+	}
+}
+
+func TestKeyRotation(t *testing.T) {
+	hs, ed := newKeys(t)
+	old, err := NewKeySet(hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := old.Sign(validClaims())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 署名鍵を ed に替えても、hs で署名済みのトークンは検証できる
+	rotated, err := NewKeySet(ed, hs)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := rotated.Verify(token, "todo", now); err != nil {
+		t.Errorf("Verify with the rotated key set: %v", err)
+	}
+
+	retired, err := NewKeySet(ed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := retired.Verify(token, "todo", now); err == nil {
+		t.Error("Verify succeeded after the key was retired")
+	}
+}
+
+func TestNewKeySet(t *testing.T) {
+	hs, ed := newKeys(t)
+	if _, err := NewKeySet(hs, hs); err == nil {
+		t.Error("NewKeySet accepted duplicate key IDs")
+	}
+	if _, err := NewKeySet(NewEd25519PublicKey("pub", ed.public)); err == nil {
+		t.Error("NewKeySet accepted a public key as the signing key")
+	}
+	if _, err := NewHS256Key("short", []byte("secret")); err == nil {
+		t.Error("NewHS256Key accepted a short secret")
+	}
+}
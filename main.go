@@ -1,14 +1,20 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"io"
 	"log"
+	"net"
 	"net/http"
 	"os"
+	"os/signal"
+	"syscall"
 	"time"
 
 	"github.com/TechBowl-japan/go-stations/db"
-	// "github.com/TechBowl-japan/go-stations/handler/middleware"
 	"github.com/TechBowl-japan/go-stations/handler/router"
+	"github.com/TechBowl-japan/go-stations/service"
 )
 
 func main() {
@@ -20,8 +26,15 @@ func main() {
 func realMain() error {
 	// config values
 	const (
-		defaultPort   = ":8080"
-		defaultDBPath = ".sqlite3/todo.db"
+		defaultPort            = ":8080"
+		defaultDBPath          = ".sqlite3/todo.db"
+		defaultShutdownTimeout = 30 * time.Second
+		defaultTrashRetention  = 30 * 24 * time.Hour
+
+		readHeaderTimeout = 5 * time.Second
+		readTimeout       = 10 * time.Second
+		writeTimeout      = 30 * time.Second
+		idleTimeout       = 120 * time.Second
 	)
 
 	port := os.Getenv("PORT")
@@ -34,6 +47,25 @@ func realMain() error {
 		dbPath = defaultDBPath
 	}
 
+	shutdownTimeout := defaultShutdownTimeout
+	if v := os.Getenv("SHUTDOWN_TIMEOUT"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		shutdownTimeout = d
+	}
+
+	// TRASH_RETENTION=0 はゴミ箱を自動で空にしない
+	trashRetention := defaultTrashRetention
+	if v := os.Getenv("TRASH_RETENTION"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil {
+			return err
+		}
+		trashRetention = d
+	}
+
 	// set time zone
 	var err error
 	time.Local, err = time.LoadLocation("Asia/Tokyo")
@@ -41,6 +73,26 @@ func realMain() error {
 		return err
 	}
 
+	// migrate サブコマンドはスキーマの更新だけを行う
+	if len(os.Args) > 1 && os.Args[1] == "migrate" {
+		return runMigrate(dbPath, os.Args[2:])
+	}
+
+	routerCfg, err := routerConfig()
+	if err != nil {
+		return err
+	}
+
+	// アクセスログのファイルは、サーバーを止めてリクエストが書き終わってから閉じる
+	var accessLog io.Closer
+	routerCfg.AccessLog, accessLog, err = accessLogConfig()
+	if err != nil {
+		return err
+	}
+	if accessLog != nil {
+		defer accessLog.Close()
+	}
+
 	// set up sqlite3
 	todoDB, err := db.NewDB(dbPath)
 	if err != nil {
@@ -49,15 +101,77 @@ func realMain() error {
 	defer todoDB.Close()
 
 	// NOTE: 新しいエンドポイントの登録はrouter.NewRouterの内部で行うようにする
-	mux := router.NewRouter(todoDB)
+	mux, err := router.NewRouterWithConfig(todoDB, routerCfg)
+	if err != nil {
+		return err
+	}
 
-	// recoveredmux := middleware.Recovery(mux)
+	srv := &http.Server{
+		Addr:              port,
+		Handler:           mux,
+		ReadHeaderTimeout: readHeaderTimeout,
+		ReadTimeout:       readTimeout,
+		WriteTimeout:      writeTimeout,
+		IdleTimeout:       idleTimeout,
+	}
+
+	l, err := net.Listen("tcp", port)
+	if err != nil {
+		return err
+	}
+
+	// SIGINT / SIGTERM を受け取ったらシャットダウンする
+	ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+	defer stop()
+
+	purged := make(chan struct{})
+	if trashRetention > 0 {
+		go func() {
+			defer close(purged)
+			purgeTrash(ctx, service.NewTODOService(todoDB), trashRetention)
+		}()
+	} else {
+		close(purged)
+	}
 
 	// サーバーをlistenする
 	log.Printf("Server is listening on %s\n", port)
-	if err := http.ListenAndServe(port, mux); err != nil {
+	err = serve(ctx, srv, l, shutdownTimeout)
+
+	// データベースを閉じる前に、ゴミ箱の自動削除を止めて終わるのを待つ
+	stop()
+	<-purged
+	return err
+}
+
+// serve serves HTTP on l until ctx is done, then shuts srv down gracefully:
+// it stops accepting connections and waits up to timeout for in-flight
+// requests to complete.
+func serve(ctx context.Context, srv *http.Server, l net.Listener, timeout time.Duration) error {
+	errCh := make(chan error, 1)
+	go func() {
+		errCh <- srv.Serve(l)
+	}()
+
+	select {
+	case err := <-errCh:
 		return err
+	case <-ctx.Done():
 	}
 
+	log.Printf("Shutting down the server, waiting up to %s for in-flight requests\n", timeout)
+
+	shutdownCtx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	if err := srv.Shutdown(shutdownCtx); err != nil {
+		// 期限内に終わらなかったリクエストは打ち切る
+		srv.Close()
+		return err
+	}
+
+	if err := <-errCh; !errors.Is(err, http.ErrServerClosed) {
+		return err
+	}
 	return nil
 }
@@ -0,0 +1,107 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestServe(t *testing.T) {
+	t.Parallel()
+
+	cases := map[string]struct {
+		timeout  time.Duration
+		wantCode int
+		wantErr  error
+	}{
+		"In-flight request completes": {timeout: 5 * time.Second, wantCode: http.StatusOK, wantErr: nil},
+		"Shutdown deadline exceeded":  {timeout: 10 * time.Millisecond, wantCode: 0, wantErr: context.DeadlineExceeded},
+	}
+
+	for name, c := range cases {
+		c := c
+		t.Run(name, func(t *testing.T) {
+			t.Parallel()
+
+			started := make(chan struct{})
+			release := make(chan struct{})
+			srv := &http.Server{Handler: http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				close(started)
+				<-release
+				w.Write([]byte("done"))
+			})}
+
+			l, err := net.Listen("tcp", "127.0.0.1:0")
+			if err != nil {
+				t.Fatal("failed to listen, err =", err)
+			}
+			url := "http://" + l.Addr().String()
+
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+
+			served := make(chan error, 1)
+			go func() {
+				served <- serve(ctx, srv, l, c.timeout)
+			}()
+
+			type result struct {
+				code int
+				body string
+			}
+			results := make(chan result, 1)
+			go func() {
+				resp, err := http.Get(url)
+				if err != nil {
+					results <- result{}
+					return
+				}
+				defer resp.Body.Close()
+				b, _ := ioutil.ReadAll(resp.Body)
+				results <- result{code: resp.StatusCode, body: string(b)}
+			}()
+
+			// リクエストの処理中にシャットダウンを開始する
+			<-started
+			cancel()
+
+			// シャットダウン開始後は新しい接続を受け付けない
+			deadline := time.Now().Add(time.Second)
+			for {
+				conn, err := net.Dial("tcp", l.Addr().String())
+				if err != nil {
+					break
+				}
+				conn.Close()
+				if time.Now().After(deadline) {
+					t.Error("server still accepts connections after shutdown started")
+					break
+				}
+				time.Sleep(5 * time.Millisecond)
+			}
+
+			if c.wantErr != nil {
+				// 期限切れまで処理中のリクエストを終わらせない
+				if err := <-served; !errors.Is(err, c.wantErr) {
+					t.Errorf("unexpected error, given = %v, expected = %v", err, c.wantErr)
+				}
+				close(release)
+			} else {
+				close(release)
+				if err := <-served; err != nil {
+					t.Errorf("unexpected error, given = %v, expected = nil", err)
+				}
+			}
+
+			if got := <-results; got.code != c.wantCode {
+				t.Errorf("unexpected status code, given = %d, expected = %d", got.code, c.wantCode)
+			} else if c.wantCode == http.StatusOK && got.body != "done" {
+				t.Errorf("unexpected body, given = %q, expected = %q", got.body, "done")
+			}
+		})
+	}
+}
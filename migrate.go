@@ -0,0 +1,73 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/db"
+)
+
+// runMigrate implements the migrate subcommand.
+//
+//	go run . migrate [-dry-run] [up|status]
+//
+// up applies the pending migrations, or only lists them with -dry-run.
+// status lists every migration with the time it was applied.
+func runMigrate(dbPath string, args []string) error {
+	fs := flag.NewFlagSet("migrate", flag.ContinueOnError)
+	dryRun := fs.Bool("dry-run", false, "list the pending migrations without applying them")
+	if err := fs.Parse(args); err != nil {
+		return err
+	}
+
+	cmd := "up"
+	if fs.NArg() > 0 {
+		cmd = fs.Arg(0)
+	}
+
+	todoDB, err := db.Open(dbPath)
+	if err != nil {
+		return err
+	}
+	defer todoDB.Close()
+
+	switch cmd {
+	case "up":
+		pending, err := db.Migrate(todoDB, *dryRun)
+		if err != nil {
+			return err
+		}
+		if len(pending) == 0 {
+			fmt.Println("database is up to date")
+			return nil
+		}
+		for _, m := range pending {
+			if *dryRun {
+				fmt.Printf("pending %04d_%s\n", m.Version, m.Name)
+			} else {
+				fmt.Printf("applied %04d_%s\n", m.Version, m.Name)
+			}
+		}
+		return nil
+	case "status":
+		statuses, err := db.MigrationStatuses(todoDB)
+		if err != nil {
+			return err
+		}
+		w := tabwriter.NewWriter(os.Stdout, 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "VERSION\tNAME\tAPPLIED AT")
+		for _, s := range statuses {
+			appliedAt := "pending"
+			if s.AppliedAt != nil {
+				appliedAt = s.AppliedAt.Local().Format(time.RFC3339)
+			}
+			fmt.Fprintf(w, "%04d\t%s\t%s\n", s.Version, s.Name, appliedAt)
+		}
+		return w.Flush()
+	default:
+		return fmt.Errorf("unknown migrate command %q", cmd)
+	}
+}
@@ -0,0 +1,70 @@
+package model
+
+import "time"
+
+// A Scope is a permission granted to an APIKey
+type Scope string
+
+// The scopes of API keys. ScopeAdmin grants every other scope too.
+const (
+	ScopeTODOsRead  Scope = "todos:read"
+	ScopeTODOsWrite Scope = "todos:write"
+	ScopeAdmin      Scope = "admin"
+)
+
+type (
+	// An APIKey expresses a key that authenticates scripts as its user.
+	// The key itself is only returned when it is created or rotated.
+	APIKey struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		Prefix     string     `json:"prefix"`
+		Scopes     []Scope    `json:"scopes"`
+		LastUsedAt *time.Time `json:"last_used_at,omitempty"`
+		Revoked    bool       `json:"revoked,omitempty"`
+		RevokedAt  *time.Time `json:"revoked_at,omitempty"`
+		CreatedAt  time.Time  `json:"created_at"`
+		UpdatedAt  time.Time  `json:"updated_at"`
+	}
+
+	// A CreateAPIKeyRequest expresses the request payload for creating a new APIKey
+	CreateAPIKeyRequest struct {
+		Name   string  `json:"name"`
+		Scopes []Scope `json:"scopes"`
+	}
+
+	// A CreateAPIKeyResponse expresses the response payload after creating an APIKey
+	CreateAPIKeyResponse struct {
+		APIKey *APIKey `json:"api_key"`
+		Key    string  `json:"key"`
+	}
+
+	// A ReadAPIKeysRequest expresses the request for reading the APIKeys of the user
+	ReadAPIKeysRequest struct{}
+
+	// A ReadAPIKeysResponse expresses the APIKeys of the user from the newest
+	ReadAPIKeysResponse struct {
+		APIKeys []*APIKey `json:"api_keys"`
+	}
+
+	// A RotateAPIKeyRequest expresses the request for replacing the key of an APIKey
+	RotateAPIKeyRequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A RotateAPIKeyResponse expresses the response payload after rotating an APIKey
+	RotateAPIKeyResponse struct {
+		APIKey *APIKey `json:"api_key"`
+		Key    string  `json:"key"`
+	}
+
+	// A RevokeAPIKeyRequest expresses the request for revoking an APIKey
+	RevokeAPIKeyRequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A RevokeAPIKeyResponse expresses the response payload after revoking an APIKey
+	RevokeAPIKeyResponse struct {
+		APIKey *APIKey `json:"api_key"`
+	}
+)
@@ -1,5 +1,7 @@
 package model
 
+import "strings"
+
 // ErrNotFound is returned when a requested entity is not found.
 type ErrNotFound struct{}
 
@@ -11,3 +13,126 @@ func (e *ErrNotFound) Is(target error) bool {
 	_, ok := target.(*ErrNotFound)
 	return ok
 }
+
+// ErrUnauthorized is returned when a request does not carry valid credentials.
+type ErrUnauthorized struct{}
+
+func (e *ErrUnauthorized) Error() string {
+	return "unauthorized"
+}
+
+func (e *ErrUnauthorized) Is(target error) bool {
+	_, ok := target.(*ErrUnauthorized)
+	return ok
+}
+
+// ErrForbidden is returned when the credentials of a request do not grant what it asks for.
+type ErrForbidden struct {
+	Reason string
+}
+
+func (e *ErrForbidden) Error() string {
+	return "forbidden: " + e.Reason
+}
+
+func (e *ErrForbidden) Is(target error) bool {
+	_, ok := target.(*ErrForbidden)
+	return ok
+}
+
+// ErrPreconditionFailed is returned when a conditional write does not match the current entity.
+type ErrPreconditionFailed struct{}
+
+func (e *ErrPreconditionFailed) Error() string {
+	return "precondition failed"
+}
+
+func (e *ErrPreconditionFailed) Is(target error) bool {
+	_, ok := target.(*ErrPreconditionFailed)
+	return ok
+}
+
+// ErrSearchUnavailable is returned when SQLite is built without FTS5.
+type ErrSearchUnavailable struct{}
+
+func (e *ErrSearchUnavailable) Error() string {
+	return "full-text search is unavailable"
+}
+
+func (e *ErrSearchUnavailable) Is(target error) bool {
+	_, ok := target.(*ErrSearchUnavailable)
+	return ok
+}
+
+// ErrValidation is returned when a request contains invalid values.
+type ErrValidation struct {
+	Fields []FieldError
+}
+
+// A FieldError describes why the value of a single request field is invalid.
+type FieldError struct {
+	Name   string `json:"name"`
+	Reason string `json:"reason"`
+}
+
+// Add records that the field name is invalid for reason.
+func (e *ErrValidation) Add(name, reason string) {
+	e.Fields = append(e.Fields, FieldError{Name: name, Reason: reason})
+}
+
+func (e *ErrValidation) Error() string {
+	msgs := make([]string, 0, len(e.Fields))
+	for _, f := range e.Fields {
+		msgs = append(msgs, f.Name+" "+f.Reason)
+	}
+	return "validation failed: " + strings.Join(msgs, ", ")
+}
+
+func (e *ErrValidation) Is(target error) bool {
+	_, ok := target.(*ErrValidation)
+	return ok
+}
+
+// ErrConflict is returned when a request conflicts with the current state of an entity.
+type ErrConflict struct {
+	Reason string
+}
+
+func (e *ErrConflict) Error() string {
+	return "conflict: " + e.Reason
+}
+
+func (e *ErrConflict) Is(target error) bool {
+	_, ok := target.(*ErrConflict)
+	return ok
+}
+
+// ErrInternal is returned when a request fails for a reason the client cannot fix.
+// Err is logged with the request ID by handler.WriteError but never exposed to the client.
+type ErrInternal struct {
+	Err error
+}
+
+func (e *ErrInternal) Error() string {
+	return "internal error: " + e.Err.Error()
+}
+
+func (e *ErrInternal) Unwrap() error {
+	return e.Err
+}
+
+func (e *ErrInternal) Is(target error) bool {
+	_, ok := target.(*ErrInternal)
+	return ok
+}
+
+// Problem represents an RFC 7807 problem details object, the response body of a failed request.
+type Problem struct {
+	Type          string       `json:"type"`
+	Title         string       `json:"title"`
+	Status        int          `json:"status"`
+	Detail        string       `json:"detail,omitempty"`
+	Instance      string       `json:"instance,omitempty"`
+	RequestID     string       `json:"request_id,omitempty"`
+	InvalidParams []FieldError `json:"invalid-params,omitempty"`
+}
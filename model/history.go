@@ -0,0 +1,43 @@
+package model
+
+import "time"
+
+type (
+	// A TODOEvent expresses a change to a TODO recorded in its history.
+	// Old is null for a created TODO and New is null for a purged one.
+	TODOEvent struct {
+		Revision  int64     `json:"revision"`
+		TODOID    int64     `json:"todo_id"`
+		Action    string    `json:"action"`
+		Old       *TODO     `json:"old"`
+		New       *TODO     `json:"new"`
+		Actor     string    `json:"actor,omitempty"`
+		CreatedAt time.Time `json:"created_at"`
+	}
+
+	// A GetTODOHistoryRequest expresses the request for reading the history of a TODO
+	GetTODOHistoryRequest struct {
+		ID           int64 `json:"-"`
+		PrevRevision int64 `json:"prev_revision"`
+		Size         int   `json:"size"`
+	}
+
+	// A GetTODOHistoryResponse expresses the changes to a TODO from the latest
+	GetTODOHistoryResponse struct {
+		Events []TODOEvent `json:"events"`
+	}
+
+	// A RevertTODORequest expresses the request payload for reverting a TODO to a revision
+	RevertTODORequest struct {
+		ID       int64 `json:"-"`
+		Revision int64 `json:"revision"`
+
+		// IfMatch holds the entity tags of the If-Match header.
+		IfMatch []string `json:"-"`
+	}
+
+	// A RevertTODOResponse expresses the response payload after reverting a TODO
+	RevertTODOResponse struct {
+		TODO TODO `json:"todo"`
+	}
+)
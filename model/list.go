@@ -0,0 +1,77 @@
+package model
+
+import "time"
+
+type (
+	// A List expresses a project that groups TODOs
+	List struct {
+		ID         int64      `json:"id"`
+		Name       string     `json:"name"`
+		Archived   bool       `json:"archived,omitempty"`
+		ArchivedAt *time.Time `json:"archived_at,omitempty"`
+		TODOCount  int64      `json:"todo_count"`
+		CreatedAt  time.Time  `json:"created_at"`
+		UpdatedAt  time.Time  `json:"updated_at"`
+	}
+
+	// A CreateListRequest expresses the request payload for creating a new List
+	CreateListRequest struct {
+		Name string `json:"name"`
+	}
+
+	// A CreateListResponse expresses the response payload after creating a List
+	CreateListResponse struct {
+		List *List `json:"list"`
+	}
+
+	// A GetListRequest expresses the request for reading a single List
+	GetListRequest struct {
+		ID int64 `json:"id"`
+	}
+
+	// A GetListResponse expresses the response payload of a single List
+	GetListResponse struct {
+		List *List `json:"list"`
+	}
+
+	// A ReadListRequest expresses the request for reading the Lists
+	ReadListRequest struct {
+		// IncludeArchived reads the archived Lists too.
+		IncludeArchived bool `json:"include_archived"`
+	}
+
+	// A ReadListResponse expresses the Lists ordered by name
+	ReadListResponse struct {
+		Lists []List `json:"lists"`
+	}
+
+	// A UpdateListRequest expresses the request payload for renaming a List
+	UpdateListRequest struct {
+		ID   int64  `json:"-"`
+		Name string `json:"name"`
+	}
+
+	// A UpdateListResponse expresses the response payload after renaming a List
+	UpdateListResponse struct {
+		List *List `json:"list"`
+	}
+
+	// An ArchiveListRequest expresses the request for archiving or unarchiving a List
+	ArchiveListRequest struct {
+		ID       int64 `json:"-"`
+		Archived bool  `json:"-"`
+	}
+
+	// An ArchiveListResponse expresses the response payload after archiving or unarchiving a List
+	ArchiveListResponse struct {
+		List *List `json:"list"`
+	}
+
+	// A DeleteListRequest expresses the request for deleting a List
+	DeleteListRequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A DeleteListResponse expresses the response payload after deleting a List
+	DeleteListResponse struct{}
+)
@@ -0,0 +1,64 @@
+package model
+
+type (
+	// A Session expresses the tokens of a signed-in user in the form of an
+	// OAuth 2.0 token response. The access token is a JWT sent as a Bearer
+	// token, and the refresh token obtains the next Session when it expires.
+	Session struct {
+		AccessToken  string `json:"access_token"`
+		TokenType    string `json:"token_type"`
+		ExpiresIn    int64  `json:"expires_in"`
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// A LoginRequest expresses the request payload for signing in with a password
+	LoginRequest struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+
+	// A LoginResponse expresses the response payload after signing in
+	LoginResponse struct {
+		Session
+	}
+
+	// A RefreshSessionRequest expresses the request payload for refreshing a Session
+	RefreshSessionRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// A RefreshSessionResponse expresses the response payload after refreshing a Session
+	RefreshSessionResponse struct {
+		Session
+	}
+
+	// A LogoutRequest expresses the request payload for ending a Session
+	LogoutRequest struct {
+		RefreshToken string `json:"refresh_token"`
+	}
+
+	// A LogoutResponse expresses the response payload after ending a Session
+	LogoutResponse struct{}
+)
+
+type (
+	// An OIDCCallbackRequest expresses the request of an OpenID Connect
+	// provider sending the user back after signing in.
+	OIDCCallbackRequest struct {
+		Code  string `json:"code"`
+		State string `json:"state"`
+		Error string `json:"error"`
+
+		// LoginState, Nonce and Verifier are those of the login the browser
+		// started, kept in its cookies.
+		LoginState string `json:"-"`
+		Nonce      string `json:"-"`
+		Verifier   string `json:"-"`
+	}
+
+	// An OIDCCallbackResponse expresses the response payload after signing in
+	// with an OpenID Connect provider
+	OIDCCallbackResponse struct {
+		Session
+	}
+)
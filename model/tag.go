@@ -0,0 +1,57 @@
+package model
+
+type (
+	// A Tag expresses a label attached to TODOs
+	Tag struct {
+		ID        int64  `json:"id"`
+		Name      string `json:"name"`
+		TODOCount int64  `json:"todo_count"`
+	}
+
+	// A CreateTagRequest expresses the request payload for creating a new Tag
+	CreateTagRequest struct {
+		Name string `json:"name"`
+	}
+
+	// A CreateTagResponse expresses the response payload after creating a Tag
+	CreateTagResponse struct {
+		Tag *Tag `json:"tag"`
+	}
+
+	// A GetTagRequest expresses the request for reading a single Tag
+	GetTagRequest struct {
+		ID int64 `json:"id"`
+	}
+
+	// A GetTagResponse expresses the response payload of a single Tag
+	GetTagResponse struct {
+		Tag *Tag `json:"tag"`
+	}
+
+	// A ReadTagRequest expresses the request for reading all Tags
+	ReadTagRequest struct{}
+
+	// A ReadTagResponse expresses the Tags ordered by name
+	ReadTagResponse struct {
+		Tags []Tag `json:"tags"`
+	}
+
+	// A UpdateTagRequest expresses the request payload for renaming a Tag
+	UpdateTagRequest struct {
+		ID   int64  `json:"-"`
+		Name string `json:"name"`
+	}
+
+	// A UpdateTagResponse expresses the response payload after renaming a Tag
+	UpdateTagResponse struct {
+		Tag *Tag `json:"tag"`
+	}
+
+	// A DeleteTagRequest expresses the request for deleting a Tag
+	DeleteTagRequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A DeleteTagResponse expresses the response payload after deleting a Tag
+	DeleteTagResponse struct{}
+)
@@ -1,21 +1,72 @@
 package model
 
-import "time"
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"fmt"
+	"time"
+)
+
+// A Priority expresses how important a TODO is
+type Priority string
+
+// The priorities from the lowest. A TODO has PriorityNone unless set.
+const (
+	PriorityNone   Priority = ""
+	PriorityLow    Priority = "low"
+	PriorityMedium Priority = "medium"
+	PriorityHigh   Priority = "high"
+)
 
 type (
 	// A TODO expresses a task with its metadata
 	TODO struct {
-		ID          int64     `json:"id"`
-		Subject     string    `json:"subject"`
-		Description string    `json:"description"`
-		CreatedAt   time.Time `json:"created_at"`
-		UpdatedAt   time.Time `json:"updated_at"`
+		ID          int64      `json:"id"`
+		Subject     string     `json:"subject"`
+		Description string     `json:"description"`
+		Completed   bool       `json:"completed"`
+		CompletedAt *time.Time `json:"completed_at,omitempty"`
+		DueAt       *time.Time `json:"due_at,omitempty"`
+		Tags        []string   `json:"tags,omitempty"`
+		Priority    Priority   `json:"priority,omitempty"`
+		ParentID    *int64     `json:"parent_id,omitempty"`
+		ListID      *int64     `json:"list_id,omitempty"`
+		RRule       string     `json:"rrule,omitempty"`
+		Progress    *Progress  `json:"progress,omitempty"`
+		BlockedBy   []int64    `json:"blocked_by,omitempty"`
+		DeletedAt   *time.Time `json:"deleted_at,omitempty"`
+		CreatedAt   time.Time  `json:"created_at"`
+		UpdatedAt   time.Time  `json:"updated_at"`
+
+		// Position is the place of the TODO in the manual order of its owner.
+		// It is not part of the representation, and is only read for the
+		// snapshots kept in the history, which undo restores.
+		Position string `json:"-"`
+	}
+
+	// A Progress expresses how many of all the subtasks of a TODO, including
+	// the subtasks of its subtasks, are completed
+	Progress struct {
+		Completed int64 `json:"completed"`
+		Total     int64 `json:"total"`
+	}
+
+	// A TODONode expresses a TODO with its subtasks in the manual order
+	TODONode struct {
+		TODO
+		Children []TODONode `json:"children"`
 	}
 
 	// A CreateTODORequest expresses the request payload for creating a new TODO
 	CreateTODORequest struct {
-		Subject     string `json:"subject"`
-		Description string `json:"description"`
+		Subject     string     `json:"subject"`
+		Description string     `json:"description"`
+		DueAt       *time.Time `json:"due_at"`
+		Tags        []string   `json:"tags"`
+		Priority    Priority   `json:"priority"`
+		ParentID    int64      `json:"parent_id"`
+		ListID      int64      `json:"list_id"`
+		RRule       string     `json:"rrule"`
 	}
 
 	// A CreateTODOResponse expresses the response payload after creating a TODO
@@ -23,10 +74,37 @@ type (
 		TODO *TODO `json:"todo"`
 	}
 
+	// A GetTODORequest expresses the request for reading a single TODO
+	GetTODORequest struct {
+		ID int64 `json:"id"`
+	}
+
+	// A GetTODOResponse expresses the response payload of a single TODO
+	GetTODOResponse struct {
+		TODO *TODO `json:"todo"`
+	}
+
 	// A ReadTODORequest expresses ...
 	ReadTODORequest struct {
-		PrevID int64 `json:"prev_id"`
-		Size   int   `json:"size"`
+		PrevID    int64         `json:"prev_id"`
+		Size      int           `json:"size"`
+		Status    string        `json:"status"`
+		Due       string        `json:"due"`
+		DueWithin time.Duration `json:"due_within"`
+		Sort      string        `json:"sort"`
+		Tags      []string      `json:"tag"`
+		TagMatch  string        `json:"tag_match"`
+		ListID    int64         `json:"list_id"`
+
+		// IncludeArchived reads the TODOs of archived Lists too.
+		IncludeArchived bool `json:"include_archived"`
+
+		// Ready, if not nil, reads the TODOs that are ready to start, or if
+		// false the TODOs blocked by ones not completed yet.
+		Ready *bool `json:"ready"`
+
+		// ParentID, if not 0, reads the subtasks of the TODO.
+		ParentID int64 `json:"-"`
 	}
 
 	// A ReadTODOResponse expresses ...
@@ -34,26 +112,266 @@ type (
 		TODOs []TODO `json:"todos"`
 	}
 
+	// A SearchTODORequest expresses the query of a full-text search
+	SearchTODORequest struct {
+		Query  string `json:"q"`
+		PrevID int64  `json:"prev_id"`
+		Size   int    `json:"size"`
+	}
+
+	// A SearchTODOHit expresses a TODO matched by a full-text search
+	SearchTODOHit struct {
+		TODO    TODO    `json:"todo"`
+		Score   float64 `json:"score"`
+		Snippet string  `json:"snippet"`
+	}
+
+	// A SearchTODOResponse expresses the hits ordered from the best match
+	SearchTODOResponse struct {
+		Hits []SearchTODOHit `json:"hits"`
+	}
+
 	// A UpdateTODORequest expresses ...
 	UpdateTODORequest struct {
 		//11
-		ID          int    `json:"id"`
-		Subject     string `json:"subject"`
-		Description string `json:"description"`
+		ID          int        `json:"id"`
+		Subject     string     `json:"subject"`
+		Description string     `json:"description"`
+		Completed   *bool      `json:"completed"`
+		DueAt       *time.Time `json:"due_at"`
+		Tags        []string   `json:"tags"`
+		Priority    *Priority  `json:"priority"`
+		ParentID    *int64     `json:"parent_id"`
+		ListID      *int64     `json:"list_id"`
+		RRule       *string    `json:"rrule"`
+
+		// IfMatch holds the entity tags of the If-Match header.
+		IfMatch []string `json:"-"`
 	}
 
 	// A UpdateTODOResponse expresses ...
 	UpdateTODOResponse struct {
 		//11
 		TODO TODO `json:"todo"`
+
+		// UndoToken undoes the update with an UndoTODORequest for a while.
+		UndoToken string `json:"undo_token,omitempty"`
+	}
+
+	// A PatchTODORequest expresses the JSON Merge Patch (RFC 7396) payload for a TODO.
+	// Each member keeps its raw JSON so that an absent member (nil) can be told apart from null.
+	PatchTODORequest struct {
+		ID          int64           `json:"-"`
+		Subject     json.RawMessage `json:"subject"`
+		Description json.RawMessage `json:"description"`
+		Completed   json.RawMessage `json:"completed"`
+		DueAt       json.RawMessage `json:"due_at"`
+		Tags        json.RawMessage `json:"tags"`
+		Priority    json.RawMessage `json:"priority"`
+		ParentID    json.RawMessage `json:"parent_id"`
+		ListID      json.RawMessage `json:"list_id"`
+		RRule       json.RawMessage `json:"rrule"`
+
+		// IfMatch holds the entity tags of the If-Match header.
+		IfMatch []string `json:"-"`
+	}
+
+	// A PatchTODOResponse expresses the response payload after patching a TODO
+	PatchTODOResponse struct {
+		TODO TODO `json:"todo"`
+
+		// UndoToken undoes the patch with an UndoTODORequest for a while.
+		UndoToken string `json:"undo_token,omitempty"`
+	}
+
+	// A MoveTODORequest expresses the request payload for moving a TODO in the manual order.
+	// Exactly one of Before and After is the id of the TODO to place it next to.
+	MoveTODORequest struct {
+		ID     int64 `json:"-"`
+		Before int64 `json:"before"`
+		After  int64 `json:"after"`
+	}
+
+	// A MoveTODOResponse expresses the response payload after moving a TODO
+	MoveTODOResponse struct {
+		TODO TODO `json:"todo"`
+		// UndoToken moves the TODO back with an UndoTODORequest for a while.
+		UndoToken string `json:"undo_token,omitempty"`
+	}
+
+	// A CompleteTODORequest expresses the request for marking a TODO as completed
+	CompleteTODORequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A CompleteTODOResponse expresses the response payload after completing a TODO
+	CompleteTODOResponse struct {
+		TODO TODO `json:"todo"`
+	}
+
+	// A ReopenTODORequest expresses the request for marking a TODO as not completed
+	ReopenTODORequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A ReopenTODOResponse expresses the response payload after reopening a TODO
+	ReopenTODOResponse struct {
+		TODO TODO `json:"todo"`
+	}
+
+	// A GetTODOTreeRequest expresses the request for reading a TODO with all its subtasks
+	GetTODOTreeRequest struct {
+		ID int64 `json:"id"`
+	}
+
+	// A GetTODOTreeResponse expresses the tree of a TODO and its subtasks
+	GetTODOTreeResponse struct {
+		TODO TODONode `json:"todo"`
+	}
+
+	// A Dependency expresses that the TODO BlockedID cannot start until the TODO BlockerID is completed
+	Dependency struct {
+		BlockerID int64 `json:"blocker_id"`
+		BlockedID int64 `json:"blocked_id"`
+	}
+
+	// A GetTODODependenciesRequest expresses the request for reading the dependencies of a TODO
+	GetTODODependenciesRequest struct {
+		ID int64 `json:"id"`
+	}
+
+	// A GetTODODependenciesResponse expresses the TODOs a TODO is blocked by and the TODOs it blocks
+	GetTODODependenciesResponse struct {
+		BlockedBy []TODO `json:"blocked_by"`
+		Blocks    []TODO `json:"blocks"`
+	}
+
+	// An AddTODODependencyRequest expresses the request payload for making a TODO blocked by another
+	AddTODODependencyRequest struct {
+		ID        int64 `json:"-"`
+		BlockedBy int64 `json:"blocked_by"`
+	}
+
+	// An AddTODODependencyResponse expresses the dependencies of a TODO after adding one
+	AddTODODependencyResponse struct {
+		BlockedBy []TODO `json:"blocked_by"`
+		Blocks    []TODO `json:"blocks"`
+	}
+
+	// A RemoveTODODependencyRequest expresses the request for making a TODO no longer blocked by another
+	RemoveTODODependencyRequest struct {
+		ID        int64 `json:"id"`
+		BlockerID int64 `json:"blocker_id"`
+	}
+
+	// A RemoveTODODependencyResponse expresses ...
+	RemoveTODODependencyResponse struct {
+	}
+
+	// A SortTODORequest expresses the request for exporting all the TODOs in dependency order
+	SortTODORequest struct {
+	}
+
+	// A SortTODOResponse expresses all the TODOs in topological order, each blocker before the
+	// TODOs it blocks, with the dependencies between them
+	SortTODOResponse struct {
+		TODOs        []TODO       `json:"todos"`
+		Dependencies []Dependency `json:"dependencies"`
+	}
+
+	// A PreviewTODOOccurrencesRequest expresses the request for previewing the next occurrences of a recurring TODO
+	PreviewTODOOccurrencesRequest struct {
+		ID int64 `json:"-"`
+		N  int   `json:"n"`
+	}
+
+	// A PreviewTODOOccurrencesResponse expresses the due dates of the next occurrences of a recurring TODO
+	PreviewTODOOccurrencesResponse struct {
+		Occurrences []time.Time `json:"occurrences"`
+	}
+
+	// A ReadTrashRequest expresses the request for reading the trashed TODOs
+	ReadTrashRequest struct {
+		PrevID int64 `json:"prev_id"`
+		Size   int   `json:"size"`
+	}
+
+	// A ReadTrashResponse expresses the trashed TODOs from the most recently deleted
+	ReadTrashResponse struct {
+		TODOs []TODO `json:"todos"`
+	}
+
+	// A RestoreTODORequest expresses the request for taking a TODO out of the trash
+	RestoreTODORequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A RestoreTODOResponse expresses the response payload after restoring a TODO
+	RestoreTODOResponse struct {
+		TODO TODO `json:"todo"`
+	}
+
+	// A PurgeTODORequest expresses the request for permanently deleting a trashed TODO
+	PurgeTODORequest struct {
+		ID int64 `json:"-"`
+	}
+
+	// A PurgeTODOResponse expresses ...
+	PurgeTODOResponse struct {
+	}
+
+	// An EmptyTrashRequest expresses the request for permanently deleting all the trashed TODOs
+	EmptyTrashRequest struct {
+	}
+
+	// An EmptyTrashResponse expresses how many TODOs were permanently deleted
+	EmptyTrashResponse struct {
+		Purged int64 `json:"purged"`
 	}
 
 	// A DeleteTODORequest expresses ...
 	DeleteTODORequest struct {
 		IDs []int64 `json:"ids"`
+
+		// Children tells what to do with the subtasks of the deleted TODOs:
+		// "reject" (default), "cascade" or "orphan".
+		Children string `json:"-"`
+
+		// IfMatch holds the entity tags of the If-Match header.
+		// It is only honored when a single TODO is deleted.
+		IfMatch []string `json:"-"`
 	}
 
 	// A DeleteTODOResponse expresses ...
 	DeleteTODOResponse struct {
+		// UndoToken restores the deleted TODOs with an UndoTODORequest for a while.
+		UndoToken string `json:"undo_token,omitempty"`
+	}
+
+	// An UndoTODORequest expresses the request payload for undoing a delete or an update
+	UndoTODORequest struct {
+		UndoToken string `json:"undo_token"`
+	}
+
+	// An UndoTODOResponse expresses the TODOs restored by an undo
+	UndoTODOResponse struct {
+		TODOs []TODO `json:"todos"`
 	}
 )
+
+// ETag returns the strong entity tag of the TODO, derived from the JSON
+// representation of its own columns. Tags, Progress and BlockedBy are left
+// out, so that changing a subtask, a blocker or the name of a tag does not
+// change the tag of the TODO; changing the tags of the TODO itself advances
+// UpdatedAt.
+func (t *TODO) ETag() string {
+	own := *t
+	own.Tags, own.Progress, own.BlockedBy = nil, nil, nil
+
+	b, err := json.Marshal(&own)
+	if err != nil {
+		return ""
+	}
+	sum := sha256.Sum256(b)
+	return fmt.Sprintf(`"%x"`, sum[:12])
+}
@@ -0,0 +1,11 @@
+package model
+
+import "time"
+
+// A User expresses an account that owns TODOs
+type User struct {
+	ID        int64     `json:"id"`
+	Name      string    `json:"name"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
@@ -0,0 +1,106 @@
+package oidc
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/jwt"
+)
+
+const (
+	// jwksTTL is how long the keys of the provider are cached.
+	jwksTTL = time.Hour
+	// jwksMinRefresh is how often an unknown key ID fetches the keys again at
+	// most, so that a provider that has rotated its keys is followed at once
+	// but forged key IDs cannot make every request fetch them.
+	jwksMinRefresh = time.Minute
+	// jwksRetry is how long a failure to fetch the keys is remembered, so
+	// that a provider that is down is not asked again by every request.
+	jwksRetry = 10 * time.Second
+)
+
+var errUnknownKey = errors.New("oidc: unknown key")
+
+// A keyCache holds the JWK Set of a provider.
+type keyCache struct {
+	uri    string
+	client *http.Client
+	// now returns the current time, replaced in tests.
+	now func() time.Time
+
+	mu        sync.Mutex
+	keys      []*jwt.Key
+	fetchedAt time.Time
+	// err is the failure of the last fetch at failedAt, nil if it succeeded.
+	err      error
+	failedAt time.Time
+}
+
+// key returns the key with the ID kid, fetching the keys again if they are
+// stale or kid is not among them. A token without a key ID is verified with
+// the only key of the provider, if it has one. A failed fetch is not retried
+// for jwksRetry, and its error is returned meanwhile unless a cached key
+// serves.
+func (c *keyCache) key(ctx context.Context, kid string) (*jwt.Key, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	if c.now != nil {
+		now = c.now()
+	}
+
+	key := c.find(kid)
+	age := now.Sub(c.fetchedAt)
+	if c.keys == nil || age >= jwksTTL || key == nil && age >= jwksMinRefresh {
+		// 取得に失敗しても、期限切れのキャッシュに鍵があればそれを使う
+		if c.err == nil || now.Sub(c.failedAt) >= jwksRetry {
+			c.err = c.fetch(ctx, now)
+			if c.err != nil {
+				c.failedAt = now
+			}
+		}
+		if c.err != nil && key == nil {
+			return nil, c.err
+		}
+		if k := c.find(kid); k != nil {
+			key = k
+		}
+	}
+
+	if key == nil {
+		return nil, fmt.Errorf("%w %q", errUnknownKey, kid)
+	}
+	return key, nil
+}
+
+func (c *keyCache) find(kid string) *jwt.Key {
+	if kid == "" && len(c.keys) == 1 {
+		return c.keys[0]
+	}
+	for _, k := range c.keys {
+		if k.ID == kid {
+			return k
+		}
+	}
+	return nil
+}
+
+func (c *keyCache) fetch(ctx context.Context, now time.Time) error {
+	body, err := get(ctx, c.client, c.uri)
+	if err != nil {
+		return fmt.Errorf("oidc: fetching keys: %v", err)
+	}
+
+	keys, err := jwt.ParseJWKS(body)
+	if err != nil {
+		return err
+	}
+	c.keys = keys
+	c.fetchedAt = now
+	return nil
+}
@@ -0,0 +1,320 @@
+// Package oidc signs users in with an OpenID Connect provider by the
+// authorization code flow with PKCE (RFC 7636). The endpoints of the provider
+// are found by discovery, and its ID tokens are verified with its JWK Set,
+// which is cached.
+package oidc
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/jwt"
+)
+
+const (
+	// defaultTimeout bounds each request to the provider unless
+	// Config.HTTPClient is given.
+	defaultTimeout = 10 * time.Second
+	// maxResponseSize bounds the responses read from the provider.
+	maxResponseSize = 1 << 20
+)
+
+// ErrInvalidIDToken is returned by Client.VerifyIDToken for an ID token that
+// is not valid, as opposed to failing to reach the provider.
+var ErrInvalidIDToken = errors.New("oidc: invalid ID token")
+
+// An Error is an OAuth 2.0 error (RFC 6749) the provider answered with, e.g.
+// "invalid_grant" for an authorization code that is expired or used.
+type Error struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *Error) Error() string {
+	if e.Description == "" {
+		return "oidc: " + e.Code
+	}
+	return "oidc: " + e.Code + ": " + e.Description
+}
+
+// Config configures a Client.
+type Config struct {
+	// Issuer is the issuer identifier of the provider, the URL its discovery
+	// document is found under.
+	Issuer string
+	// ClientID and ClientSecret are the credentials of the client registered
+	// with the provider. ClientSecret is "" for a public client.
+	ClientID     string
+	ClientSecret string
+	// RedirectURL is the registered URL the provider sends the user back to.
+	RedirectURL string
+	// Scopes are requested in addition to "openid".
+	Scopes []string
+	// HTTPClient makes the requests to the provider, defaulting to a client
+	// with a timeout of 10 seconds.
+	HTTPClient *http.Client
+}
+
+// A Provider is the part of the discovery document of a provider the
+// Client uses.
+type Provider struct {
+	Issuer                        string   `json:"issuer"`
+	AuthorizationEndpoint         string   `json:"authorization_endpoint"`
+	TokenEndpoint                 string   `json:"token_endpoint"`
+	JWKSURI                       string   `json:"jwks_uri"`
+	CodeChallengeMethodsSupported []string `json:"code_challenge_methods_supported"`
+}
+
+// A Token is the response of the token endpoint.
+type Token struct {
+	AccessToken string `json:"access_token"`
+	TokenType   string `json:"token_type"`
+	IDToken     string `json:"id_token"`
+}
+
+// A Client signs users in with a provider. The provider is discovered on
+// first use rather than by NewClient, so that the server starts while the
+// provider is unreachable.
+type Client struct {
+	cfg    Config
+	client *http.Client
+
+	mu       sync.Mutex
+	provider *Provider
+	keys     *keyCache
+}
+
+// NewClient returns new Client.
+func NewClient(cfg Config) *Client {
+	client := cfg.HTTPClient
+	if client == nil {
+		client = &http.Client{Timeout: defaultTimeout}
+	}
+	return &Client{
+		cfg:    cfg,
+		client: client,
+	}
+}
+
+// Issuer returns the issuer identifier of the provider.
+func (c *Client) Issuer() string {
+	return c.cfg.Issuer
+}
+
+// Provider returns the discovered endpoints of the provider. A failed
+// discovery is tried again on the next call.
+func (c *Client) Provider(ctx context.Context) (*Provider, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.provider != nil {
+		return c.provider, nil
+	}
+
+	body, err := get(ctx, c.client, strings.TrimSuffix(c.cfg.Issuer, "/")+"/.well-known/openid-configuration")
+	if err != nil {
+		return nil, fmt.Errorf("oidc: discovery: %v", err)
+	}
+	var p Provider
+	if err := json.Unmarshal(body, &p); err != nil {
+		return nil, fmt.Errorf("oidc: discovery: malformed document: %v", err)
+	}
+
+	switch {
+	// 別の issuer を名乗る応答は受け付けない (OpenID Connect Discovery 4.3)
+	case p.Issuer != c.cfg.Issuer:
+		return nil, fmt.Errorf("oidc: discovery: issuer %q does not match %q", p.Issuer, c.cfg.Issuer)
+	case p.AuthorizationEndpoint == "", p.TokenEndpoint == "", p.JWKSURI == "":
+		return nil, errors.New("oidc: discovery: the document lacks an endpoint")
+	case len(p.CodeChallengeMethodsSupported) > 0 && !contains(p.CodeChallengeMethodsSupported, "S256"):
+		return nil, errors.New("oidc: discovery: the provider does not support PKCE with S256")
+	}
+
+	c.provider = &p
+	c.keys = &keyCache{uri: p.JWKSURI, client: c.client}
+	return c.provider, nil
+}
+
+// AuthCodeURL returns the URL of the provider the user is sent to for signing
+// in. state and nonce are checked when the user comes back, and verifier is
+// the PKCE code verifier that Exchange needs.
+func (c *Client) AuthCodeURL(ctx context.Context, state, nonce, verifier string) (string, error) {
+	p, err := c.Provider(ctx)
+	if err != nil {
+		return "", err
+	}
+
+	q := url.Values{
+		"response_type":         {"code"},
+		"client_id":             {c.cfg.ClientID},
+		"redirect_uri":          {c.cfg.RedirectURL},
+		"scope":                 {strings.Join(append([]string{"openid"}, c.cfg.Scopes...), " ")},
+		"state":                 {state},
+		"nonce":                 {nonce},
+		"code_challenge":        {Challenge(verifier)},
+		"code_challenge_method": {"S256"},
+	}
+
+	sep := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		sep = "&"
+	}
+	return p.AuthorizationEndpoint + sep + q.Encode(), nil
+}
+
+// Exchange exchanges the authorization code for a Token, proving with the
+// verifier that the code was requested by this client.
+func (c *Client) Exchange(ctx context.Context, code, verifier string) (*Token, error) {
+	p, err := c.Provider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	form := url.Values{
+		"grant_type":    {"authorization_code"},
+		"code":          {code},
+		"redirect_uri":  {c.cfg.RedirectURL},
+		"code_verifier": {verifier},
+	}
+	if c.cfg.ClientSecret == "" {
+		form.Set("client_id", c.cfg.ClientID)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.TokenEndpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if c.cfg.ClientSecret != "" {
+		// client_secret_basic では資格情報を URL エンコードしてから Basic 認証にする (RFC 6749 2.3.1)
+		req.SetBasicAuth(url.QueryEscape(c.cfg.ClientID), url.QueryEscape(c.cfg.ClientSecret))
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %v", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+	if err != nil {
+		return nil, fmt.Errorf("oidc: token request: %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		var oerr Error
+		if json.Unmarshal(body, &oerr) == nil && oerr.Code != "" {
+			return nil, &oerr
+		}
+		return nil, fmt.Errorf("oidc: token request: %s", resp.Status)
+	}
+
+	var token Token
+	if err := json.Unmarshal(body, &token); err != nil {
+		return nil, fmt.Errorf("oidc: malformed token response: %v", err)
+	}
+	if token.IDToken == "" {
+		return nil, errors.New("oidc: the token response lacks an ID token")
+	}
+	return &token, nil
+}
+
+// VerifyIDToken returns the claims of the ID token if it is signed with a key
+// of the provider, issued by it for this client, valid now, and carries the
+// nonce. It returns an error wrapping ErrInvalidIDToken if the token is not
+// valid, and another error if the keys of the provider cannot be fetched.
+func (c *Client) VerifyIDToken(ctx context.Context, token, nonce string) (*jwt.Claims, error) {
+	p, err := c.Provider(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	var fetchErr error
+	key := func(kid string) (*jwt.Key, error) {
+		k, err := c.keys.key(ctx, kid)
+		if err != nil && !errors.Is(err, errUnknownKey) {
+			fetchErr = err
+		}
+		return k, err
+	}
+
+	claims, err := jwt.Verify(token, key, c.cfg.ClientID, time.Now())
+	if fetchErr != nil {
+		return nil, fetchErr
+	}
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrInvalidIDToken, err)
+	}
+
+	switch {
+	case claims.Issuer != p.Issuer:
+		return nil, fmt.Errorf("%w: issuer %q is not the provider", ErrInvalidIDToken, claims.Issuer)
+	case claims.Subject == "":
+		return nil, fmt.Errorf("%w: no subject", ErrInvalidIDToken)
+	// 複数の audience があるときは azp で自分宛てと確かめる (OpenID Connect Core 3.1.3.7)
+	case len(claims.Audience) > 1 && claims.AuthorizedParty == "",
+		claims.AuthorizedParty != "" && claims.AuthorizedParty != c.cfg.ClientID:
+		return nil, fmt.Errorf("%w: authorized party %q is not the client", ErrInvalidIDToken, claims.AuthorizedParty)
+	case nonce == "" || subtle.ConstantTimeCompare([]byte(claims.Nonce), []byte(nonce)) != 1:
+		return nil, fmt.Errorf("%w: nonce does not match", ErrInvalidIDToken)
+	}
+	return claims, nil
+}
+
+// get returns the body of the JSON document at the URL.
+func get(ctx context.Context, client *http.Client, u string) ([]byte, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GET %s: %s", u, resp.Status)
+	}
+	return io.ReadAll(io.LimitReader(resp.Body, maxResponseSize))
+}
+
+// RandomValue returns a random value of 43 URL-safe characters, fit for a
+// state, a nonce and a PKCE code verifier.
+func RandomValue() (string, error) {
+	var b [32]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(b[:]), nil
+}
+
+// Challenge returns the S256 PKCE code challenge of the verifier.
+func Challenge(verifier string) string {
+	sum := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(sum[:])
+}
+
+func contains(ss []string, s string) bool {
+	for _, v := range ss {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,430 @@
+package oidc
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/jwt"
+)
+
+const (
+	clientID     = "todo-app"
+	clientSecret = "s3cr:et"
+	redirectURL  = "http://app.example/auth/oidc/callback"
+)
+
+// fakeIdP is an OpenID Connect provider serving discovery, authorization,
+// token and JWKS endpoints in process.
+type fakeIdP struct {
+	*httptest.Server
+	t *testing.T
+
+	mu          sync.Mutex
+	signing     *jwt.Key
+	published   []*jwt.Key
+	codes       map[string]authRequest
+	jwksFetches int
+	jwksDown    bool
+}
+
+type authRequest struct {
+	challenge, nonce string
+}
+
+func newFakeIdP(t *testing.T) *fakeIdP {
+	t.Helper()
+
+	private, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, err := jwt.NewRS256Key("rsa-1", private)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idp := &fakeIdP{t: t, signing: key, published: []*jwt.Key{key}, codes: map[string]authRequest{}}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", idp.discovery)
+	mux.HandleFunc("/authorize", idp.authorize)
+	mux.HandleFunc("/token", idp.token)
+	mux.HandleFunc("/jwks", idp.jwks)
+	idp.Server = httptest.NewServer(mux)
+	t.Cleanup(idp.Close)
+	return idp
+}
+
+func (idp *fakeIdP) discovery(w http.ResponseWriter, r *http.Request) {
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"issuer":                           idp.URL,
+		"authorization_endpoint":           idp.URL + "/authorize",
+		"token_endpoint":                   idp.URL + "/token",
+		"jwks_uri":                         idp.URL + "/jwks",
+		"code_challenge_methods_supported": []string{"S256"},
+	})
+}
+
+// authorize signs the user in at once and sends them back with a code.
+func (idp *fakeIdP) authorize(w http.ResponseWriter, r *http.Request) {
+	q := r.URL.Query()
+	if q.Get("response_type") != "code" || q.Get("client_id") != clientID || q.Get("redirect_uri") != redirectURL ||
+		q.Get("code_challenge_method") != "S256" || q.Get("scope") != "openid profile" {
+		http.Error(w, "invalid authorization request", http.StatusBadRequest)
+		return
+	}
+
+	code, _ := RandomValue()
+	idp.mu.Lock()
+	idp.codes[code] = authRequest{challenge: q.Get("code_challenge"), nonce: q.Get("nonce")}
+	idp.mu.Unlock()
+
+	http.Redirect(w, r, redirectURL+"?"+url.Values{"code": {code}, "state": {q.Get("state")}}.Encode(), http.StatusFound)
+}
+
+func (idp *fakeIdP) token(w http.ResponseWriter, r *http.Request) {
+	fail := func(code string) {
+		w.Header().Set("Content-Type", "application/json")
+		w.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(w, `{"error":%q}`, code)
+	}
+
+	id, secret, _ := r.BasicAuth()
+	id, _ = url.QueryUnescape(id)
+	secret, _ = url.QueryUnescape(secret)
+	if id != clientID || secret != clientSecret {
+		fail("invalid_client")
+		return
+	}
+
+	idp.mu.Lock()
+	req, ok := idp.codes[r.PostFormValue("code")]
+	delete(idp.codes, r.PostFormValue("code"))
+	idp.mu.Unlock()
+	// 認可リクエストの code_challenge と一致する verifier だけを受け付ける
+	if !ok || r.PostFormValue("grant_type") != "authorization_code" || r.PostFormValue("redirect_uri") != redirectURL ||
+		Challenge(r.PostFormValue("code_verifier")) != req.challenge {
+		fail("invalid_grant")
+		return
+	}
+
+	json.NewEncoder(w).Encode(map[string]string{
+		"access_token": "opaque",
+		"token_type":   "Bearer",
+		"id_token":     idp.sign(idp.claims(req.nonce)),
+	})
+}
+
+func (idp *fakeIdP) jwks(w http.ResponseWriter, r *http.Request) {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.jwksFetches++
+	if idp.jwksDown {
+		http.Error(w, "unavailable", http.StatusServiceUnavailable)
+		return
+	}
+
+	encode := base64.RawURLEncoding.EncodeToString
+	keys := []map[string]string{}
+	for _, k := range idp.published {
+		switch public := k.Public().(type) {
+		case *rsa.PublicKey:
+			keys = append(keys, map[string]string{"kty": "RSA", "kid": k.ID, "use": "sig", "alg": jwt.RS256,
+				"n": encode(public.N.Bytes()), "e": encode(big.NewInt(int64(public.E)).Bytes())})
+		case ed25519.PublicKey:
+			keys = append(keys, map[string]string{"kty": "OKP", "kid": k.ID, "crv": "Ed25519", "x": encode(public)})
+		}
+	}
+	json.NewEncoder(w).Encode(map[string]interface{}{"keys": keys})
+}
+
+func (idp *fakeIdP) claims(nonce string) *jwt.Claims {
+	now := time.Now()
+	return &jwt.Claims{
+		Issuer:            idp.URL,
+		Subject:           "248289761001",
+		Audience:          jwt.Audience{clientID},
+		ExpiresAt:         now.Add(time.Minute).Unix(),
+		IssuedAt:          now.Unix(),
+		Nonce:             nonce,
+		PreferredUsername: "jane",
+	}
+}
+
+func (idp *fakeIdP) sign(c *jwt.Claims) string {
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+
+	ks, err := jwt.NewKeySet(idp.signing)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	token, err := ks.Sign(c)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	return token
+}
+
+// rotate makes the IdP sign with a new Ed25519 key, publishing both.
+func (idp *fakeIdP) rotate() {
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		idp.t.Fatal(err)
+	}
+	idp.mu.Lock()
+	defer idp.mu.Unlock()
+	idp.signing = jwt.NewEd25519Key("ed-2", private)
+	idp.published = append(idp.published, idp.signing)
+}
+
+func newClient(idp *fakeIdP) *Client {
+	return NewClient(Config{
+		Issuer:       idp.URL,
+		ClientID:     clientID,
+		ClientSecret: clientSecret,
+		RedirectURL:  redirectURL,
+		Scopes:       []string{"profile"},
+		HTTPClient:   idp.Client(),
+	})
+}
+
+// login follows AuthCodeURL as a browser would, returning the code and state
+// the IdP sends back to the redirect URL.
+func login(t *testing.T, c *Client, state, nonce, verifier string) (code, gotState string) {
+	t.Helper()
+
+	u, err := c.AuthCodeURL(context.Background(), state, nonce, verifier)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	browser := &http.Client{CheckRedirect: func(*http.Request, []*http.Request) error { return http.ErrUseLastResponse }}
+	resp, err := browser.Get(u)
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusFound {
+		t.Fatalf("authorization endpoint answered %s", resp.Status)
+	}
+
+	back, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return back.Query().Get("code"), back.Query().Get("state")
+}
+
+func TestAuthorizationCodeFlow(t *testing.T) {
+	idp := newFakeIdP(t)
+	c := newClient(idp)
+	ctx := context.Background()
+
+	code, state := login(t, c, "state-1", "nonce-1", "verifier-that-is-long-enough-for-pkce-0000001")
+	if state != "state-1" {
+		t.Errorf("state = %q, want %q", state, "state-1")
+	}
+
+	token, err := c.Exchange(ctx, code, "verifier-that-is-long-enough-for-pkce-0000001")
+	if err != nil {
+		t.Fatal(err)
+	}
+	claims, err := c.VerifyIDToken(ctx, token.IDToken, "nonce-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Subject != "248289761001" || claims.PreferredUsername != "jane" {
+		t.Errorf("claims = %+v", claims)
+	}
+
+	// 認可コードは一度しか使えない
+	var oerr *Error
+	if _, err := c.Exchange(ctx, code, "verifier-that-is-long-enough-for-pkce-0000001"); !errors.As(err, &oerr) || oerr.Code != "invalid_grant" {
+		t.Errorf("Exchange with a used code: err = %v, want invalid_grant", err)
+	}
+}
+
+func TestExchangeRequiresVerifier(t *testing.T) {
+	idp := newFakeIdP(t)
+	c := newClient(idp)
+
+	code, _ := login(t, c, "state", "nonce", "verifier-that-is-long-enough-for-pkce-0000001")
+
+	// 盗まれた認可コードは、verifier を知らなければ使えない
+	var oerr *Error
+	if _, err := c.Exchange(context.Background(), code, "verifier-of-someone-else-00000000000000000002"); !errors.As(err, &oerr) || oerr.Code != "invalid_grant" {
+		t.Errorf("Exchange with a wrong verifier: err = %v, want invalid_grant", err)
+	}
+}
+
+func TestVerifyIDToken(t *testing.T) {
+	idp := newFakeIdP(t)
+	c := newClient(idp)
+
+	_, private, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unpublished, err := jwt.NewKeySet(jwt.NewEd25519Key("rsa-1", private))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		edit func(c *jwt.Claims)
+		ok   bool
+	}{
+		{"valid", func(c *jwt.Claims) {}, true},
+		{"other nonce", func(c *jwt.Claims) { c.Nonce = "other" }, false},
+		{"other issuer", func(c *jwt.Claims) { c.Issuer = "https://evil.example" }, false},
+		{"other audience", func(c *jwt.Claims) { c.Audience = jwt.Audience{"other-app"} }, false},
+		{"audiences without azp", func(c *jwt.Claims) { c.Audience = jwt.Audience{clientID, "other-app"} }, false},
+		{"audiences with azp", func(c *jwt.Claims) {
+			c.Audience, c.AuthorizedParty = jwt.Audience{clientID, "other-app"}, clientID
+		}, true},
+		{"other azp", func(c *jwt.Claims) { c.AuthorizedParty = "other-app" }, false},
+		{"expired", func(c *jwt.Claims) { c.ExpiresAt = time.Now().Add(-time.Second).Unix() }, false},
+		{"no subject", func(c *jwt.Claims) { c.Subject = "" }, false},
+	}
+	for _, tt := range tests {
+		claims := idp.claims("nonce")
+		tt.edit(claims)
+		_, err := c.VerifyIDToken(context.Background(), idp.sign(claims), "nonce")
+		if tt.ok && err != nil {
+			t.Errorf("%s: VerifyIDToken: %v", tt.name, err)
+		}
+		if !tt.ok && !errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("%s: err = %v, want ErrInvalidIDToken", tt.name, err)
+		}
+	}
+
+	forged, err := unpublished.Sign(idp.claims("nonce"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := c.VerifyIDToken(context.Background(), forged, "nonce"); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("token signed with an unpublished key: err = %v, want ErrInvalidIDToken", err)
+	}
+}
+
+func TestKeyCache(t *testing.T) {
+	idp := newFakeIdP(t)
+	c := newClient(idp)
+	ctx := context.Background()
+
+	if _, err := c.Provider(ctx); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.keys.now = func() time.Time { return now }
+
+	verify := func(token string) error {
+		_, err := c.VerifyIDToken(ctx, token, "nonce")
+		return err
+	}
+	fetches := func() int {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		return idp.jwksFetches
+	}
+
+	for i := 0; i < 3; i++ {
+		if err := verify(idp.sign(idp.claims("nonce"))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if n := fetches(); n != 1 {
+		t.Errorf("keys fetched %d times for 3 tokens, want 1", n)
+	}
+
+	// 未知の kid が来ても、直前に取得したばかりなら取り直さない
+	idp.rotate()
+	rotated := idp.sign(idp.claims("nonce"))
+	if err := verify(rotated); !errors.Is(err, ErrInvalidIDToken) {
+		t.Errorf("token of a key published after the fetch: err = %v, want ErrInvalidIDToken", err)
+	}
+	if n := fetches(); n != 1 {
+		t.Errorf("keys fetched %d times within jwksMinRefresh, want 1", n)
+	}
+
+	now = now.Add(jwksMinRefresh)
+	if err := verify(rotated); err != nil {
+		t.Errorf("token of the rotated key: %v", err)
+	}
+	if n := fetches(); n != 2 {
+		t.Errorf("keys fetched %d times after rotation, want 2", n)
+	}
+}
+
+func TestKeyCacheFailure(t *testing.T) {
+	idp := newFakeIdP(t)
+	c := newClient(idp)
+	ctx := context.Background()
+
+	if _, err := c.Provider(ctx); err != nil {
+		t.Fatal(err)
+	}
+	now := time.Now()
+	c.keys.now = func() time.Time { return now }
+
+	setDown := func(down bool) {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		idp.jwksDown = down
+	}
+	fetches := func() int {
+		idp.mu.Lock()
+		defer idp.mu.Unlock()
+		return idp.jwksFetches
+	}
+
+	// 鍵を取得できない間は、リクエストのたびに取り直さない
+	setDown(true)
+	token := idp.sign(idp.claims("nonce"))
+	for i := 0; i < 3; i++ {
+		_, err := c.VerifyIDToken(ctx, token, "nonce")
+		if err == nil || errors.Is(err, ErrInvalidIDToken) {
+			t.Errorf("token while the keys cannot be fetched: err = %v, want a fetch error", err)
+		}
+	}
+	if n := fetches(); n != 1 {
+		t.Errorf("keys fetched %d times for 3 tokens within jwksRetry, want 1", n)
+	}
+
+	setDown(false)
+	now = now.Add(jwksRetry)
+	if _, err := c.VerifyIDToken(ctx, token, "nonce"); err != nil {
+		t.Errorf("token after the provider recovered: %v", err)
+	}
+	if n := fetches(); n != 2 {
+		t.Errorf("keys fetched %d times after jwksRetry, want 2", n)
+	}
+}
+
+func TestDiscovery(t *testing.T) {
+	idp := newFakeIdP(t)
+
+	c := NewClient(Config{Issuer: idp.URL + "/", ClientID: clientID, RedirectURL: redirectURL, HTTPClient: idp.Client()})
+	if _, err := c.Provider(context.Background()); err == nil {
+		t.Error("Provider accepted a document of another issuer")
+	}
+
+	c = NewClient(Config{Issuer: "http://127.0.0.1:1", ClientID: clientID, RedirectURL: redirectURL})
+	if _, err := c.AuthCodeURL(context.Background(), "state", "nonce", "verifier"); err == nil {
+		t.Error("AuthCodeURL succeeded without discovery")
+	}
+}
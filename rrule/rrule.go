@@ -0,0 +1,306 @@
+// Package rrule implements the subset of the RFC 5545 recurrence rules that
+// recurring TODOs support: the DAILY, WEEKLY and MONTHLY frequencies with
+// INTERVAL, BYDAY, COUNT and UNTIL. Weeks start on Monday.
+package rrule
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// A Frequency is how often a rule repeats.
+type Frequency string
+
+// The supported frequencies.
+const (
+	Daily   Frequency = "DAILY"
+	Weekly  Frequency = "WEEKLY"
+	Monthly Frequency = "MONTHLY"
+)
+
+// maxPeriods bounds the days, weeks or months Occurrences looks through, so
+// that a rule matching rarely or never cannot loop forever.
+const maxPeriods = 10000
+
+// untilFormat is the UTC form of UNTIL.
+const untilFormat = "20060102T150405Z"
+
+var weekdays = map[string]time.Weekday{
+	"MO": time.Monday,
+	"TU": time.Tuesday,
+	"WE": time.Wednesday,
+	"TH": time.Thursday,
+	"FR": time.Friday,
+	"SA": time.Saturday,
+	"SU": time.Sunday,
+}
+
+// A WeekdayNum is an element of BYDAY. N, if not 0, picks only the Nth
+// weekday of the month, counted from the end if negative, e.g. -1FR for the
+// last Friday.
+type WeekdayNum struct {
+	N   int
+	Day time.Weekday
+}
+
+// String returns the weekday as written in BYDAY, e.g. "2TU".
+func (w WeekdayNum) String() string {
+	s := strings.ToUpper(w.Day.String()[:2])
+	if w.N != 0 {
+		s = strconv.Itoa(w.N) + s
+	}
+	return s
+}
+
+// A Rule is a parsed recurrence rule. The zero Count and Until are unset.
+type Rule struct {
+	Freq     Frequency
+	Interval int
+	ByDay    []WeekdayNum
+	Count    int
+	Until    time.Time
+}
+
+// Parse parses a recurrence rule such as "FREQ=WEEKLY;BYDAY=MO,TH;COUNT=10",
+// optionally prefixed with "RRULE:". An UNTIL without "Z" is in loc.
+func Parse(s string, loc *time.Location) (*Rule, error) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "RRULE:")
+	if s == "" {
+		return nil, errors.New("rule is empty")
+	}
+
+	r := &Rule{Interval: 1}
+	seen := map[string]bool{}
+	for _, part := range strings.Split(s, ";") {
+		i := strings.IndexByte(part, '=')
+		if i < 0 {
+			return nil, fmt.Errorf("%q is not NAME=VALUE", part)
+		}
+		name, value := strings.ToUpper(part[:i]), part[i+1:]
+		if seen[name] {
+			return nil, fmt.Errorf("%s is given more than once", name)
+		}
+		seen[name] = true
+
+		var err error
+		switch name {
+		case "FREQ":
+			switch f := Frequency(strings.ToUpper(value)); f {
+			case Daily, Weekly, Monthly:
+				r.Freq = f
+			default:
+				return nil, fmt.Errorf("FREQ=%s is not supported; use DAILY, WEEKLY or MONTHLY", value)
+			}
+		case "INTERVAL":
+			r.Interval, err = positive(name, value)
+		case "COUNT":
+			r.Count, err = positive(name, value)
+		case "UNTIL":
+			r.Until, err = parseUntil(value, loc)
+		case "BYDAY":
+			r.ByDay, err = parseByDay(value)
+		case "WKST":
+			if strings.ToUpper(value) != "MO" {
+				err = errors.New("WKST other than MO is not supported")
+			}
+		default:
+			err = fmt.Errorf("%s is not supported", name)
+		}
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if r.Freq == "" {
+		return nil, errors.New("FREQ is required")
+	}
+	if r.Count > 0 && !r.Until.IsZero() {
+		return nil, errors.New("COUNT and UNTIL must not be given together")
+	}
+	if r.Freq != Monthly {
+		for _, w := range r.ByDay {
+			if w.N != 0 {
+				return nil, fmt.Errorf("BYDAY=%s is only allowed with FREQ=MONTHLY", w)
+			}
+		}
+	}
+	return r, nil
+}
+
+// String returns the rule in its canonical form, leaving out default values.
+func (r *Rule) String() string {
+	parts := []string{"FREQ=" + string(r.Freq)}
+	if r.Interval > 1 {
+		parts = append(parts, "INTERVAL="+strconv.Itoa(r.Interval))
+	}
+	if len(r.ByDay) > 0 {
+		days := make([]string, len(r.ByDay))
+		for i, w := range r.ByDay {
+			days[i] = w.String()
+		}
+		parts = append(parts, "BYDAY="+strings.Join(days, ","))
+	}
+	if r.Count > 0 {
+		parts = append(parts, "COUNT="+strconv.Itoa(r.Count))
+	}
+	if !r.Until.IsZero() {
+		parts = append(parts, "UNTIL="+r.Until.UTC().Format(untilFormat))
+	}
+	return strings.Join(parts, ";")
+}
+
+// Occurrences returns up to n first occurrences of the rule starting at
+// dtstart, which is always the first one as in RFC 5545. The occurrences are
+// computed in the location of dtstart and keep its time of day.
+func (r *Rule) Occurrences(dtstart time.Time, n int) []time.Time {
+	if n <= 0 {
+		return nil
+	}
+
+	limit := n
+	if r.Count > 0 && r.Count < limit {
+		limit = r.Count
+	}
+	if r.after(dtstart) {
+		return nil
+	}
+
+	occurrences := []time.Time{dtstart}
+	for period := 0; period < maxPeriods && len(occurrences) < limit; period++ {
+		for _, t := range r.candidates(dtstart, period*r.Interval) {
+			if !t.After(dtstart) {
+				continue
+			}
+			if r.after(t) {
+				return occurrences
+			}
+			occurrences = append(occurrences, t)
+			if len(occurrences) == limit {
+				break
+			}
+		}
+	}
+	return occurrences
+}
+
+// after reports whether t is after UNTIL.
+func (r *Rule) after(t time.Time) bool {
+	return !r.Until.IsZero() && t.After(r.Until)
+}
+
+// candidates returns the times in the day, week or month offset periods after
+// the one of dtstart that match the rule, in chronological order.
+func (r *Rule) candidates(dtstart time.Time, offset int) []time.Time {
+	y, m, d := dtstart.Date()
+	at := func(y int, m time.Month, d int) time.Time {
+		return time.Date(y, m, d, dtstart.Hour(), dtstart.Minute(), dtstart.Second(), dtstart.Nanosecond(), dtstart.Location())
+	}
+
+	var times []time.Time
+	switch r.Freq {
+	case Daily:
+		t := at(y, m, d+offset)
+		if r.matchWeekday(t.Weekday()) {
+			times = append(times, t)
+		}
+	case Weekly:
+		// 月曜始まりの週の各曜日
+		monday := d - (int(dtstart.Weekday())+6)%7 + 7*offset
+		for i := 0; i < 7; i++ {
+			t := at(y, m, monday+i)
+			if len(r.ByDay) == 0 && t.Weekday() == dtstart.Weekday() || len(r.ByDay) > 0 && r.matchWeekday(t.Weekday()) {
+				times = append(times, t)
+			}
+		}
+	case Monthly:
+		first := time.Date(y, m+time.Month(offset), 1, 0, 0, 0, 0, dtstart.Location())
+		days := daysIn(first)
+		if len(r.ByDay) == 0 {
+			// 存在しない日 (31 日など) の月は飛ばす
+			if d <= days {
+				times = append(times, at(first.Year(), first.Month(), d))
+			}
+			break
+		}
+		for day := 1; day <= days; day++ {
+			t := at(first.Year(), first.Month(), day)
+			nth, fromEnd := (day-1)/7+1, -((days-day)/7 + 1)
+			for _, w := range r.ByDay {
+				if w.Day == t.Weekday() && (w.N == 0 || w.N == nth || w.N == fromEnd) {
+					times = append(times, t)
+					break
+				}
+			}
+		}
+	}
+	sort.Slice(times, func(i, j int) bool { return times[i].Before(times[j]) })
+	return times
+}
+
+// matchWeekday reports whether BYDAY, if given, includes the weekday.
+func (r *Rule) matchWeekday(day time.Weekday) bool {
+	if len(r.ByDay) == 0 {
+		return true
+	}
+	for _, w := range r.ByDay {
+		if w.Day == day {
+			return true
+		}
+	}
+	return false
+}
+
+// daysIn returns the number of days in the month of t.
+func daysIn(t time.Time) int {
+	return time.Date(t.Year(), t.Month()+1, 0, 0, 0, 0, 0, time.UTC).Day()
+}
+
+func positive(name, value string) (int, error) {
+	n, err := strconv.Atoi(value)
+	if err != nil || n <= 0 {
+		return 0, fmt.Errorf("%s must be a positive integer", name)
+	}
+	return n, nil
+}
+
+func parseUntil(value string, loc *time.Location) (time.Time, error) {
+	if t, err := time.Parse(untilFormat, value); err == nil {
+		return t, nil
+	}
+	if t, err := time.ParseInLocation("20060102T150405", value, loc); err == nil {
+		return t, nil
+	}
+	// 日付だけの UNTIL はその日の終わりまでを含む
+	if t, err := time.ParseInLocation("20060102", value, loc); err == nil {
+		return t.AddDate(0, 0, 1).Add(-time.Second), nil
+	}
+	return time.Time{}, errors.New("UNTIL must be a date such as 20261231 or a date-time such as 20261231T235959Z")
+}
+
+func parseByDay(value string) ([]WeekdayNum, error) {
+	var days []WeekdayNum
+	for _, s := range strings.Split(strings.ToUpper(value), ",") {
+		if len(s) < 2 {
+			return nil, fmt.Errorf("BYDAY=%s is not a weekday", s)
+		}
+		day, ok := weekdays[s[len(s)-2:]]
+		if !ok {
+			return nil, fmt.Errorf("BYDAY=%s is not a weekday", s)
+		}
+
+		w := WeekdayNum{Day: day}
+		if prefix := s[:len(s)-2]; prefix != "" {
+			n, err := strconv.Atoi(prefix)
+			if err != nil || n == 0 || n < -5 || n > 5 {
+				return nil, fmt.Errorf("BYDAY=%s must be numbered from -5 to 5", s)
+			}
+			w.N = n
+		}
+		days = append(days, w)
+	}
+	return days, nil
+}
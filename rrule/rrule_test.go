@@ -0,0 +1,109 @@
+package rrule
+
+import (
+	"testing"
+	"time"
+)
+
+func TestOccurrences(t *testing.T) {
+	// 2026-10-05 は月曜日
+	dtstart := time.Date(2026, 10, 5, 9, 30, 0, 0, time.UTC)
+
+	tests := []struct {
+		rule string
+		n    int
+		want []string
+	}{
+		{"FREQ=DAILY", 3, []string{"2026-10-05", "2026-10-06", "2026-10-07"}},
+		{"FREQ=DAILY;INTERVAL=3;COUNT=3", 5, []string{"2026-10-05", "2026-10-08", "2026-10-11"}},
+		{"FREQ=DAILY;BYDAY=SA,SU", 3, []string{"2026-10-05", "2026-10-10", "2026-10-11"}},
+		{"FREQ=WEEKLY", 3, []string{"2026-10-05", "2026-10-12", "2026-10-19"}},
+		{"FREQ=WEEKLY;BYDAY=MO,TH", 4, []string{"2026-10-05", "2026-10-08", "2026-10-12", "2026-10-15"}},
+		{"FREQ=WEEKLY;INTERVAL=2;BYDAY=FR,TU", 4, []string{"2026-10-05", "2026-10-06", "2026-10-09", "2026-10-20"}},
+		{"FREQ=WEEKLY;UNTIL=20261019", 5, []string{"2026-10-05", "2026-10-12", "2026-10-19"}},
+		{"FREQ=WEEKLY;UNTIL=20261019T000000Z", 5, []string{"2026-10-05", "2026-10-12"}},
+		{"FREQ=MONTHLY", 3, []string{"2026-10-05", "2026-11-05", "2026-12-05"}},
+		{"FREQ=MONTHLY;BYDAY=1MO", 3, []string{"2026-10-05", "2026-11-02", "2026-12-07"}},
+		{"FREQ=MONTHLY;BYDAY=-1FR", 3, []string{"2026-10-05", "2026-10-30", "2026-11-27"}},
+		{"RRULE:FREQ=MONTHLY;INTERVAL=2;BYDAY=2TU;COUNT=2", 5, []string{"2026-10-05", "2026-10-13"}},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule, time.UTC)
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+
+		var got []string
+		for _, o := range r.Occurrences(dtstart, tt.n) {
+			if o.Hour() != 9 || o.Minute() != 30 {
+				t.Errorf("%s: occurrence %v does not keep the time of day", tt.rule, o)
+			}
+			got = append(got, o.Format("2006-01-02"))
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.rule, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestOccurrencesSkipsShortMonths(t *testing.T) {
+	r, err := Parse("FREQ=MONTHLY", time.UTC)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, o := range r.Occurrences(time.Date(2027, 1, 31, 0, 0, 0, 0, time.UTC), 3) {
+		got = append(got, o.Format("2006-01-02"))
+	}
+	want := []string{"2027-01-31", "2027-03-31", "2027-05-31"}
+	if len(got) != len(want) || got[1] != want[1] || got[2] != want[2] {
+		t.Errorf("got %v, want %v", got, want)
+	}
+}
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		rule string
+		want string // 空ならエラー
+	}{
+		{"freq=weekly;byday=mo,we;interval=1", "FREQ=WEEKLY;BYDAY=MO,WE"},
+		{"FREQ=MONTHLY;BYDAY=-1SU;COUNT=12", "FREQ=MONTHLY;BYDAY=-1SU;COUNT=12"},
+		{"FREQ=DAILY;UNTIL=20261231T150000Z;WKST=MO", "FREQ=DAILY;UNTIL=20261231T150000Z"},
+		{"", ""},
+		{"BYDAY=MO", ""},
+		{"FREQ=YEARLY", ""},
+		{"FREQ=DAILY;COUNT=0", ""},
+		{"FREQ=DAILY;COUNT=2;UNTIL=20261231", ""},
+		{"FREQ=WEEKLY;BYDAY=1MO", ""},
+		{"FREQ=MONTHLY;BYDAY=6MO", ""},
+		{"FREQ=MONTHLY;BYDAY=XX", ""},
+		{"FREQ=DAILY;BYMONTHDAY=1", ""},
+		{"FREQ=DAILY;FREQ=WEEKLY", ""},
+		{"FREQ", ""},
+	}
+	for _, tt := range tests {
+		r, err := Parse(tt.rule, time.UTC)
+		if tt.want == "" {
+			if err == nil {
+				t.Errorf("Parse(%q) = %v, want an error", tt.rule, r)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("Parse(%q): %v", tt.rule, err)
+			continue
+		}
+		if got := r.String(); got != tt.want {
+			t.Errorf("Parse(%q).String() = %q, want %q", tt.rule, got, tt.want)
+		}
+	}
+}
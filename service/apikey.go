@@ -0,0 +1,335 @@
+package service
+
+import (
+	"context"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+const (
+	// apiKeyPrefix starts every API key, so that a leaked key is easy to recognize.
+	apiKeyPrefix = "todo_"
+	// apiKeyDisplayLength is the number of characters of a key kept to tell keys apart.
+	apiKeyDisplayLength = 12
+	// apiKeyUseInterval is how often last_used_at is updated at most, so that
+	// a busy key does not write to DB on every request.
+	apiKeyUseInterval = time.Minute
+	// maxAPIKeyNameLength is the maximum number of characters in an API key name.
+	maxAPIKeyNameLength = 100
+)
+
+// apiKeyColumns is the column list that scanAPIKey expects.
+const apiKeyColumns = `id, name, prefix, scopes, last_used_at, revoked_at, created_at, updated_at`
+
+// scopes are the scopes an API key can be granted, in their canonical order.
+var scopes = []model.Scope{
+	model.ScopeTODOsRead,
+	model.ScopeTODOsWrite,
+	model.ScopeAdmin,
+}
+
+// An APIKeyService implements the API keys of the users.
+// Every method but AuthenticateAPIKey manages the keys of the user of its
+// context, returning model.ErrUnauthorized if there is none.
+type APIKeyService struct {
+	db *sql.DB
+}
+
+// NewAPIKeyService returns new APIKeyService.
+func NewAPIKeyService(db *sql.DB) *APIKeyService {
+	return &APIKeyService{
+		db: db,
+	}
+}
+
+// CreateAPIKey creates an APIKey with the scopes on DB and returns it with
+// the key, which is not stored and cannot be read again.
+func (s *APIKeyService) CreateAPIKey(ctx context.Context, name string, granted []model.Scope) (_ *model.APIKey, key string, err error) {
+	defer logFailure(ctx, "CreateAPIKey", &err)
+
+	const insert = `INSERT INTO api_keys(user_id, name, prefix, key_hash, scopes) VALUES(?, ?, ?, ?, ?)`
+
+	u, err := currentUser(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	name, err = normalizeName("name", name, maxAPIKeyNameLength)
+	if err != nil {
+		return nil, "", err
+	}
+	granted, err = normalizeScopes("scopes", granted)
+	if err != nil {
+		return nil, "", err
+	}
+
+	key, err = newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	res, err := s.db.ExecContext(ctx, insert, u.ID, name, key[:apiKeyDisplayLength], hashToken(key), joinScopes(granted))
+	if err != nil {
+		return nil, "", err
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, "", err
+	}
+
+	apiKey, err := getAPIKey(ctx, s.db, u.ID, id)
+	if err != nil {
+		return nil, "", err
+	}
+	return apiKey, key, nil
+}
+
+// ReadAPIKeys reads the APIKeys on DB from the newest, including the revoked ones.
+func (s *APIKeyService) ReadAPIKeys(ctx context.Context) (_ []*model.APIKey, err error) {
+	defer logFailure(ctx, "ReadAPIKeys", &err)
+
+	const read = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE user_id = ? ORDER BY id DESC`
+
+	u, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := s.db.QueryContext(ctx, read, u.ID)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	keys := []*model.APIKey{}
+	for rows.Next() {
+		key, err := scanAPIKey(rows)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return keys, nil
+}
+
+// RotateAPIKey replaces the key of the APIKey on DB and returns it with the
+// new key. The old key stops working at once. It returns model.ErrConflict
+// if the APIKey is revoked.
+func (s *APIKeyService) RotateAPIKey(ctx context.Context, id int64) (_ *model.APIKey, key string, err error) {
+	defer logFailure(ctx, "RotateAPIKey", &err)
+
+	const update = `UPDATE api_keys SET prefix = ?, key_hash = ? WHERE id = ?`
+
+	u, err := currentUser(ctx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	apiKey, err := getAPIKey(ctx, tx, u.ID, id)
+	if err != nil {
+		return nil, "", err
+	}
+	if apiKey.Revoked {
+		return nil, "", &model.ErrConflict{Reason: "The API key is revoked; create a new one instead."}
+	}
+
+	key, err = newAPIKey()
+	if err != nil {
+		return nil, "", err
+	}
+
+	if _, err := tx.ExecContext(ctx, update, key[:apiKeyDisplayLength], hashToken(key), id); err != nil {
+		return nil, "", err
+	}
+
+	apiKey, err = getAPIKey(ctx, tx, u.ID, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	return apiKey, key, nil
+}
+
+// RevokeAPIKey revokes the APIKey on DB, so that its key no longer
+// authenticates, and returns it. Revoking a revoked APIKey changes nothing.
+func (s *APIKeyService) RevokeAPIKey(ctx context.Context, id int64) (_ *model.APIKey, err error) {
+	defer logFailure(ctx, "RevokeAPIKey", &err)
+
+	const update = `UPDATE api_keys SET revoked_at = ? WHERE id = ? AND user_id = ? AND revoked_at IS NULL`
+
+	u, err := currentUser(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	if _, err := tx.ExecContext(ctx, update, time.Now().UTC(), id, u.ID); err != nil {
+		return nil, err
+	}
+
+	apiKey, err := getAPIKey(ctx, tx, u.ID, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return apiKey, nil
+}
+
+// AuthenticateAPIKey returns the user of the key and the scopes it grants,
+// recording that the key has been used. It returns model.ErrUnauthorized if
+// the key is unknown or revoked.
+func (s *APIKeyService) AuthenticateAPIKey(ctx context.Context, key string) (_ *model.User, _ []model.Scope, err error) {
+	defer logFailure(ctx, "AuthenticateAPIKey", &err)
+
+	const (
+		// 平文の鍵は保存していないので、ハッシュで引く
+		read = `SELECT api_keys.id, api_keys.scopes, users.id, users.name, users.created_at, users.updated_at
+			FROM api_keys JOIN users ON users.id = api_keys.user_id
+			WHERE api_keys.key_hash = ? AND api_keys.revoked_at IS NULL`
+		touch = `UPDATE api_keys SET last_used_at = ? WHERE id = ? AND (last_used_at IS NULL OR last_used_at < ?)`
+	)
+
+	var (
+		id      int64
+		granted string
+		u       model.User
+	)
+	err = s.db.QueryRowContext(ctx, read, hashToken(key)).Scan(&id, &granted, &u.ID, &u.Name, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, nil, &model.ErrUnauthorized{}
+		}
+		return nil, nil, err
+	}
+
+	now := time.Now().UTC()
+	if _, err := s.db.ExecContext(ctx, touch, now, id, now.Add(-apiKeyUseInterval)); err != nil {
+		return nil, nil, err
+	}
+
+	return &u, splitScopes(granted), nil
+}
+
+// getAPIKey reads the APIKey id of the user userID, returning
+// model.ErrNotFound if there is none.
+func getAPIKey(ctx context.Context, q queryer, userID, id int64) (*model.APIKey, error) {
+	const read = `SELECT ` + apiKeyColumns + ` FROM api_keys WHERE id = ? AND user_id = ?`
+
+	key, err := scanAPIKey(q.QueryRowContext(ctx, read, id, userID))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+	return key, nil
+}
+
+// scanAPIKey scans a row selected with apiKeyColumns into an APIKey.
+func scanAPIKey(row rowScanner) (*model.APIKey, error) {
+	var (
+		key     model.APIKey
+		granted string
+	)
+	if err := row.Scan(&key.ID, &key.Name, &key.Prefix, &granted, &key.LastUsedAt, &key.RevokedAt, &key.CreatedAt, &key.UpdatedAt); err != nil {
+		return nil, err
+	}
+	key.Scopes = splitScopes(granted)
+	key.Revoked = key.RevokedAt != nil
+	return &key, nil
+}
+
+// newAPIKey returns a new random API key.
+func newAPIKey() (string, error) {
+	s, err := randomHex(24)
+	if err != nil {
+		return "", err
+	}
+	return apiKeyPrefix + s, nil
+}
+
+// hashToken returns the value stored for a random token such as an API key
+// or a refresh token. The tokens are random enough that a fast hash does not
+// make them guessable.
+func hashToken(token string) string {
+	sum := sha256.Sum256([]byte(token))
+	return hex.EncodeToString(sum[:])
+}
+
+// normalizeScopes returns the scopes without duplicates in their canonical
+// order, or a model.ErrValidation of field if any of them is unknown or there
+// are none.
+func normalizeScopes(field string, granted []model.Scope) ([]model.Scope, error) {
+	seen := map[model.Scope]bool{}
+	for _, scope := range granted {
+		seen[scope] = true
+	}
+
+	normalized := []model.Scope{}
+	for _, scope := range scopes {
+		if seen[scope] {
+			normalized = append(normalized, scope)
+			delete(seen, scope)
+		}
+	}
+
+	verr := &model.ErrValidation{}
+	switch {
+	case len(seen) > 0:
+		verr.Add(field, fmt.Sprintf("must be some of %s, %s and %s", model.ScopeTODOsRead, model.ScopeTODOsWrite, model.ScopeAdmin))
+	case len(normalized) == 0:
+		verr.Add(field, "must not be empty")
+	default:
+		return normalized, nil
+	}
+	return nil, verr
+}
+
+// joinScopes returns the value of the scopes column for the scopes.
+func joinScopes(granted []model.Scope) string {
+	s := make([]string, len(granted))
+	for i, scope := range granted {
+		s[i] = string(scope)
+	}
+	return strings.Join(s, " ")
+}
+
+// splitScopes splits the value of the scopes column.
+func splitScopes(s string) []model.Scope {
+	granted := []model.Scope{}
+	for _, scope := range strings.Fields(s) {
+		granted = append(granted, model.Scope(scope))
+	}
+	return granted
+}
@@ -0,0 +1,357 @@
+package service
+
+import (
+	"container/heap"
+	"context"
+	"database/sql"
+	"errors"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// unblocked matches the TODOs whose blockers are all completed or trashed.
+const unblocked = `NOT EXISTS (SELECT 1 FROM todo_dependencies JOIN todos AS blocker ON blocker.id = todo_dependencies.blocker_id
+	WHERE todo_dependencies.blocked_id = todos.id AND blocker.completed = FALSE AND blocker.deleted_at IS NULL)`
+
+// GetTODODependencies reads the TODOs the TODO on DB is blocked by and the
+// TODOs it blocks, each in the manual order.
+func (s *TODOService) GetTODODependencies(ctx context.Context, id int64) (blockedBy, blocks []*model.TODO, err error) {
+	defer logFailure(ctx, "GetTODODependencies", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, nil, err
+	}
+
+	return getDependencies(ctx, s.db, id)
+}
+
+// AddTODODependency makes the TODO on DB blocked by the TODO blockerID and
+// returns its dependencies. It returns a model.ErrValidation of blocked_by if
+// the blocker does not exist or the dependency would create a cycle.
+// Adding an existing dependency changes nothing.
+func (s *TODOService) AddTODODependency(ctx context.Context, id, blockerID int64) (blockedBy, blocks []*model.TODO, err error) {
+	defer logFailure(ctx, "AddTODODependency", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, nil, err
+	}
+
+	const (
+		insert = `INSERT OR IGNORE INTO todo_dependencies(blocker_id, blocked_id) VALUES(?, ?)`
+		touch  = `UPDATE todos SET updated_at = DATETIME('now') WHERE id = ?`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := snapshotTODO(ctx, tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := checkBlocker(ctx, tx, id, blockerID); err != nil {
+		return nil, nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, insert, blockerID, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	// 依存関係が増えた場合だけ更新日時を進める
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, nil, err
+	}
+	if rowsAffected > 0 {
+		if _, err := tx.ExecContext(ctx, touch, id); err != nil {
+			return nil, nil, err
+		}
+		if err := recordEvent(ctx, tx, ActionUpdate, id, old); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	blockedBy, blocks, err = getDependencies(ctx, tx, id)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, nil, err
+	}
+
+	return blockedBy, blocks, nil
+}
+
+// RemoveTODODependency makes the TODO on DB no longer blocked by the TODO
+// blockerID, returning model.ErrNotFound if it is not.
+func (s *TODOService) RemoveTODODependency(ctx context.Context, id, blockerID int64) (err error) {
+	defer logFailure(ctx, "RemoveTODODependency", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return err
+	}
+
+	const (
+		deleteEdge = `DELETE FROM todo_dependencies WHERE blocker_id = ? AND blocked_id = ?`
+		touch      = `UPDATE todos SET updated_at = DATETIME('now') WHERE id = ?`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	old, err := snapshotTODO(ctx, tx, id)
+	if err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, deleteEdge, blockerID, id)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return &model.ErrNotFound{}
+	}
+
+	if _, err := tx.ExecContext(ctx, touch, id); err != nil {
+		return err
+	}
+
+	if err := recordEvent(ctx, tx, ActionUpdate, id, old); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// SortTODO reads all the TODOs on DB but the trashed ones in topological order, so that every TODO
+// comes after all its blockers, with the dependencies between them. TODOs that
+// are not ordered by the dependencies keep the manual order.
+func (s *TODOService) SortTODO(ctx context.Context) (_ []*model.TODO, _ []model.Dependency, err error) {
+	defer logFailure(ctx, "SortTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, nil, err
+	}
+
+	const (
+		readTODOs = `SELECT ` + todoColumns + ` FROM todos WHERE deleted_at IS NULL AND ` + owned + ` ORDER BY position`
+		readDeps  = `SELECT blocker_id, blocked_id FROM todo_dependencies
+			WHERE blocker_id IN (SELECT id FROM todos WHERE deleted_at IS NULL AND ` + owned + `)
+			AND blocked_id IN (SELECT id FROM todos WHERE deleted_at IS NULL AND ` + owned + `)
+			ORDER BY blocked_id, blocker_id`
+	)
+
+	// 一覧と依存関係を同じスナップショットから読む
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer tx.Rollback()
+
+	owner := ownerID(ctx)
+	rows, err := tx.QueryContext(ctx, readTODOs, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	todos := []*model.TODO{}
+	for rows.Next() {
+		todo, err := scanTODO(rows)
+		if err != nil {
+			return nil, nil, err
+		}
+		todos = append(todos, todo)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	rows, err = tx.QueryContext(ctx, readDeps, owner, owner)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer rows.Close()
+
+	deps := []model.Dependency{}
+	for rows.Next() {
+		var dep model.Dependency
+		if err := rows.Scan(&dep.BlockerID, &dep.BlockedID); err != nil {
+			return nil, nil, err
+		}
+		deps = append(deps, dep)
+	}
+	if err := rows.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	sorted, err := sortTopologically(todos, deps)
+	if err != nil {
+		return nil, nil, err
+	}
+	return sorted, deps, nil
+}
+
+// sortTopologically orders todos so that every TODO comes after its blockers
+// in deps. Among the TODOs whose blockers have all been placed, the one that
+// comes first in todos is placed first.
+func sortTopologically(todos []*model.TODO, deps []model.Dependency) ([]*model.TODO, error) {
+	index := make(map[int64]int, len(todos))
+	for i, todo := range todos {
+		index[todo.ID] = i
+	}
+
+	var (
+		blocks   = make([][]int, len(todos))
+		blockers = make([]int, len(todos))
+	)
+	for _, dep := range deps {
+		from, ok1 := index[dep.BlockerID]
+		to, ok2 := index[dep.BlockedID]
+		if !ok1 || !ok2 {
+			continue
+		}
+		blocks[from] = append(blocks[from], to)
+		blockers[to]++
+	}
+
+	// Kahn のアルゴリズムで、ブロックされていない TODO から順に並べる
+	ready := &indexHeap{}
+	for i := range todos {
+		if blockers[i] == 0 {
+			heap.Push(ready, i)
+		}
+	}
+
+	sorted := make([]*model.TODO, 0, len(todos))
+	for ready.Len() > 0 {
+		i := heap.Pop(ready).(int)
+		sorted = append(sorted, todos[i])
+		for _, j := range blocks[i] {
+			if blockers[j]--; blockers[j] == 0 {
+				heap.Push(ready, j)
+			}
+		}
+	}
+
+	if len(sorted) < len(todos) {
+		return nil, errors.New("dependencies between TODOs contain a cycle")
+	}
+	return sorted, nil
+}
+
+// checkBlocker returns a model.ErrValidation of blocked_by unless the TODO
+// blockerID exists and is neither the TODO id nor blocked by it, directly or
+// through other TODOs, so that the dependency does not create a cycle.
+func checkBlocker(ctx context.Context, q queryer, id, blockerID int64) error {
+	const ancestors = `WITH RECURSIVE blockers(blocker_id) AS (
+		SELECT id FROM todos WHERE id = ? AND deleted_at IS NULL AND ` + owned + `
+		UNION
+		SELECT todo_dependencies.blocker_id FROM todo_dependencies JOIN blockers ON todo_dependencies.blocked_id = blockers.blocker_id
+	) SELECT COUNT(*), TOTAL(blocker_id = ?) FROM blockers`
+
+	var (
+		n     int
+		cycle float64
+	)
+	if err := q.QueryRowContext(ctx, ancestors, blockerID, ownerID(ctx), id).Scan(&n, &cycle); err != nil {
+		return err
+	}
+
+	verr := &model.ErrValidation{}
+	switch {
+	case n == 0:
+		verr.Add("blocked_by", "must be the id of an existing TODO")
+	case cycle > 0:
+		verr.Add("blocked_by", "must not be the TODO itself or a TODO blocked by it")
+	default:
+		return nil
+	}
+	return verr
+}
+
+// getDependencies reads the TODOs the TODO id is blocked by and the TODOs it
+// blocks, returning model.ErrNotFound if there is no such TODO.
+func getDependencies(ctx context.Context, q queryer, id int64) (blockedBy, blocks []*model.TODO, err error) {
+	const (
+		readBlockedBy = `SELECT ` + todoColumns + ` FROM todos
+			WHERE id IN (SELECT blocker_id FROM todo_dependencies WHERE blocked_id = ?) AND deleted_at IS NULL ORDER BY position`
+		readBlocks = `SELECT ` + todoColumns + ` FROM todos
+			WHERE id IN (SELECT blocked_id FROM todo_dependencies WHERE blocker_id = ?) AND deleted_at IS NULL ORDER BY position`
+	)
+
+	if _, err := getTODO(ctx, q, id); err != nil {
+		return nil, nil, err
+	}
+
+	if blockedBy, err = queryTODOs(ctx, q, readBlockedBy, id); err != nil {
+		return nil, nil, err
+	}
+	if blocks, err = queryTODOs(ctx, q, readBlocks, id); err != nil {
+		return nil, nil, err
+	}
+	return blockedBy, blocks, nil
+}
+
+// queryTODOs returns the TODOs selected with todoColumns by the query.
+func queryTODOs(ctx context.Context, q queryer, query string, args ...interface{}) ([]*model.TODO, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	todos := []*model.TODO{}
+	for rows.Next() {
+		todo, err := scanTODO(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+// splitIDs splits the ids aggregated with commas in ascending order.
+// It returns nil if there are no ids.
+func splitIDs(s sql.NullString) []int64 {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+
+	var ids []int64
+	for _, f := range strings.Split(s.String, ",") {
+		id, err := strconv.ParseInt(f, 10, 64)
+		if err != nil {
+			continue
+		}
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return ids[i] < ids[j] })
+	return ids
+}
+
+// An indexHeap is a min-heap of indexes implementing heap.Interface.
+type indexHeap []int
+
+func (h indexHeap) Len() int            { return len(h) }
+func (h indexHeap) Less(i, j int) bool  { return h[i] < h[j] }
+func (h indexHeap) Swap(i, j int)       { h[i], h[j] = h[j], h[i] }
+func (h *indexHeap) Push(x interface{}) { *h = append(*h, x.(int)) }
+func (h *indexHeap) Pop() interface{} {
+	old := *h
+	x := old[len(old)-1]
+	*h = old[:len(old)-1]
+	return x
+}
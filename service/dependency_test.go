@@ -0,0 +1,60 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+func TestSortTopologically(t *testing.T) {
+	// 手動の並び順は 1, 2, 3, 4, 5
+	var todos []*model.TODO
+	for id := int64(1); id <= 5; id++ {
+		todos = append(todos, &model.TODO{ID: id})
+	}
+
+	tests := []struct {
+		name string
+		deps []model.Dependency
+		want []int64
+	}{
+		{"no dependencies", nil, []int64{1, 2, 3, 4, 5}},
+		{"blocker later", []model.Dependency{{BlockerID: 4, BlockedID: 2}}, []int64{1, 3, 4, 2, 5}},
+		{"chain", []model.Dependency{{BlockerID: 5, BlockedID: 3}, {BlockerID: 3, BlockedID: 1}}, []int64{2, 4, 5, 3, 1}},
+		{"diamond", []model.Dependency{
+			{BlockerID: 5, BlockedID: 2}, {BlockerID: 5, BlockedID: 3},
+			{BlockerID: 2, BlockedID: 1}, {BlockerID: 3, BlockedID: 1},
+		}, []int64{4, 5, 2, 3, 1}},
+		{"unknown TODO", []model.Dependency{{BlockerID: 9, BlockedID: 1}}, []int64{1, 2, 3, 4, 5}},
+	}
+	for _, tt := range tests {
+		sorted, err := sortTopologically(todos, tt.deps)
+		if err != nil {
+			t.Errorf("%s: error: %v", tt.name, err)
+			continue
+		}
+
+		var got []int64
+		for _, todo := range sorted {
+			got = append(got, todo.ID)
+		}
+		if len(got) != len(tt.want) {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+			continue
+		}
+		for i := range got {
+			if got[i] != tt.want[i] {
+				t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+				break
+			}
+		}
+	}
+}
+
+func TestSortTopologicallyCycle(t *testing.T) {
+	todos := []*model.TODO{{ID: 1}, {ID: 2}}
+	deps := []model.Dependency{{BlockerID: 1, BlockedID: 2}, {BlockerID: 2, BlockedID: 1}}
+	if _, err := sortTopologically(todos, deps); err == nil {
+		t.Error("sortTopologically with a cycle returned no error")
+	}
+}
@@ -0,0 +1,78 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// TestETag checks that the entity tag of a TODO only changes with the TODO
+// itself, and that UpdateTODO honors If-Match like PatchTODO.
+func TestETag(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "etag_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	var (
+		svc = NewTODOService(todoDB)
+		ctx = context.Background()
+	)
+
+	parent, err := svc.InsertTODO(ctx, &TODOInput{Subject: "parent", Tags: []string{"work"}})
+	if err != nil {
+		t.Fatal("failed to create TODO, err =", err)
+	}
+	etag := parent.ETag()
+
+	// 子タスクや依存先、タグ名の変更では親の ETag は変わらない
+	child, err := svc.InsertTODO(ctx, &TODOInput{Subject: "child", ParentID: parent.ID})
+	if err != nil {
+		t.Fatal("failed to create subtask, err =", err)
+	}
+	if _, err := svc.CompleteTODO(ctx, child.ID); err != nil {
+		t.Fatal("failed to complete subtask, err =", err)
+	}
+	blocker, err := svc.InsertTODO(ctx, &TODOInput{Subject: "blocker"})
+	if err != nil {
+		t.Fatal("failed to create blocker, err =", err)
+	}
+	if _, _, err := svc.AddTODODependency(ctx, parent.ID, blocker.ID); err != nil {
+		t.Fatal("failed to add dependency, err =", err)
+	}
+	if err := svc.DeleteTODO(ctx, []int64{blocker.ID}); err != nil {
+		t.Fatal("failed to trash blocker, err =", err)
+	}
+	tags, err := NewTagService(todoDB).ReadTags(ctx)
+	if err != nil || len(tags) != 1 {
+		t.Fatalf("unexpected tags, tags = %v, err = %v", tags, err)
+	}
+	if _, err := NewTagService(todoDB).RenameTag(ctx, tags[0].ID, "office"); err != nil {
+		t.Fatal("failed to rename tag, err =", err)
+	}
+
+	got, err := svc.GetTODO(ctx, parent.ID)
+	if err != nil {
+		t.Fatal("failed to read TODO, err =", err)
+	}
+	if got.ETag() != etag {
+		t.Errorf("ETag changed with the subtask, the blocker or the tag: got %s, want %s", got.ETag(), etag)
+	}
+
+	// ETag が一致すれば更新でき、更新後は古い ETag では更新できない
+	updated, err := svc.UpdateTODO(ctx, parent.ID, "renamed", "", etag)
+	if err != nil {
+		t.Fatal("UpdateTODO with the current ETag: err =", err)
+	}
+	if updated.ETag() == etag {
+		t.Error("ETag did not change with the subject")
+	}
+	if _, err := svc.UpdateTODO(ctx, parent.ID, "stale", "", etag); !errors.Is(err, &model.ErrPreconditionFailed{}) {
+		t.Errorf("UpdateTODO with a stale ETag: got %v, want ErrPreconditionFailed", err)
+	}
+}
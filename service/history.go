@@ -0,0 +1,375 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"database/sql"
+	"encoding/json"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/actor"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// A TODOAction names the kind of change recorded in the history of a TODO.
+type TODOAction string
+
+const (
+	// ActionCreate records a created TODO.
+	ActionCreate TODOAction = "create"
+	// ActionUpdate records an updated TODO.
+	ActionUpdate TODOAction = "update"
+	// ActionMove records a TODO moved in the manual order.
+	ActionMove TODOAction = "move"
+	// ActionDelete records a TODO moved to the trash.
+	ActionDelete TODOAction = "delete"
+	// ActionRestore records a TODO taken out of the trash.
+	ActionRestore TODOAction = "restore"
+	// ActionPurge records a TODO permanently deleted from the trash.
+	ActionPurge TODOAction = "purge"
+	// ActionRevert records a TODO reverted to a revision.
+	ActionRevert TODOAction = "revert"
+	// ActionUndo records a TODO restored by an undo token.
+	ActionUndo TODOAction = "undo"
+)
+
+// GetTODOHistory reads the changes to the TODO on DB from the latest, returning
+// model.ErrNotFound if there has never been such a TODO of the user. The history of a
+// trashed or purged TODO is kept. Pass the revision of the last event of the
+// previous page as prevRevision to read the next page.
+func (s *TODOService) GetTODOHistory(ctx context.Context, id, prevRevision, size int64) (_ []*model.TODOEvent, err error) {
+	defer logFailure(ctx, "GetTODOHistory", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const (
+		exists = `SELECT EXISTS(SELECT 1 FROM todos WHERE id = ? AND ` + owned + `)
+			OR EXISTS(SELECT 1 FROM todo_events WHERE todo_id = ? AND ` + owned + `)`
+		read = `SELECT id, todo_id, action, old_value, new_value, actor, created_at FROM todo_events
+			WHERE todo_id = ? AND ` + owned
+		after = ` AND id < ?`
+		order = ` ORDER BY id DESC LIMIT ?`
+	)
+
+	owner := ownerID(ctx)
+
+	var found bool
+	if err := s.db.QueryRowContext(ctx, exists, id, owner, id, owner).Scan(&found); err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, &model.ErrNotFound{}
+	}
+
+	query, args := read, []interface{}{id, owner}
+	if prevRevision > 0 {
+		query += after
+		args = append(args, prevRevision)
+	}
+	query += order
+	args = append(args, size)
+
+	return queryEvents(ctx, s.db, query, args...)
+}
+
+// RevertTODO reverts the TODO on DB to the state it had right after the
+// revision: its subject, description, completion, due date, tags, priority,
+// parent, list and recurrence. Dependencies and the manual order are left as they are.
+// It returns a model.ErrValidation of revision unless the revision is one of
+// the TODO, and of parent_id or list_id if they can no longer be restored.
+func (s *TODOService) RevertTODO(ctx context.Context, id, revision int64, ifMatch []string) (_ *model.TODO, err error) {
+	defer logFailure(ctx, "RevertTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const (
+		read = `SELECT id, todo_id, action, old_value, new_value, actor, created_at FROM todo_events
+			WHERE id = ? AND todo_id = ? AND ` + owned
+		update = `UPDATE todos SET subject = ?, description = ?, completed = ?, completed_at = ?, due_at = ?,
+			priority = ?, parent_id = ?, list_id = ?, rrule = ?, updated_at = DATETIME('now') WHERE id = ? AND deleted_at IS NULL`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	old, err := snapshotTODO(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+	if err := checkIfMatch(ctx, tx, id, ifMatch); err != nil {
+		return nil, err
+	}
+
+	event, err := scanEvent(tx.QueryRowContext(ctx, read, revision, id, ownerID(ctx)))
+	if err != nil && err != sql.ErrNoRows {
+		return nil, err
+	}
+	if err == sql.ErrNoRows || event.New == nil {
+		verr := &model.ErrValidation{}
+		verr.Add("revision", "must be a revision of the TODO")
+		return nil, verr
+	}
+	target := event.New
+
+	priority, err := priorityLevel(target.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	// 親やリストが削除・アーカイブされていれば元に戻せない
+	var parentID, listID interface{}
+	if target.ParentID != nil {
+		if err := checkParent(ctx, tx, id, *target.ParentID); err != nil {
+			return nil, err
+		}
+		parentID = *target.ParentID
+	}
+	if target.ListID != nil {
+		if err := checkList(ctx, tx, *target.ListID); err != nil {
+			return nil, err
+		}
+		listID = *target.ListID
+	}
+
+	var completedAt interface{}
+	if target.CompletedAt != nil {
+		completedAt = target.CompletedAt.UTC()
+	}
+
+	_, err = tx.ExecContext(ctx, update, target.Subject, target.Description, target.Completed, completedAt,
+		dueAt(target.DueAt), priority, parentID, listID, nullableString(target.RRule), id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setTODOTags(ctx, tx, id, target.Tags); err != nil {
+		return nil, err
+	}
+
+	if err := recordEvent(ctx, tx, ActionRevert, id, old); err != nil {
+		return nil, err
+	}
+
+	todo, err := getTODO(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return todo, nil
+}
+
+// recordEvent appends the change to the TODO id to its history, attributed to
+// the actor of ctx. old is the TODO before the change, or nil if it has just
+// been created; the TODO after the change is read back with q, and is nil if
+// it has been purged. A change that leaves the TODO as it was is not recorded.
+// The event belongs to the owner of the TODO, who may differ from the user of
+// ctx when the trash is purged for retention.
+func recordEvent(ctx context.Context, q queryer, action TODOAction, id int64, old *model.TODO) error {
+	// 完全に削除された TODO は、それまでの履歴の所有者を引き継ぐ
+	const insert = `INSERT INTO todo_events(todo_id, action, old_value, new_value, actor, owner_id) VALUES(?, ?, ?, ?, ?,
+		COALESCE((SELECT owner_id FROM todos WHERE id = ?), (SELECT owner_id FROM todo_events WHERE todo_id = ? ORDER BY id DESC LIMIT 1)))`
+
+	todos, err := snapshotTODOs(ctx, q, []int64{id})
+	if err != nil {
+		return err
+	}
+	var current *model.TODO
+	if len(todos) > 0 {
+		current = todos[0]
+	}
+
+	if unchanged(old, current) {
+		return nil
+	}
+
+	oldValue, err := eventValue(old)
+	if err != nil {
+		return err
+	}
+	newValue, err := eventValue(current)
+	if err != nil {
+		return err
+	}
+
+	var name interface{}
+	if a := actor.FromContext(ctx); a != "" {
+		name = a
+	}
+
+	_, err = q.ExecContext(ctx, insert, id, action, nullableJSON(oldValue), nullableJSON(newValue), name, id, id)
+	return err
+}
+
+// recordEvents records the same change to each of the TODOs, which are the
+// TODOs before the change.
+func recordEvents(ctx context.Context, q queryer, action TODOAction, olds []*model.TODO) error {
+	for _, old := range olds {
+		if err := recordEvent(ctx, q, action, old.ID, old); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// snapshotTODOs reads the TODOs ids with their positions, whether they are
+// trashed or not, in the order of their ids. TODOs that do not exist are left
+// out.
+func snapshotTODOs(ctx context.Context, q queryer, ids []int64) ([]*model.TODO, error) {
+	const readFmt = `SELECT ` + todoColumns + `, position FROM todos WHERE id IN (%s) ORDER BY id`
+
+	if len(ids) == 0 {
+		return nil, nil
+	}
+
+	query, args := inClause(readFmt, ids)
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var todos []*model.TODO
+	for rows.Next() {
+		var position string
+		todo, err := scanTODO(rows, &position)
+		if err != nil {
+			return nil, err
+		}
+		todo.Position = position
+		todos = append(todos, todo)
+	}
+	return todos, rows.Err()
+}
+
+// snapshotTODO reads the TODO id of the user with its position, like
+// getTODO, to be recorded as the TODO before a change.
+func snapshotTODO(ctx context.Context, q queryer, id int64) (*model.TODO, error) {
+	const read = `SELECT ` + todoColumns + `, position FROM todos WHERE id = ? AND deleted_at IS NULL AND ` + owned
+
+	var position string
+	todo, err := scanTODO(q.QueryRowContext(ctx, read, id, ownerID(ctx)), &position)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+	todo.Position = position
+	return todo, nil
+}
+
+// eventSnapshot is the JSON of a TODO stored in the history. It keeps the
+// position, which the representation of the TODO leaves out.
+type eventSnapshot struct {
+	*model.TODO
+	Position string `json:"position,omitempty"`
+}
+
+// eventValue returns the JSON of the TODO stored in the history, or nil if
+// the TODO is nil. Its progress is left out as it depends on other TODOs.
+func eventValue(todo *model.TODO) ([]byte, error) {
+	if todo == nil {
+		return nil, nil
+	}
+
+	t := *todo
+	t.Progress = nil
+	return json.Marshal(&eventSnapshot{TODO: &t, Position: t.Position})
+}
+
+// unchanged reports whether the TODOs a and b are the same but for their
+// progress and updated_at, which change without the TODO itself changing.
+// Two nil TODOs are the same. The snapshots recorded before positions were
+// kept in the history have none, which matches any position.
+func unchanged(a, b *model.TODO) bool {
+	if a == nil || b == nil {
+		return a == nil && b == nil
+	}
+
+	x, y := *a, *b
+	x.UpdatedAt, y.UpdatedAt = time.Time{}, time.Time{}
+	if x.Position == "" || y.Position == "" {
+		x.Position, y.Position = "", ""
+	}
+	bx, errx := eventValue(&x)
+	by, erry := eventValue(&y)
+	return errx == nil && erry == nil && bytes.Equal(bx, by)
+}
+
+// nullableJSON returns the JSON as the value of a nullable TEXT column.
+func nullableJSON(b []byte) interface{} {
+	if b == nil {
+		return nil
+	}
+	return string(b)
+}
+
+// nullableString returns s as the value of a nullable TEXT column.
+func nullableString(s string) interface{} {
+	if s == "" {
+		return nil
+	}
+	return s
+}
+
+// queryEvents reads the events selected by the query.
+func queryEvents(ctx context.Context, q queryer, query string, args ...interface{}) ([]*model.TODOEvent, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []*model.TODOEvent{}
+	for rows.Next() {
+		event, err := scanEvent(rows)
+		if err != nil {
+			return nil, err
+		}
+		events = append(events, event)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return events, nil
+}
+
+// scanEvent scans a row of todo_events into a TODOEvent.
+func scanEvent(row rowScanner) (*model.TODOEvent, error) {
+	var (
+		event              model.TODOEvent
+		oldValue, newValue sql.NullString
+		name               sql.NullString
+	)
+	if err := row.Scan(&event.Revision, &event.TODOID, &event.Action, &oldValue, &newValue, &name, &event.CreatedAt); err != nil {
+		return nil, err
+	}
+	event.Actor = name.String
+
+	for _, v := range []struct {
+		value sql.NullString
+		dest  **model.TODO
+	}{{oldValue, &event.Old}, {newValue, &event.New}} {
+		if !v.value.Valid {
+			continue
+		}
+		snapshot := eventSnapshot{TODO: &model.TODO{}}
+		if err := json.Unmarshal([]byte(v.value.String), &snapshot); err != nil {
+			return nil, err
+		}
+		snapshot.TODO.Position = snapshot.Position
+		*v.dest = snapshot.TODO
+	}
+	return &event, nil
+}
@@ -0,0 +1,307 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxListNameLength is the maximum number of characters in a list name.
+const maxListNameLength = 100
+
+// listColumns is the column list that scanList expects.
+const listColumns = `id, name, archived_at,
+	(SELECT COUNT(*) FROM todos WHERE list_id = lists.id AND owner_id IS lists.owner_id AND deleted_at IS NULL),
+	created_at, updated_at`
+
+// unarchived matches the TODOs that belong to no list or to a list of their
+// owner that is not archived.
+const unarchived = `(list_id IS NULL OR list_id NOT IN (SELECT id FROM lists WHERE archived_at IS NOT NULL AND owner_id IS todos.owner_id))`
+
+// A ListService implements CRUD of List entities. Like TODOs, a List belongs
+// to the user of the request that created it, and only that user sees it.
+type ListService struct {
+	db          *sql.DB
+	requireUser bool
+}
+
+// NewListService returns new ListService.
+func NewListService(db *sql.DB) *ListService {
+	return &ListService{
+		db: db,
+	}
+}
+
+// SetRequireUser sets whether a request without a user is rejected, see
+// TODOService.SetRequireUser.
+func (s *ListService) SetRequireUser(require bool) {
+	s.requireUser = require
+}
+
+// CreateList creates a List on DB, returning model.ErrConflict if the name is
+// taken by another List of the user.
+func (s *ListService) CreateList(ctx context.Context, name string) (_ *model.List, err error) {
+	defer logFailure(ctx, "CreateList", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const insert = `INSERT INTO lists(name, owner_id) VALUES(?, ?)`
+
+	name, err = normalizeName("name", name, maxListNameLength)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, insert, name, ownerID(ctx))
+	if err != nil {
+		return nil, listError(err, name)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := getList(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// GetList reads the List on DB by id.
+func (s *ListService) GetList(ctx context.Context, id int64) (_ *model.List, err error) {
+	defer logFailure(ctx, "GetList", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	return getList(ctx, s.db, id)
+}
+
+// ReadLists reads the Lists on DB ordered by name. Archived Lists are read
+// only if includeArchived is true.
+func (s *ListService) ReadLists(ctx context.Context, includeArchived bool) (_ []*model.List, err error) {
+	defer logFailure(ctx, "ReadLists", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	query := `SELECT ` + listColumns + ` FROM lists WHERE ` + owned
+	if !includeArchived {
+		query += ` AND archived_at IS NULL`
+	}
+	query += ` ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, query, ownerID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	lists := []*model.List{}
+	for rows.Next() {
+		list, err := scanList(rows)
+		if err != nil {
+			return nil, err
+		}
+		lists = append(lists, list)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return lists, nil
+}
+
+// RenameList renames the List on DB, returning model.ErrConflict if the name
+// is taken by another List of the user.
+func (s *ListService) RenameList(ctx context.Context, id int64, name string) (_ *model.List, err error) {
+	defer logFailure(ctx, "RenameList", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const update = `UPDATE lists SET name = ? WHERE id = ? AND ` + owned
+
+	name, err = normalizeName("name", name, maxListNameLength)
+	if err != nil {
+		return nil, err
+	}
+
+	list, err := s.updateList(ctx, id, update, name, id, ownerID(ctx))
+	if err != nil {
+		return nil, listError(err, name)
+	}
+	return list, nil
+}
+
+// ArchiveList archives or unarchives the List on DB. The TODOs of an archived
+// List are kept but hidden from FilterTODO and SearchTODO unless asked for.
+// Archiving an already archived List keeps its original archived_at.
+func (s *ListService) ArchiveList(ctx context.Context, id int64, archived bool) (_ *model.List, err error) {
+	defer logFailure(ctx, "ArchiveList", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const update = `UPDATE lists SET archived_at = CASE WHEN ? THEN COALESCE(archived_at, ?) END WHERE id = ? AND ` + owned
+
+	return s.updateList(ctx, id, update, archived, time.Now().UTC(), id, ownerID(ctx))
+}
+
+// DeleteList deletes the empty List on DB by id, returning model.ErrConflict
+// if TODOs still belong to it. Trashed TODOs of the List are taken out of it.
+func (s *ListService) DeleteList(ctx context.Context, id int64) (err error) {
+	defer logFailure(ctx, "DeleteList", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return err
+	}
+
+	const (
+		count      = `SELECT COUNT(*) FROM todos WHERE list_id = ? AND deleted_at IS NULL AND ` + owned
+		unlist     = `UPDATE todos SET list_id = NULL WHERE list_id = ? AND ` + owned
+		deleteByID = `DELETE FROM lists WHERE id = ? AND ` + owned
+	)
+
+	owner := ownerID(ctx)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var n int
+	if err := tx.QueryRowContext(ctx, count, id, owner).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return &model.ErrConflict{Reason: "list has TODOs; move or delete them first, or archive the list instead"}
+	}
+
+	// ゴミ箱の TODO はリストから外しておく
+	if _, err := tx.ExecContext(ctx, unlist, id, owner); err != nil {
+		return err
+	}
+
+	res, err := tx.ExecContext(ctx, deleteByID, id, owner)
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return &model.ErrNotFound{}
+	}
+
+	return tx.Commit()
+}
+
+// updateList executes the update of the List id and reads it back.
+func (s *ListService) updateList(ctx context.Context, id int64, update string, args ...interface{}) (*model.List, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, update, args...)
+	if err != nil {
+		return nil, err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, &model.ErrNotFound{}
+	}
+
+	list, err := getList(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return list, nil
+}
+
+// listError converts the unique constraint violation of a list name into model.ErrConflict.
+func listError(err error, name string) error {
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return &model.ErrConflict{Reason: "list " + name + " already exists"}
+	}
+	return err
+}
+
+// getList reads the List of the user of ctx by id, returning
+// model.ErrNotFound if there is none.
+func getList(ctx context.Context, q queryer, id int64) (*model.List, error) {
+	const read = `SELECT ` + listColumns + ` FROM lists WHERE id = ? AND ` + owned
+
+	list, err := scanList(q.QueryRowContext(ctx, read, id, ownerID(ctx)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+	return list, nil
+}
+
+// scanList scans a row selected with listColumns into a List.
+func scanList(row rowScanner) (*model.List, error) {
+	var list model.List
+	if err := row.Scan(&list.ID, &list.Name, &list.ArchivedAt, &list.TODOCount, &list.CreatedAt, &list.UpdatedAt); err != nil {
+		return nil, err
+	}
+	list.Archived = list.ArchivedAt != nil
+	return &list, nil
+}
+
+// checkList returns a model.ErrValidation of list_id unless the List listID
+// of the user exists and is not archived, so that TODOs are not added to
+// hidden Lists or Lists of others.
+func checkList(ctx context.Context, q queryer, listID int64) error {
+	list, err := getList(ctx, q, listID)
+	if err != nil && !errors.Is(err, &model.ErrNotFound{}) {
+		return err
+	}
+
+	verr := &model.ErrValidation{}
+	switch {
+	case list == nil:
+		verr.Add("list_id", "must be the id of an existing list")
+	case list.Archived:
+		verr.Add("list_id", "must not be an archived list")
+	default:
+		return nil
+	}
+	return verr
+}
@@ -0,0 +1,214 @@
+package service
+
+import (
+	"errors"
+	"strings"
+)
+
+// Positions order TODOs manually. A position is a fractional index: positions
+// compare as byte strings, and a TODO is moved by giving it a new position
+// between its new neighbours, so that no other TODO has to be renumbered.
+//
+// A position consists of an integer part and a fraction, both written with
+// positionDigits. The first character of the integer part tells its length:
+// 'a' to 'z' for the non-negative integers of 1 to 26 digits and 'Z' to 'A'
+// for the negative ones, so that integers compare in order as strings.
+// Prepending and appending only increment or decrement the integer part, and
+// a fraction is needed only to insert between two consecutive integers.
+const positionDigits = "0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+
+// positionZero is the position of the first TODO.
+const positionZero = "a0"
+
+// errPositionExhausted is returned when no position exists beyond the given one.
+var errPositionExhausted = errors.New("position out of range")
+
+// positionBetween returns a position between a and b, where a < b.
+// An empty a means before b, and an empty b means after a.
+func positionBetween(a, b string) (string, error) {
+	switch {
+	case a == "" && b == "":
+		return positionZero, nil
+	case a == "":
+		ib, err := positionInteger(b)
+		if err != nil {
+			return "", err
+		}
+		if ib != b {
+			return ib, nil
+		}
+		i, ok := decrementPosition(ib)
+		if !ok {
+			return ib + midpoint("", b[len(ib):]), nil
+		}
+		return i, nil
+	case b == "":
+		ia, err := positionInteger(a)
+		if err != nil {
+			return "", err
+		}
+		i, ok := incrementPosition(ia)
+		if !ok {
+			return ia + midpoint(a[len(ia):], ""), nil
+		}
+		return i, nil
+	}
+
+	if a >= b {
+		return "", errors.New("position " + a + " is not before " + b)
+	}
+
+	ia, err := positionInteger(a)
+	if err != nil {
+		return "", err
+	}
+	ib, err := positionInteger(b)
+	if err != nil {
+		return "", err
+	}
+	if ia == ib {
+		return ia + midpoint(a[len(ia):], b[len(ib):]), nil
+	}
+
+	i, ok := incrementPosition(ia)
+	if !ok {
+		return "", errPositionExhausted
+	}
+	if i < b {
+		return i, nil
+	}
+	return ia + midpoint(a[len(ia):], ""), nil
+}
+
+// positionInteger returns the integer part of the position.
+func positionInteger(p string) (string, error) {
+	if p == "" {
+		return "", errors.New("empty position")
+	}
+
+	var n int
+	switch head := p[0]; {
+	case head >= 'a' && head <= 'z':
+		n = int(head-'a') + 2
+	case head >= 'A' && head <= 'Z':
+		n = int('Z'-head) + 2
+	default:
+		return "", errors.New("invalid position " + p)
+	}
+	if len(p) < n {
+		return "", errors.New("invalid position " + p)
+	}
+	return p[:n], nil
+}
+
+// midpoint returns a fraction between the fractions a and b, where a < b.
+// An empty b means no upper bound. Neither a nor b may end with '0', so that
+// there is always room before them.
+func midpoint(a, b string) string {
+	if b != "" {
+		// 共通の接頭辞はそのまま残し、残りの間を取る
+		n := 0
+		for n < len(b) && digitAt(a, n) == b[n] {
+			n++
+		}
+		if n > 0 {
+			return b[:n] + midpoint(suffix(a, n), b[n:])
+		}
+	}
+
+	da := 0
+	if a != "" {
+		da = strings.IndexByte(positionDigits, a[0])
+	}
+	db := len(positionDigits)
+	if b != "" {
+		db = strings.IndexByte(positionDigits, b[0])
+	}
+
+	if db-da > 1 {
+		return string(positionDigits[(da+db+1)/2])
+	}
+
+	// 先頭の桁が隣り合っている場合は次の桁で間を取る
+	if len(b) > 1 {
+		return b[:1]
+	}
+	return string(positionDigits[da]) + midpoint(suffix(a, 1), "")
+}
+
+// incrementPosition returns the integer following the integer part i.
+// It reports false if i is the largest integer.
+func incrementPosition(i string) (string, bool) {
+	head, digits := i[0], []byte(i[1:])
+	for k := len(digits) - 1; k >= 0; k-- {
+		d := strings.IndexByte(positionDigits, digits[k]) + 1
+		if d < len(positionDigits) {
+			digits[k] = positionDigits[d]
+			return string(head) + string(digits), true
+		}
+		digits[k] = positionDigits[0]
+	}
+
+	// 桁あふれした場合は桁数を一つ増やす
+	switch head {
+	case 'Z':
+		return "a" + positionDigits[:1], true
+	case 'z':
+		return "", false
+	}
+	head++
+	if head > 'a' {
+		digits = append(digits, positionDigits[0])
+	} else {
+		digits = digits[:len(digits)-1]
+	}
+	return string(head) + string(digits), true
+}
+
+// decrementPosition returns the integer preceding the integer part i.
+// It reports false if i is the smallest integer.
+func decrementPosition(i string) (string, bool) {
+	last := positionDigits[len(positionDigits)-1]
+
+	head, digits := i[0], []byte(i[1:])
+	for k := len(digits) - 1; k >= 0; k-- {
+		d := strings.IndexByte(positionDigits, digits[k]) - 1
+		if d >= 0 {
+			digits[k] = positionDigits[d]
+			return string(head) + string(digits), true
+		}
+		digits[k] = last
+	}
+
+	// 桁が足りなくなった場合は桁数を一つ増やす
+	switch head {
+	case 'a':
+		return "Z" + string(last), true
+	case 'A':
+		return "", false
+	}
+	head--
+	if head < 'Z' {
+		digits = append(digits, last)
+	} else {
+		digits = digits[:len(digits)-1]
+	}
+	return string(head) + string(digits), true
+}
+
+// digitAt returns the n-th digit of the fraction f, which is implicitly
+// followed by zeros.
+func digitAt(f string, n int) byte {
+	if n < len(f) {
+		return f[n]
+	}
+	return positionDigits[0]
+}
+
+// suffix returns f without its first n digits.
+func suffix(f string, n int) string {
+	if n < len(f) {
+		return f[n:]
+	}
+	return ""
+}
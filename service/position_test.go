@@ -0,0 +1,80 @@
+package service
+
+import (
+	"math/rand"
+	"sort"
+	"testing"
+)
+
+func TestPositionBetween(t *testing.T) {
+	tests := []struct {
+		a, b, want string
+	}{
+		{"", "", "a0"},
+		{"", "a0", "Zz"},
+		{"a0", "", "a1"},
+		{"a0", "a1", "a0V"},
+		{"a1", "a2", "a1V"},
+		{"a0V", "a1", "a0l"},
+		{"Zz", "a0", "ZzV"},
+		{"az", "", "b00"},
+		{"", "b00", "az"},
+		{"h00000000", "h00000001", "h00000000V"},
+		{"", "h00000000", "gzzzzzzz"},
+	}
+	for _, tt := range tests {
+		got, err := positionBetween(tt.a, tt.b)
+		if err != nil {
+			t.Errorf("positionBetween(%q, %q) error: %v", tt.a, tt.b, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("positionBetween(%q, %q) = %q, want %q", tt.a, tt.b, got, tt.want)
+		}
+	}
+}
+
+func TestPositionBetweenKeepsOrder(t *testing.T) {
+	rnd := rand.New(rand.NewSource(1))
+
+	positions := []string{}
+	for i := 0; i < 2000; i++ {
+		// 先頭・末尾・途中への挿入を混ぜる
+		k := rnd.Intn(len(positions) + 1)
+		switch rnd.Intn(3) {
+		case 0:
+			k = 0
+		case 1:
+			k = len(positions)
+		}
+
+		var a, b string
+		if k > 0 {
+			a = positions[k-1]
+		}
+		if k < len(positions) {
+			b = positions[k]
+		}
+
+		p, err := positionBetween(a, b)
+		if err != nil {
+			t.Fatalf("positionBetween(%q, %q) error: %v", a, b, err)
+		}
+		if (a != "" && p <= a) || (b != "" && p >= b) {
+			t.Fatalf("positionBetween(%q, %q) = %q is out of order", a, b, p)
+		}
+
+		positions = append(positions, "")
+		copy(positions[k+1:], positions[k:])
+		positions[k] = p
+	}
+
+	if !sort.StringsAreSorted(positions) {
+		t.Fatal("positions are not sorted")
+	}
+	for _, p := range positions {
+		if len(p) > 40 {
+			t.Errorf("position %q is too long", p)
+		}
+	}
+}
@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/rrule"
+)
+
+// PreviewOccurrences returns the due dates of up to n occurrences that follow
+// the TODO on DB in its series, fewer if COUNT or UNTIL ends the recurrence
+// first and none if the TODO does not recur. A TODO without a due date recurs
+// from when it is completed, so the preview then starts from now.
+func (s *TODOService) PreviewOccurrences(ctx context.Context, id int64, n int) (_ []time.Time, err error) {
+	defer logFailure(ctx, "PreviewOccurrences", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	todo, err := getTODO(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	_, occurrence, err := seriesOf(ctx, s.db, id)
+	if err != nil {
+		return nil, err
+	}
+
+	return nextOccurrences(todo, occurrence, time.Now(), n)
+}
+
+// spawnNextOccurrence creates the next occurrence of the recurring TODO id
+// that has just been completed, unless the recurrence has ended or the
+// occurrence already exists. The new TODO is a copy of the completed one but
+// for its due date, dependencies and completion.
+func spawnNextOccurrence(ctx context.Context, q queryer, id int64) error {
+	const (
+		// 完了を取り消して再び完了しても、次の回は一度しか作らない
+		exists = `SELECT EXISTS(SELECT 1 FROM todos WHERE COALESCE(series_id, id) = ? AND occurrence > ?)`
+		insert = `INSERT INTO todos(subject, description, due_at, priority, position, parent_id, list_id, rrule, series_id, occurrence, owner_id)
+			VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?, ?, ?)`
+	)
+
+	todo, err := getTODO(ctx, q, id)
+	if err != nil {
+		return err
+	}
+	if todo.RRule == "" {
+		return nil
+	}
+
+	seriesID, occurrence, err := seriesOf(ctx, q, id)
+	if err != nil {
+		return err
+	}
+
+	var spawned bool
+	if err := q.QueryRowContext(ctx, exists, seriesID, occurrence).Scan(&spawned); err != nil {
+		return err
+	}
+	if spawned {
+		return nil
+	}
+
+	from := time.Now()
+	if todo.CompletedAt != nil {
+		from = *todo.CompletedAt
+	}
+	next, err := nextOccurrences(todo, occurrence, from, 1)
+	if err != nil || len(next) == 0 {
+		return err
+	}
+
+	priority, err := priorityLevel(todo.Priority)
+	if err != nil {
+		return err
+	}
+	position, err := firstPosition(ctx, q)
+	if err != nil {
+		return err
+	}
+
+	res, err := q.ExecContext(ctx, insert, todo.Subject, todo.Description, dueAt(&next[0]), priority, position,
+		todo.ParentID, todo.ListID, todo.RRule, seriesID, occurrence+1, ownerID(ctx))
+	if err != nil {
+		return err
+	}
+
+	nextID, err := res.LastInsertId()
+	if err != nil {
+		return err
+	}
+
+	if err := setTODOTags(ctx, q, nextID, todo.Tags); err != nil {
+		return err
+	}
+
+	return recordEvent(ctx, q, ActionCreate, nextID, nil)
+}
+
+// nextOccurrences returns the due dates of up to n occurrences that follow
+// the TODO, which is the given occurrence of its series counted from 1. The
+// recurrence starts from the due date of the TODO, or from the time from if
+// it has none.
+func nextOccurrences(todo *model.TODO, occurrence int64, from time.Time, n int) ([]time.Time, error) {
+	next := []time.Time{}
+	if todo.RRule == "" {
+		return next, nil
+	}
+
+	rule, err := rrule.Parse(todo.RRule, time.Local)
+	if err != nil {
+		return nil, err
+	}
+
+	// COUNT は系列の最初の回から数える
+	if rule.Count > 0 {
+		if left := int64(rule.Count) - occurrence; left < int64(n) {
+			n = int(left)
+		}
+		rule.Count = 0
+	}
+	if n <= 0 {
+		return next, nil
+	}
+
+	dtstart := from.Truncate(time.Second)
+	if todo.DueAt != nil {
+		dtstart = *todo.DueAt
+	}
+
+	// 最初の回は TODO 自身
+	for i, t := range rule.Occurrences(dtstart.In(time.Local), n+1) {
+		if i > 0 {
+			next = append(next, t.UTC())
+		}
+	}
+	return next, nil
+}
+
+// seriesOf returns the id of the first TODO of the series the TODO id belongs
+// to and which occurrence of the series it is.
+func seriesOf(ctx context.Context, q queryer, id int64) (seriesID, occurrence int64, err error) {
+	const read = `SELECT COALESCE(series_id, id), occurrence FROM todos WHERE id = ?`
+
+	err = q.QueryRowContext(ctx, read, id).Scan(&seriesID, &occurrence)
+	return seriesID, occurrence, err
+}
+
+// normalizeRRule returns the value stored in the rrule column for the
+// recurrence rule s, or a model.ErrValidation of field if it is invalid.
+func normalizeRRule(field, s string) (interface{}, error) {
+	if s == "" {
+		return nil, nil
+	}
+
+	rule, err := rrule.Parse(s, time.Local)
+	if err != nil {
+		verr := &model.ErrValidation{}
+		verr.Add(field, err.Error())
+		return nil, verr
+	}
+	return rule.String(), nil
+}
@@ -0,0 +1,88 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// TestSearchTODO runs with FTS5 only if built with `-tags sqlite_fts5`, and
+// otherwise checks that searching reports it is unavailable.
+func TestSearchTODO(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "search_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	var (
+		users = NewUserService(todoDB)
+		todos = NewTODOService(todoDB)
+	)
+	login := func(name string) context.Context {
+		u, err := users.EnsureUser(context.Background(), name)
+		if err != nil {
+			t.Fatal("failed to create user, err =", err)
+		}
+		return auth.NewContext(context.Background(), u)
+	}
+	alice, bob := login("alice"), login("bob")
+
+	available, err := db.HasFTS5(todoDB)
+	if err != nil {
+		t.Fatal("failed to check FTS5, err =", err)
+	}
+	if !available {
+		if _, err := todos.SearchTODO(alice, "milk", 0, 10); !errors.Is(err, &model.ErrSearchUnavailable{}) {
+			t.Errorf("SearchTODO without FTS5: got %v, want ErrSearchUnavailable", err)
+		}
+		return
+	}
+
+	create := func(ctx context.Context, subject, description string) int64 {
+		todo, err := todos.CreateTODO(ctx, subject, description)
+		if err != nil {
+			t.Fatal("failed to create TODO, err =", err)
+		}
+		return todo.ID
+	}
+	inDescription := create(alice, "shopping", "milk and eggs")
+	inSubject := create(alice, "buy milk", "")
+	create(alice, "bread", "")
+	trashed := create(alice, "old milk", "")
+	if err := todos.DeleteTODO(alice, []int64{trashed}); err != nil {
+		t.Fatal("failed to delete TODO, err =", err)
+	}
+	create(bob, "milk of bob", "")
+
+	// 件名の一致は説明の一致より上位になり、ゴミ箱と他のユーザーの TODO は出ない
+	hits, err := todos.SearchTODO(alice, "milk", 0, 10)
+	if err != nil {
+		t.Fatal("failed to search, err =", err)
+	}
+	var got []int64
+	for _, hit := range hits {
+		got = append(got, hit.TODO.ID)
+	}
+	if len(got) != 2 || got[0] != inSubject || got[1] != inDescription {
+		t.Fatalf("SearchTODO: got %v, want [%d %d]", got, inSubject, inDescription)
+	}
+	if hits[0].Snippet != "buy <mark>milk</mark>" {
+		t.Errorf("SearchTODO: got snippet %q", hits[0].Snippet)
+	}
+
+	// 最後のヒットの id で次のページを読む
+	page, err := todos.SearchTODO(alice, "milk", inSubject, 1)
+	if err != nil || len(page) != 1 || page[0].TODO.ID != inDescription {
+		t.Errorf("SearchTODO after %d: got %v, %v, want TODO %d", inSubject, page, err, inDescription)
+	}
+
+	if _, err := todos.SearchTODO(alice, `"unterminated`, 0, 10); !errors.Is(err, &model.ErrValidation{}) {
+		t.Errorf("SearchTODO with an invalid query: got %v, want ErrValidation", err)
+	}
+}
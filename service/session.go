@@ -0,0 +1,258 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/jwt"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// SessionConfig configures SessionService.
+type SessionConfig struct {
+	// Audience is the "aud" claim of the access tokens, which they must carry
+	// to authenticate.
+	Audience string
+	// AccessTTL is how long an access token is valid.
+	AccessTTL time.Duration
+	// RefreshTTL is how long a refresh token is valid.
+	RefreshTTL time.Duration
+}
+
+// A SessionService implements the sessions of signed-in users. A session is
+// a short-lived JWT access token, which is verified without DB, and a refresh
+// token stored on DB, which can be revoked.
+type SessionService struct {
+	db   *sql.DB
+	keys *jwt.KeySet
+	cfg  SessionConfig
+}
+
+// NewSessionService returns new SessionService that signs access tokens with keys.
+func NewSessionService(db *sql.DB, keys *jwt.KeySet, cfg SessionConfig) *SessionService {
+	return &SessionService{
+		db:   db,
+		keys: keys,
+		cfg:  cfg,
+	}
+}
+
+// StartSession returns a new Session of the user.
+func (s *SessionService) StartSession(ctx context.Context, u *model.User) (_ *model.Session, err error) {
+	defer logFailure(ctx, "StartSession", &err)
+
+	family, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	session, err := s.issueSession(ctx, tx, u, family)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// RefreshSession returns the next Session for the refresh token, which is
+// used up. Presenting a used refresh token again revokes every token of its
+// session, as it has leaked to someone. It returns model.ErrUnauthorized if
+// the token is unknown, used, revoked or expired.
+func (s *SessionService) RefreshSession(ctx context.Context, token string) (_ *model.Session, err error) {
+	defer logFailure(ctx, "RefreshSession", &err)
+
+	const (
+		read = `SELECT refresh_tokens.id, refresh_tokens.family, refresh_tokens.expires_at,
+				refresh_tokens.used_at, refresh_tokens.revoked_at,
+				users.id, users.name, users.created_at, users.updated_at
+			FROM refresh_tokens JOIN users ON users.id = refresh_tokens.user_id
+			WHERE refresh_tokens.token_hash = ?`
+		use = `UPDATE refresh_tokens SET used_at = ? WHERE id = ?`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var (
+		id                int64
+		family            string
+		expiresAt         time.Time
+		usedAt, revokedAt *time.Time
+		u                 model.User
+	)
+	err = tx.QueryRowContext(ctx, read, hashToken(token)).Scan(&id, &family, &expiresAt, &usedAt, &revokedAt, &u.ID, &u.Name, &u.CreatedAt, &u.UpdatedAt)
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrUnauthorized{}
+		}
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	switch {
+	case revokedAt != nil, !now.Before(expiresAt):
+		return nil, &model.ErrUnauthorized{}
+	case usedAt != nil:
+		// 使用済みのトークンが再び来たら漏洩とみなし、セッションごと失効させる
+		if err := revokeFamily(ctx, tx, family, now); err != nil {
+			return nil, err
+		}
+		if err := tx.Commit(); err != nil {
+			return nil, err
+		}
+		return nil, &model.ErrUnauthorized{}
+	}
+
+	if _, err := tx.ExecContext(ctx, use, now, id); err != nil {
+		return nil, err
+	}
+
+	session, err := s.issueSession(ctx, tx, &u, family)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return session, nil
+}
+
+// EndSession revokes every refresh token of the session of the refresh token.
+// Access tokens already issued stay valid until they expire. Ending an ended
+// or unknown session changes nothing.
+func (s *SessionService) EndSession(ctx context.Context, token string) (err error) {
+	defer logFailure(ctx, "EndSession", &err)
+
+	const read = `SELECT family FROM refresh_tokens WHERE token_hash = ?`
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	var family string
+	if err := tx.QueryRowContext(ctx, read, hashToken(token)).Scan(&family); err != nil {
+		if err == sql.ErrNoRows {
+			return nil
+		}
+		return err
+	}
+
+	if err := revokeFamily(ctx, tx, family, time.Now().UTC()); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// AuthenticateAccessToken returns the user of the access token. It returns
+// model.ErrUnauthorized if the token is not valid now, not meant for the
+// audience, or of no user.
+func (s *SessionService) AuthenticateAccessToken(ctx context.Context, token string) (_ *model.User, err error) {
+	defer logFailure(ctx, "AuthenticateAccessToken", &err)
+
+	const read = `SELECT id, name, created_at, updated_at FROM users WHERE id = ?`
+
+	claims, err := s.keys.Verify(token, s.cfg.Audience, time.Now())
+	if err != nil {
+		return nil, &model.ErrUnauthorized{}
+	}
+	id, err := strconv.ParseInt(claims.Subject, 10, 64)
+	if err != nil {
+		return nil, &model.ErrUnauthorized{}
+	}
+
+	u, err := scanUser(s.db.QueryRowContext(ctx, read, id))
+	if err != nil {
+		if errors.Is(err, &model.ErrNotFound{}) {
+			return nil, &model.ErrUnauthorized{}
+		}
+		return nil, err
+	}
+	return u, nil
+}
+
+// issueSession returns a new Session of the user, storing its refresh token
+// in the family. Expired refresh tokens are deleted on the way, so that the
+// table does not grow without bound.
+func (s *SessionService) issueSession(ctx context.Context, tx *sql.Tx, u *model.User, family string) (*model.Session, error) {
+	const (
+		purge  = `DELETE FROM refresh_tokens WHERE expires_at < ?`
+		insert = `INSERT INTO refresh_tokens(user_id, family, token_hash, expires_at) VALUES(?, ?, ?, ?)`
+	)
+
+	now := time.Now().UTC()
+	if _, err := tx.ExecContext(ctx, purge, now); err != nil {
+		return nil, err
+	}
+
+	refresh, err := randomHex(32)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, insert, u.ID, family, hashToken(refresh), now.Add(s.cfg.RefreshTTL)); err != nil {
+		return nil, err
+	}
+
+	jti, err := randomHex(16)
+	if err != nil {
+		return nil, err
+	}
+	access, err := s.keys.Sign(&jwt.Claims{
+		Subject:   strconv.FormatInt(u.ID, 10),
+		Audience:  jwt.Audience{s.cfg.Audience},
+		ExpiresAt: now.Add(s.cfg.AccessTTL).Unix(),
+		NotBefore: now.Unix(),
+		IssuedAt:  now.Unix(),
+		ID:        jti,
+		Name:      u.Name,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return &model.Session{
+		AccessToken:  access,
+		TokenType:    "Bearer",
+		ExpiresIn:    int64(s.cfg.AccessTTL / time.Second),
+		RefreshToken: refresh,
+	}, nil
+}
+
+// revokeFamily revokes the refresh tokens of the family that are not revoked yet.
+func revokeFamily(ctx context.Context, tx *sql.Tx, family string, now time.Time) error {
+	const update = `UPDATE refresh_tokens SET revoked_at = ? WHERE family = ? AND revoked_at IS NULL`
+
+	_, err := tx.ExecContext(ctx, update, now, family)
+	return err
+}
+
+// randomHex returns n random bytes encoded in hex.
+func randomHex(n int) (string, error) {
+	b := make([]byte, n)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
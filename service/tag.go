@@ -0,0 +1,320 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+	"unicode"
+	"unicode/utf8"
+
+	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/mattn/go-sqlite3"
+)
+
+// maxTagNameLength is the maximum number of characters in a tag name.
+const maxTagNameLength = 64
+
+// tagSeparator separates the tag names aggregated into a single column.
+// Tag names cannot contain control characters, so it never appears in a name.
+const tagSeparator = "\x1f"
+
+// tagColumns is the column list that scanTag expects.
+const tagColumns = `id, name, (SELECT COUNT(*) FROM todo_tags JOIN todos ON todos.id = todo_tags.todo_id
+	WHERE todo_tags.tag_id = tags.id AND todos.owner_id IS tags.owner_id)`
+
+// A TagService implements CRUD of Tag entities. Like TODOs, a Tag belongs to
+// the user of the request that created it, and only that user sees it.
+type TagService struct {
+	db          *sql.DB
+	requireUser bool
+}
+
+// NewTagService returns new TagService.
+func NewTagService(db *sql.DB) *TagService {
+	return &TagService{
+		db: db,
+	}
+}
+
+// SetRequireUser sets whether a request without a user is rejected, see
+// TODOService.SetRequireUser.
+func (s *TagService) SetRequireUser(require bool) {
+	s.requireUser = require
+}
+
+// CreateTag creates a Tag on DB, returning model.ErrConflict if the name is
+// taken by another Tag of the user.
+func (s *TagService) CreateTag(ctx context.Context, name string) (_ *model.Tag, err error) {
+	defer logFailure(ctx, "CreateTag", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const insert = `INSERT INTO tags(name, owner_id) VALUES(?, ?)`
+
+	name, err = normalizeTagName("name", name)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, insert, name, ownerID(ctx))
+	if err != nil {
+		return nil, tagError(err, name)
+	}
+
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	tag, err := getTag(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+// GetTag reads the Tag on DB by id.
+func (s *TagService) GetTag(ctx context.Context, id int64) (_ *model.Tag, err error) {
+	defer logFailure(ctx, "GetTag", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	return getTag(ctx, s.db, id)
+}
+
+// ReadTags reads the Tags of the user on DB ordered by name.
+func (s *TagService) ReadTags(ctx context.Context) (_ []*model.Tag, err error) {
+	defer logFailure(ctx, "ReadTags", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const read = `SELECT ` + tagColumns + ` FROM tags WHERE ` + owned + ` ORDER BY name`
+
+	rows, err := s.db.QueryContext(ctx, read, ownerID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tags := []*model.Tag{}
+	for rows.Next() {
+		tag, err := scanTag(rows)
+		if err != nil {
+			return nil, err
+		}
+		tags = append(tags, tag)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return tags, nil
+}
+
+// RenameTag renames the Tag on DB, returning model.ErrConflict if the name
+// is taken by another Tag of the user.
+// The new name is reflected in every TODO the Tag is attached to.
+func (s *TagService) RenameTag(ctx context.Context, id int64, name string) (_ *model.Tag, err error) {
+	defer logFailure(ctx, "RenameTag", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const update = `UPDATE tags SET name = ? WHERE id = ? AND ` + owned
+
+	name, err = normalizeTagName("name", name)
+	if err != nil {
+		return nil, err
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	res, err := tx.ExecContext(ctx, update, name, id, ownerID(ctx))
+	if err != nil {
+		return nil, tagError(err, name)
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return nil, err
+	}
+	if rowsAffected == 0 {
+		return nil, &model.ErrNotFound{}
+	}
+
+	tag, err := getTag(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return tag, nil
+}
+
+// DeleteTag deletes the Tag on DB by id and detaches it from every TODO.
+func (s *TagService) DeleteTag(ctx context.Context, id int64) (err error) {
+	defer logFailure(ctx, "DeleteTag", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return err
+	}
+
+	const deleteByID = `DELETE FROM tags WHERE id = ? AND ` + owned
+
+	// todo_tags の行は外部キーの ON DELETE CASCADE で削除される
+	res, err := s.db.ExecContext(ctx, deleteByID, id, ownerID(ctx))
+	if err != nil {
+		return err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return err
+	}
+	if rowsAffected == 0 {
+		return &model.ErrNotFound{}
+	}
+
+	return nil
+}
+
+// tagError converts the unique constraint violation of a tag name into model.ErrConflict.
+func tagError(err error, name string) error {
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && serr.ExtendedCode == sqlite3.ErrConstraintUnique {
+		return &model.ErrConflict{Reason: "tag " + name + " already exists"}
+	}
+	return err
+}
+
+// getTag reads the Tag of the user of ctx by id, returning
+// model.ErrNotFound if there is none.
+func getTag(ctx context.Context, q queryer, id int64) (*model.Tag, error) {
+	const read = `SELECT ` + tagColumns + ` FROM tags WHERE id = ? AND ` + owned
+
+	tag, err := scanTag(q.QueryRowContext(ctx, read, id, ownerID(ctx)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+	return tag, nil
+}
+
+// scanTag scans a row selected with tagColumns into a Tag.
+func scanTag(row rowScanner) (*model.Tag, error) {
+	var tag model.Tag
+	if err := row.Scan(&tag.ID, &tag.Name, &tag.TODOCount); err != nil {
+		return nil, err
+	}
+	return &tag, nil
+}
+
+// setTODOTags replaces the tags of the TODO with names, creating the missing
+// tags. The tags are those of the owner of the TODO.
+func setTODOTags(ctx context.Context, q queryer, todoID int64, names []string) error {
+	const (
+		detach = `DELETE FROM todo_tags WHERE todo_id = ?`
+		create = `INSERT INTO tags(name, owner_id) SELECT ?, owner_id FROM todos
+			WHERE id = ? AND NOT EXISTS (SELECT 1 FROM tags WHERE name = ? AND owner_id IS todos.owner_id)`
+		attach = `INSERT INTO todo_tags(todo_id, tag_id) SELECT todos.id, tags.id
+			FROM todos JOIN tags ON tags.owner_id IS todos.owner_id WHERE todos.id = ? AND tags.name = ?`
+	)
+
+	if _, err := q.ExecContext(ctx, detach, todoID); err != nil {
+		return err
+	}
+
+	for _, name := range names {
+		if _, err := q.ExecContext(ctx, create, name, todoID, name); err != nil {
+			return err
+		}
+		if _, err := q.ExecContext(ctx, attach, todoID, name); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// normalizeTags trims and validates the tag names of the field, dropping
+// duplicates that differ only in case.
+func normalizeTags(field string, names []string) ([]string, error) {
+	var (
+		normalized = make([]string, 0, len(names))
+		seen       = make(map[string]bool, len(names))
+	)
+	for _, name := range names {
+		name, err := normalizeTagName(field, name)
+		if err != nil {
+			return nil, err
+		}
+		if key := strings.ToLower(name); !seen[key] {
+			seen[key] = true
+			normalized = append(normalized, name)
+		}
+	}
+	return normalized, nil
+}
+
+// normalizeTagName trims and validates the tag name given as field.
+func normalizeTagName(field, name string) (string, error) {
+	return normalizeName(field, name, maxTagNameLength)
+}
+
+// normalizeName trims and validates the name given as field, which must not
+// be longer than max characters.
+func normalizeName(field, name string, max int) (string, error) {
+	name = strings.TrimSpace(name)
+
+	var reason string
+	switch {
+	case name == "":
+		reason = "must not be empty"
+	case utf8.RuneCountInString(name) > max:
+		reason = fmt.Sprintf("must be at most %d characters", max)
+	case strings.IndexFunc(name, unicode.IsControl) >= 0:
+		reason = "must not contain control characters"
+	default:
+		return name, nil
+	}
+
+	verr := &model.ErrValidation{}
+	verr.Add(field, reason)
+	return "", verr
+}
+
+// splitTags splits the tag names aggregated with tagSeparator, sorted by name
+// ignoring case as tags are. It returns nil if there are no tags.
+func splitTags(s sql.NullString) []string {
+	if !s.Valid || s.String == "" {
+		return nil
+	}
+	tags := strings.Split(s.String, tagSeparator)
+	sort.Slice(tags, func(i, j int) bool { return strings.ToLower(tags[i]) < strings.ToLower(tags[j]) })
+	return tags
+}
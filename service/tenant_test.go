@@ -0,0 +1,231 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// TestTenantIsolation checks that a user can neither read, change, count nor
+// hide the TODOs, lists and tags of another user.
+func TestTenantIsolation(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "tenant_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	var (
+		users = NewUserService(todoDB)
+		todos = NewTODOService(todoDB)
+		lists = NewListService(todoDB)
+		tags  = NewTagService(todoDB)
+	)
+
+	login := func(name string) context.Context {
+		u, err := users.EnsureUser(context.Background(), name)
+		if err != nil {
+			t.Fatal("failed to create user, err =", err)
+		}
+		return auth.NewContext(context.Background(), u)
+	}
+	alice, bob := login("alice"), login("bob")
+
+	// bob の TODO をリストとタグ付きで作る
+	bobList, err := lists.CreateList(bob, "private")
+	if err != nil {
+		t.Fatal("failed to create list, err =", err)
+	}
+	bobTODO, err := todos.InsertTODO(bob, &TODOInput{Subject: "secret", Tags: []string{"private"}, ListID: bobList.ID})
+	if err != nil {
+		t.Fatal("failed to create TODO, err =", err)
+	}
+	bobTags, err := tags.ReadTags(bob)
+	if err != nil || len(bobTags) != 1 {
+		t.Fatalf("unexpected tags of bob, tags = %v, err = %v", bobTags, err)
+	}
+	bobTag := bobTags[0]
+
+	aliceTODO, err := todos.InsertTODO(alice, &TODOInput{Subject: "mine", Tags: []string{"private"}})
+	if err != nil {
+		t.Fatal("failed to create TODO of alice, err =", err)
+	}
+
+	notFound := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, &model.ErrNotFound{}) {
+			t.Errorf("%s: got %v, want ErrNotFound", op, err)
+		}
+	}
+	invalid := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, &model.ErrValidation{}) {
+			t.Errorf("%s: got %v, want ErrValidation", op, err)
+		}
+	}
+
+	// TODO
+	_, err = todos.GetTODO(alice, bobTODO.ID)
+	notFound("GetTODO", err)
+	subject := "stolen"
+	_, err = todos.PatchTODO(alice, bobTODO.ID, &TODOPatch{Subject: &subject})
+	notFound("PatchTODO", err)
+	notFound("DeleteTODO", todos.DeleteTODO(alice, []int64{bobTODO.ID}))
+	_, err = todos.MoveTODO(alice, aliceTODO.ID, bobTODO.ID, 0)
+	invalid("MoveTODO", err)
+	for _, sort := range []TODOSort{SortID, SortDue, SortPriority, SortPosition} {
+		got, err := todos.FilterTODO(alice, &TODOFilter{Sort: sort, PrevID: bobTODO.ID, Size: 10})
+		if err != nil {
+			t.Errorf("FilterTODO sorted by %q: %v", sort, err)
+		}
+		for _, todo := range got {
+			if todo.ID != aliceTODO.ID {
+				t.Errorf("FilterTODO sorted by %q: got TODO %d of another user", sort, todo.ID)
+			}
+		}
+	}
+
+	// bob のゴミ箱の TODO を起点にページングさせない
+	bobTrashed, err := todos.InsertTODO(bob, &TODOInput{Subject: "trashed"})
+	if err != nil {
+		t.Fatal("failed to create TODO, err =", err)
+	}
+	aliceTrashed, err := todos.InsertTODO(alice, &TODOInput{Subject: "trashed"})
+	if err != nil {
+		t.Fatal("failed to create TODO, err =", err)
+	}
+	for _, del := range []struct {
+		ctx context.Context
+		id  int64
+	}{{alice, aliceTrashed.ID}, {bob, bobTrashed.ID}} {
+		if err := todos.DeleteTODO(del.ctx, []int64{del.id}); err != nil {
+			t.Fatal("failed to delete TODO, err =", err)
+		}
+	}
+	if got, err := todos.ReadTrash(alice, bobTrashed.ID, 10); err != nil || len(got) != 0 {
+		t.Errorf("ReadTrash after a TODO of another user: got %v, %v, want no TODOs", got, err)
+	}
+
+	// リスト
+	_, err = lists.GetList(alice, bobList.ID)
+	notFound("GetList", err)
+	if got, err := lists.ReadLists(alice, true); err != nil || len(got) != 0 {
+		t.Errorf("ReadLists: got %v, %v, want no lists", got, err)
+	}
+	_, err = lists.RenameList(alice, bobList.ID, "renamed")
+	notFound("RenameList", err)
+	_, err = lists.ArchiveList(alice, bobList.ID, true)
+	notFound("ArchiveList", err)
+	notFound("DeleteList", lists.DeleteList(alice, bobList.ID))
+	_, err = todos.InsertTODO(alice, &TODOInput{Subject: "intruder", ListID: bobList.ID})
+	invalid("InsertTODO into a list of another user", err)
+	listID := sql.NullInt64{Int64: bobList.ID, Valid: true}
+	_, err = todos.PatchTODO(alice, aliceTODO.ID, &TODOPatch{ListID: &listID})
+	invalid("PatchTODO into a list of another user", err)
+	if _, err := lists.CreateList(alice, "Private"); err != nil {
+		t.Errorf("CreateList with the name of a list of another user: %v", err)
+	}
+
+	// タグ
+	_, err = tags.GetTag(alice, bobTag.ID)
+	notFound("GetTag", err)
+	_, err = tags.RenameTag(alice, bobTag.ID, "renamed")
+	notFound("RenameTag", err)
+	notFound("DeleteTag", tags.DeleteTag(alice, bobTag.ID))
+	aliceTags, err := tags.ReadTags(alice)
+	if err != nil || len(aliceTags) != 1 || aliceTags[0].ID == bobTag.ID || aliceTags[0].TODOCount != 1 {
+		t.Errorf("ReadTags: got %v, %v, want only the tag of alice", aliceTags, err)
+	}
+
+	// bob からは何も変わっていない
+	got, err := todos.GetTODO(bob, bobTODO.ID)
+	if err != nil || got.Subject != "secret" {
+		t.Errorf("GetTODO of bob: got %v, %v", got, err)
+	}
+	if got, err := todos.FilterTODO(bob, &TODOFilter{Size: 10}); err != nil || len(got) != 1 || got[0].ID != bobTODO.ID {
+		t.Errorf("FilterTODO of bob: got %v, %v, want only TODO %d", got, err, bobTODO.ID)
+	}
+	if got, err := lists.GetList(bob, bobList.ID); err != nil || got.Name != "private" || got.Archived || got.TODOCount != 1 {
+		t.Errorf("GetList of bob: got %+v, %v", got, err)
+	}
+	if got, err := tags.GetTag(bob, bobTag.ID); err != nil || got.Name != "private" || got.TODOCount != 1 {
+		t.Errorf("GetTag of bob: got %+v, %v", got, err)
+	}
+}
+
+// TestRequireUser checks that the services that require a user reject the
+// requests without one, instead of serving them the TODOs of no user.
+func TestRequireUser(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "tenant_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	var (
+		todos = NewTODOService(todoDB)
+		lists = NewListService(todoDB)
+		tags  = NewTagService(todoDB)
+		ctx   = context.Background()
+	)
+
+	// 認証を設定する前に作られた TODO
+	legacy, err := todos.InsertTODO(ctx, &TODOInput{Subject: "legacy", Tags: []string{"old"}})
+	if err != nil {
+		t.Fatal("failed to create TODO, err =", err)
+	}
+
+	todos.SetRequireUser(true)
+	lists.SetRequireUser(true)
+	tags.SetRequireUser(true)
+
+	unauthorized := func(op string, err error) {
+		t.Helper()
+		if !errors.Is(err, &model.ErrUnauthorized{}) {
+			t.Errorf("%s: got %v, want ErrUnauthorized", op, err)
+		}
+	}
+
+	_, err = todos.InsertTODO(ctx, &TODOInput{Subject: "anonymous"})
+	unauthorized("InsertTODO", err)
+	_, err = todos.GetTODO(ctx, legacy.ID)
+	unauthorized("GetTODO", err)
+	_, err = todos.FilterTODO(ctx, &TODOFilter{Size: 10})
+	unauthorized("FilterTODO", err)
+	subject := "changed"
+	_, err = todos.PatchTODO(ctx, legacy.ID, &TODOPatch{Subject: &subject})
+	unauthorized("PatchTODO", err)
+	unauthorized("DeleteTODO", todos.DeleteTODO(ctx, []int64{legacy.ID}))
+	_, err = todos.ReadTrash(ctx, 0, 10)
+	unauthorized("ReadTrash", err)
+	_, err = todos.EmptyTrash(ctx)
+	unauthorized("EmptyTrash", err)
+	_, err = lists.ReadLists(ctx, true)
+	unauthorized("ReadLists", err)
+	_, err = lists.CreateList(ctx, "anonymous")
+	unauthorized("CreateList", err)
+	_, err = tags.ReadTags(ctx)
+	unauthorized("ReadTags", err)
+
+	// ユーザーのいるリクエストは影響を受けない
+	alice, err := NewUserService(todoDB).EnsureUser(ctx, "alice")
+	if err != nil {
+		t.Fatal("failed to create user, err =", err)
+	}
+	got, err := todos.FilterTODO(auth.NewContext(ctx, alice), &TODOFilter{Size: 10})
+	if err != nil || len(got) != 0 {
+		t.Errorf("FilterTODO of alice: got %v, %v, want no TODOs", got, err)
+	}
+
+	// ゴミ箱の自動削除はユーザーによらない
+	if _, err := todos.PurgeTrash(ctx, time.Now()); err != nil {
+		t.Errorf("PurgeTrash: %v", err)
+	}
+}
@@ -3,223 +3,1248 @@ package service
 import (
 	"context"
 	"database/sql"
+	"errors"
 	"fmt"
+	"log"
 	"strings"
 	"time"
 
+	"github.com/TechBowl-japan/go-stations/db"
 	"github.com/TechBowl-japan/go-stations/model"
+	"github.com/TechBowl-japan/go-stations/requestid"
 	"github.com/mattn/go-sqlite3"
 )
 
+// todoColumns is the column list that scanTODO expects.
+// The tag names of a TODO are aggregated into a single column joined with
+// tagSeparator, and the ids of its blockers into one joined with commas.
+// Trashed subtasks and blockers are left out.
+const todoColumns = `id, subject, description, completed, completed_at, due_at,
+	(SELECT group_concat(name, char(31)) FROM tags WHERE id IN (SELECT tag_id FROM todo_tags WHERE todo_id = todos.id)),
+	priority, parent_id, list_id, rrule,
+	(WITH RECURSIVE subtasks(subtask_id, completed) AS (
+		SELECT id, completed FROM todos AS c WHERE c.parent_id = todos.id AND c.deleted_at IS NULL
+		UNION
+		SELECT c.id, c.completed FROM todos AS c JOIN subtasks ON c.parent_id = subtasks.subtask_id WHERE c.deleted_at IS NULL
+	) SELECT COUNT(*) || ' ' || IFNULL(SUM(completed), 0) FROM subtasks),
+	(SELECT group_concat(blocker_id) FROM todo_dependencies JOIN todos AS blocker ON blocker.id = todo_dependencies.blocker_id
+		WHERE blocked_id = todos.id AND blocker.deleted_at IS NULL),
+	deleted_at, created_at, updated_at`
+
+// priorities maps the priority column to model.Priority.
+var priorities = []model.Priority{
+	model.PriorityNone,
+	model.PriorityLow,
+	model.PriorityMedium,
+	model.PriorityHigh,
+}
+
+// A TODOStatus filters TODOs by their completion state.
+type TODOStatus string
+
+const (
+	// StatusAll matches every TODO.
+	StatusAll TODOStatus = ""
+	// StatusOpen matches TODOs that are not completed yet.
+	StatusOpen TODOStatus = "open"
+	// StatusCompleted matches completed TODOs.
+	StatusCompleted TODOStatus = "completed"
+)
+
+// A TODODue filters TODOs by their due date.
+type TODODue string
+
+const (
+	// DueAny matches every TODO.
+	DueAny TODODue = ""
+	// DueOverdue matches TODOs that are not completed and past their due date.
+	DueOverdue TODODue = "overdue"
+	// DueToday matches TODOs due during the current day in time.Local.
+	DueToday TODODue = "today"
+)
+
+// A TODOSort orders the TODOs returned by FilterTODO.
+type TODOSort string
+
+const (
+	// SortID orders TODOs from the newest.
+	SortID TODOSort = ""
+	// SortDue orders TODOs from the earliest due date, TODOs without one last.
+	SortDue TODOSort = "due"
+	// SortPriority orders TODOs from the highest priority, then from the newest.
+	SortPriority TODOSort = "priority"
+	// SortPosition orders TODOs in the manual order set by MoveTODO.
+	SortPosition TODOSort = "position"
+)
+
+// A TODOTagMatch tells how FilterTODO matches the tags of the filter.
+type TODOTagMatch string
+
+const (
+	// TagMatchAny matches TODOs that have any of the tags.
+	TagMatchAny TODOTagMatch = "any"
+	// TagMatchAll matches TODOs that have all of the tags.
+	TagMatchAll TODOTagMatch = "all"
+)
+
+// A TODOFilter narrows the TODOs returned by FilterTODO.
+// PrevID is the id of the last TODO of the previous page in the Sort order.
+type TODOFilter struct {
+	PrevID int64
+	Size   int64
+	Status TODOStatus
+	Due    TODODue
+	// DueWithin, if positive, matches TODOs due from now until DueWithin later.
+	DueWithin time.Duration
+	Sort      TODOSort
+	Tags      []string
+	TagMatch  TODOTagMatch
+	// ParentID, if positive, matches the subtasks of the TODO.
+	ParentID int64
+	// ListID, if positive, matches the TODOs of the List, even if it is archived.
+	ListID int64
+	// IncludeArchived matches the TODOs of archived Lists too, which are
+	// hidden by default.
+	IncludeArchived bool
+	// Ready, if not nil, matches the TODOs that are not completed and whose
+	// blockers are all completed, or if false the ones with blockers left.
+	Ready *bool
+}
+
+// A TODOInput describes a TODO created by InsertTODO.
+type TODOInput struct {
+	Subject     string
+	Description string
+	DueAt       *time.Time
+	Tags        []string
+	Priority    model.Priority
+	ParentID    int64
+	ListID      int64
+	// RRule, if not empty, is the RFC 5545 recurrence rule of the TODO.
+	RRule string
+}
+
+// A TODOPatch describes the changes PatchTODO applies to a TODO.
+// Nil fields are left untouched; an invalid DueAt clears the due date, an
+// invalid ParentID makes the TODO top-level, an invalid ListID takes the TODO
+// out of its List, an empty RRule stops the recurrence and Tags replaces all
+// the tags.
+type TODOPatch struct {
+	Subject     *string
+	Description *string
+	Completed   *bool
+	DueAt       *sql.NullTime
+	Tags        *[]string
+	Priority    *model.Priority
+	ParentID    *sql.NullInt64
+	ListID      *sql.NullInt64
+	RRule       *string
+
+	// IfMatch, if not empty, holds the entity tags one of which the
+	// current TODO must match for the patch to be applied.
+	IfMatch []string
+}
+
 // A TODOService implements CRUD of TODO entities.
+// Every method only sees the TODOs of the user of its context, see
+// auth.FromContext, and treats those of other users as if they did not exist.
 type TODOService struct {
-	db *sql.DB
+	db          *sql.DB
+	undoWindow  time.Duration
+	requireUser bool
 }
 
 // NewTODOService returns new TODOService.
 func NewTODOService(db *sql.DB) *TODOService {
 	return &TODOService{
-		db: db,
+		db:         db,
+		undoWindow: DefaultUndoWindow,
 	}
 }
 
+// SetRequireUser sets whether a request without a user is rejected with
+// model.ErrUnauthorized, as a server with authentication configured does.
+// Otherwise, which is the default for a server without authentication, such
+// a request is served the TODOs of no user. PurgeTrash is never restricted.
+func (s *TODOService) SetRequireUser(require bool) {
+	s.requireUser = require
+}
+
 // CreateTODO creates a TODO on DB.
 func (s *TODOService) CreateTODO(ctx context.Context, subject, description string) (*model.TODO, error) {
-    const (
-        insert  = `INSERT INTO todos(subject, description) VALUES(?, ?)`
-        confirm = `SELECT subject, description, created_at, updated_at FROM todos WHERE id = ?`
-    )
+	return s.InsertTODO(ctx, &TODOInput{Subject: subject, Description: description})
+}
 
-	now := time.Now()
+// InsertTODO creates a TODO described by in on DB.
+func (s *TODOService) InsertTODO(ctx context.Context, in *TODOInput) (_ *model.TODO, err error) {
+	defer logFailure(ctx, "CreateTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const insert = `INSERT INTO todos(subject, description, due_at, priority, position, parent_id, list_id, rrule, owner_id)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, ?)`
+
+	tags, err := normalizeTags("tags", in.Tags)
+	if err != nil {
+		return nil, err
+	}
+
+	priority, err := priorityLevel(in.Priority)
+	if err != nil {
+		return nil, err
+	}
+
+	rule, err := normalizeRRule("rrule", in.RRule)
+	if err != nil {
+		return nil, err
+	}
 
-    // トランザクションを開始
-    tx, err := s.db.BeginTx(ctx, nil)
-    if err != nil {
-        return nil, err
-    }
-    defer tx.Rollback()
-
-    // INSERTクエリを実行
-    res, err := tx.ExecContext(ctx, insert, subject, description, now , now)
-    if err != nil {
-        return nil, err // エラーをそのまま返す
-    }
-
-    // 挿入されたレコードのIDを取得
-    id, err := res.LastInsertId()
-    if err != nil {
-        return nil, err
-    }
-
-    // 挿入したレコードを取得
-    var todo model.TODO
-    err = tx.QueryRowContext(ctx, confirm, id).Scan(
-        &todo.Subject,
-        &todo.Description,
-        &todo.CreatedAt,
-        &todo.UpdatedAt,
-    )
-    if err != nil {
-        return nil, err
-    }
-
-    // IDをセット
-    todo.ID = id
-
-    // トランザクションをコミット
-    if err := tx.Commit(); err != nil {
-        return nil, err
-    }
-
-    return &todo, nil
+	// トランザクションを開始
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	// INSERTクエリを実行
+	var parentID interface{}
+	if in.ParentID != 0 {
+		if err := checkParent(ctx, tx, 0, in.ParentID); err != nil {
+			return nil, err
+		}
+		parentID = in.ParentID
+	}
+
+	var listID interface{}
+	if in.ListID != 0 {
+		if err := checkList(ctx, tx, in.ListID); err != nil {
+			return nil, err
+		}
+		listID = in.ListID
+	}
+
+	position, err := firstPosition(ctx, tx)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := tx.ExecContext(ctx, insert, in.Subject, in.Description, dueAt(in.DueAt), priority, position, parentID, listID, rule, ownerID(ctx))
+	if err != nil {
+		return nil, err // エラーをそのまま返す
+	}
+
+	// 挿入されたレコードのIDを取得
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+
+	if err := setTODOTags(ctx, tx, id, tags); err != nil {
+		return nil, err
+	}
+
+	if err := recordEvent(ctx, tx, ActionCreate, id, nil); err != nil {
+		return nil, err
+	}
+
+	// 挿入したレコードを取得
+	todo, err := getTODO(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	// トランザクションをコミット
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return todo, nil
+}
+
+// GetTODO reads the TODO on DB by id.
+func (s *TODOService) GetTODO(ctx context.Context, id int64) (_ *model.TODO, err error) {
+	defer logFailure(ctx, "GetTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	return getTODO(ctx, s.db, id)
 }
 
 // ReadTODO reads TODOs on DB.
 func (s *TODOService) ReadTODO(ctx context.Context, prevID, size int64) ([]*model.TODO, error) {
-    const (
-        read       = `SELECT id, subject, description, created_at, updated_at FROM todos ORDER BY id DESC LIMIT ?`
-        readWithID = `SELECT id, subject, description, created_at, updated_at FROM todos WHERE id < ? ORDER BY id DESC LIMIT ?`
-    )
-
-    var (
-        rows *sql.Rows
-        err  error
-    )
-
-    // PrevID に応じてクエリを選択
-    if prevID > 0 {
-        rows, err = s.db.QueryContext(ctx, readWithID, prevID, size)
-    } else {
-        rows, err = s.db.QueryContext(ctx, read, size)
-    }
-
-    if err != nil {
-        return nil, err
-    }
-    defer rows.Close()
-
-    // TODO スライスを用意
-    todos := []*model.TODO{}
-
-    // rows からデータを取得
-    for rows.Next() {
-        var todo model.TODO
-        if err := rows.Scan(&todo.ID, &todo.Subject, &todo.Description, &todo.CreatedAt, &todo.UpdatedAt); err != nil {
-            return nil, err
-        }
-        todos = append(todos, &todo)
-    }
-
-    // エラーが発生した場合
-    if err := rows.Err(); err != nil {
-        return nil, err
-    }
-
-    return todos, nil
-}
-
-// UpdateTODO updates the TODO on DB.
-func (s *TODOService) UpdateTODO(ctx context.Context, id int64, subject, description string) (*model.TODO, error) {
+	return s.FilterTODO(ctx, &TODOFilter{PrevID: prevID, Size: size})
+}
+
+// FilterTODO reads TODOs on DB that match the filter.
+func (s *TODOService) FilterTODO(ctx context.Context, f *TODOFilter) (_ []*model.TODO, err error) {
+	defer logFailure(ctx, "FilterTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	// ゴミ箱の TODO と他のユーザーの TODO は返さない
+	var (
+		where = []string{`deleted_at IS NULL`, owned}
+		args  = []interface{}{ownerID(ctx)}
+	)
+
+	// PrevID に応じて条件を追加
+	// 期限順では期限のない TODO を最後に並べるため、NULL を最大の日時として比較する
+	// PrevID も自分の TODO でなければならない
+	const cursor = ` FROM todos WHERE id = ? AND deleted_at IS NULL AND ` + owned + `)`
+	var order string
+	switch f.Sort {
+	case SortID:
+		if f.PrevID > 0 {
+			where = append(where, `id < ?`)
+			args = append(args, f.PrevID)
+		}
+		order = `id DESC`
+	case SortDue:
+		if f.PrevID > 0 {
+			where = append(where, `(IFNULL(due_at, '`+noDueAt+`'), id) > (SELECT IFNULL(due_at, '`+noDueAt+`'), id`+cursor)
+			args = append(args, f.PrevID, ownerID(ctx))
+		}
+		order = `IFNULL(due_at, '` + noDueAt + `'), id`
+	case SortPriority:
+		if f.PrevID > 0 {
+			where = append(where, `(priority, id) < (SELECT priority, id`+cursor)
+			args = append(args, f.PrevID, ownerID(ctx))
+		}
+		order = `priority DESC, id DESC`
+	case SortPosition:
+		if f.PrevID > 0 {
+			where = append(where, `position > (SELECT position`+cursor)
+			args = append(args, f.PrevID, ownerID(ctx))
+		}
+		order = `position`
+	default:
+		return nil, fmt.Errorf("unknown sort %q", f.Sort)
+	}
+
+	now := time.Now()
+	switch f.Due {
+	case DueAny:
+	case DueOverdue:
+		where = append(where, `completed = FALSE`, `due_at < ?`)
+		args = append(args, now.UTC())
+	case DueToday:
+		// 「今日」は time.Local での日付で判定する
+		y, m, d := now.Date()
+		start := time.Date(y, m, d, 0, 0, 0, 0, time.Local)
+		where = append(where, `due_at >= ?`, `due_at < ?`)
+		args = append(args, start.UTC(), start.AddDate(0, 0, 1).UTC())
+	default:
+		return nil, fmt.Errorf("unknown due filter %q", f.Due)
+	}
+
+	if f.DueWithin > 0 {
+		where = append(where, `due_at >= ?`, `due_at <= ?`)
+		args = append(args, now.UTC(), now.Add(f.DueWithin).UTC())
+	}
+
+	if f.ParentID > 0 {
+		// 親の TODO が存在しない場合は空の一覧ではなく ErrNotFound を返す
+		if _, err := getTODO(ctx, s.db, f.ParentID); err != nil {
+			return nil, err
+		}
+		where = append(where, `parent_id = ?`)
+		args = append(args, f.ParentID)
+	}
+
+	// アーカイブされたリストの TODO は、そのリストを指定した場合のみ返す
+	switch {
+	case f.ListID > 0:
+		if _, err := getList(ctx, s.db, f.ListID); err != nil {
+			return nil, err
+		}
+		where = append(where, `list_id = ?`)
+		args = append(args, f.ListID)
+	case !f.IncludeArchived:
+		where = append(where, unarchived)
+	}
+
+	if len(f.Tags) > 0 {
+		tags, err := normalizeTags("tag", f.Tags)
+		if err != nil {
+			return nil, err
+		}
+
+		const taggedFmt = `id IN (SELECT todo_id FROM todo_tags JOIN tags ON tags.id = todo_tags.tag_id WHERE tags.name IN (?%s)%s)`
+		placeholders := strings.Repeat(",?", len(tags)-1)
+		for _, tag := range tags {
+			args = append(args, tag)
+		}
+
+		switch f.TagMatch {
+		case TagMatchAny, "":
+			where = append(where, fmt.Sprintf(taggedFmt, placeholders, ``))
+		case TagMatchAll:
+			// すべてのタグを持つ TODO は、一致したタグの数がタグの数と等しい
+			where = append(where, fmt.Sprintf(taggedFmt, placeholders, ` GROUP BY todo_id HAVING COUNT(*) = ?`))
+			args = append(args, len(tags))
+		default:
+			return nil, fmt.Errorf("unknown tag match %q", f.TagMatch)
+		}
+	}
+
+	if f.Ready != nil {
+		if *f.Ready {
+			where = append(where, `completed = FALSE`, unblocked)
+		} else {
+			where = append(where, `completed = FALSE`, `NOT `+unblocked)
+		}
+	}
+
+	switch f.Status {
+	case StatusAll:
+	case StatusOpen:
+		where = append(where, `completed = FALSE`)
+	case StatusCompleted:
+		where = append(where, `completed = TRUE`)
+	default:
+		return nil, fmt.Errorf("unknown status %q", f.Status)
+	}
+
+	query := `SELECT ` + todoColumns + ` FROM todos WHERE ` + strings.Join(where, ` AND `)
+	query += ` ORDER BY ` + order + ` LIMIT ?`
+	args = append(args, f.Size)
+
+	rows, err := s.db.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	// TODO スライスを用意
+	todos := []*model.TODO{}
+
+	// rows からデータを取得
+	for rows.Next() {
+		todo, err := scanTODO(rows)
+		if err != nil {
+			return nil, err
+		}
+		todos = append(todos, todo)
+	}
+
+	// エラーが発生した場合
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// SearchTODO searches TODOs on DB with the FTS5 full-text query, which supports
+// phrases ("buy milk"), prefixes (mil*) and boolean operators (AND, OR, NOT).
+// Trashed TODOs and TODOs of archived Lists are not searched.
+// Hits are ordered from the best match; pass the id of the last hit as prevID
+// to read the next page.
+func (s *TODOService) SearchTODO(ctx context.Context, query string, prevID, size int64) (_ []*model.SearchTODOHit, err error) {
+	defer logFailure(ctx, "SearchTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
 	const (
-		update  = `UPDATE todos SET subject = ?, description = ?, updated_at = ? WHERE id = ?`
-		confirm = `SELECT id, subject, description, created_at, updated_at FROM todos WHERE id = ?`
+		search = `WITH hits AS (
+			SELECT rowid AS hit_id,
+			       -bm25(todos_fts, 10.0, 1.0) AS score,
+			       snippet(todos_fts, -1, '<mark>', '</mark>', '…', 16) AS snippet
+			FROM todos_fts WHERE todos_fts MATCH ?
+		)
+		SELECT ` + todoColumns + `, score, snippet FROM hits JOIN todos ON todos.id = hits.hit_id
+		WHERE deleted_at IS NULL AND ` + owned + ` AND ` + unarchived
+		after = ` AND (score, hit_id) < (SELECT score, hit_id FROM hits JOIN todos ON todos.id = hits.hit_id
+			WHERE hit_id = ? AND deleted_at IS NULL AND ` + owned + `)`
+		order = ` ORDER BY score DESC, hit_id DESC LIMIT ?`
 	)
 
-	// ID が無効な場合、ErrNotFound を返す
+	available, err := db.HasFTS5(s.db)
+	if err != nil {
+		return nil, err
+	}
+	if !available {
+		return nil, &model.ErrSearchUnavailable{}
+	}
+
+	q, args := search, []interface{}{query, ownerID(ctx)}
+	if prevID > 0 {
+		q += after
+		args = append(args, prevID, ownerID(ctx))
+	}
+	q += order
+	args = append(args, size)
+
+	rows, err := s.db.QueryContext(ctx, q, args...)
+	if err != nil {
+		return nil, searchError(err)
+	}
+	defer rows.Close()
+
+	hits := []*model.SearchTODOHit{}
+	for rows.Next() {
+		var hit model.SearchTODOHit
+		todo, err := scanTODO(rows, &hit.Score, &hit.Snippet)
+		if err != nil {
+			return nil, err
+		}
+		hit.TODO = *todo
+		hits = append(hits, &hit)
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, searchError(err)
+	}
+
+	return hits, nil
+}
+
+// searchError converts the SQLite error of an FTS5 query into a model.ErrValidation of q.
+// The SQL itself is fixed, so a generic SQL error can only be caused by the query text.
+func searchError(err error) error {
+	var serr sqlite3.Error
+	if errors.As(err, &serr) && serr.Code == sqlite3.ErrError {
+		verr := &model.ErrValidation{}
+		verr.Add("q", "is not a valid search query: "+serr.Error())
+		return verr
+	}
+	return err
+}
+
+// UpdateTODO replaces the subject and the description of the TODO on DB
+// with PatchTODO, returning model.ErrPreconditionFailed unless the TODO
+// matches one of the entity tags of ifMatch, if any. The handlers replace
+// TODOs with PatchTODO; UpdateTODO remains the original API of the service.
+func (s *TODOService) UpdateTODO(ctx context.Context, id int64, subject, description string, ifMatch ...string) (*model.TODO, error) {
+	// ID が無効な場合、Subject より先に ErrNotFound を返す
 	if id == 0 {
 		return nil, &model.ErrNotFound{}
 	}
+	return s.PatchTODO(ctx, id, &TODOPatch{Subject: &subject, Description: &description, IfMatch: ifMatch})
+}
 
-	// Subject が空の場合、SQLite の制約エラーを模倣する
-	if subject == "" {
-		return nil, sqlite3.Error{Code: sqlite3.ErrConstraint}
+// PatchTODO applies only the changed columns of the patch to the TODO on DB.
+func (s *TODOService) PatchTODO(ctx context.Context, id int64, p *TODOPatch) (*model.TODO, error) {
+	todo, _, err := s.PatchTODOUndoable(ctx, id, p)
+	return todo, err
+}
+
+// PatchTODOUndoable is like PatchTODO, but also returns the token that undoes
+// the change with UndoTODO, or "" if nothing has changed.
+func (s *TODOService) PatchTODOUndoable(ctx context.Context, id int64, p *TODOPatch) (_ *model.TODO, undoToken string, err error) {
+	defer logFailure(ctx, "PatchTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, "", err
+	}
+
+	var (
+		sets []string
+		args []interface{}
+	)
+
+	if p.Subject != nil {
+		// Subject が空の場合、SQLite の制約エラーを模倣する
+		if *p.Subject == "" {
+			return nil, "", sqlite3.Error{Code: sqlite3.ErrConstraint}
+		}
+		sets = append(sets, `subject = ?`)
+		args = append(args, *p.Subject)
+	}
+	if p.Description != nil {
+		sets = append(sets, `description = ?`)
+		args = append(args, *p.Description)
+	}
+	if p.Completed != nil {
+		// 完了済みのまま再度完了にした場合は completed_at を維持する
+		sets = append(sets, `completed = ?`, `completed_at = CASE WHEN ? THEN COALESCE(completed_at, ?) END`)
+		args = append(args, *p.Completed, *p.Completed, time.Now().UTC())
+	}
+	if p.DueAt != nil {
+		var due *time.Time
+		if p.DueAt.Valid {
+			due = &p.DueAt.Time
+		}
+		sets = append(sets, `due_at = ?`)
+		args = append(args, dueAt(due))
+	}
+
+	if p.Priority != nil {
+		priority, err := priorityLevel(*p.Priority)
+		if err != nil {
+			return nil, "", err
+		}
+		sets = append(sets, `priority = ?`)
+		args = append(args, priority)
+	}
+
+	if p.ParentID != nil {
+		sets = append(sets, `parent_id = ?`)
+		args = append(args, *p.ParentID)
+	}
+
+	if p.ListID != nil {
+		sets = append(sets, `list_id = ?`)
+		args = append(args, *p.ListID)
+	}
+
+	if p.RRule != nil {
+		rule, err := normalizeRRule("rrule", *p.RRule)
+		if err != nil {
+			return nil, "", err
+		}
+		sets = append(sets, `rrule = ?`)
+		args = append(args, rule)
+	}
+
+	var tags []string
+	if p.Tags != nil {
+		if tags, err = normalizeTags("tags", *p.Tags); err != nil {
+			return nil, "", err
+		}
+		// タグだけを変更した場合も更新日時を進める
+		sets = append(sets, `updated_at = DATETIME('now')`)
 	}
 
-	// トランザクションを開始
 	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 	defer tx.Rollback()
 
-	// 現在時刻
-	now := time.Now()
+	since, err := lastRevision(ctx, tx)
+	if err != nil {
+		return nil, "", err
+	}
 
-	// UPDATE クエリを実行
-	res, err := tx.ExecContext(ctx, update, subject, description, now, id)
+	if err := checkIfMatch(ctx, tx, id, p.IfMatch); err != nil {
+		return nil, "", err
+	}
+
+	old, err := snapshotTODO(ctx, tx, id)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	// 更新された行数を確認
-	rowsAffected, err := res.RowsAffected()
+	if p.ParentID != nil && p.ParentID.Valid {
+		if err := checkParent(ctx, tx, id, p.ParentID.Int64); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if p.ListID != nil && p.ListID.Valid {
+		if err := checkList(ctx, tx, p.ListID.Int64); err != nil {
+			return nil, "", err
+		}
+	}
+
+	// 変更がなければ更新せずに現在の値を返す
+	if len(sets) > 0 {
+		query := `UPDATE todos SET ` + strings.Join(sets, `, `) + ` WHERE id = ? AND deleted_at IS NULL`
+		res, err := tx.ExecContext(ctx, query, append(args, id)...)
+		if err != nil {
+			return nil, "", err
+		}
+
+		rowsAffected, err := res.RowsAffected()
+		if err != nil {
+			return nil, "", err
+		}
+		if rowsAffected == 0 {
+			return nil, "", &model.ErrNotFound{}
+		}
+	}
+
+	if p.Tags != nil {
+		if err := setTODOTags(ctx, tx, id, tags); err != nil {
+			return nil, "", err
+		}
+	}
+
+	if err := recordEvent(ctx, tx, ActionUpdate, id, old); err != nil {
+		return nil, "", err
+	}
+
+	// 繰り返しの TODO を完了したら次の回を作る
+	if p.Completed != nil && *p.Completed && !old.Completed {
+		if err := spawnNextOccurrence(ctx, tx, id); err != nil {
+			return nil, "", err
+		}
+	}
+
+	todo, err := getTODO(ctx, tx, id)
 	if err != nil {
-		return nil, err
+		return nil, "", err
 	}
-	if rowsAffected == 0 {
-		// 対象 ID のレコードが存在しない場合
-		return nil, &model.ErrNotFound{}
+
+	token, err := s.issueUndo(ctx, tx, since)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// 更新後のレコードを取得
-	var todo model.TODO
-	err = tx.QueryRowContext(ctx, confirm, id).Scan(
-		&todo.ID,
-		&todo.Subject,
-		&todo.Description,
-		&todo.CreatedAt,
-		&todo.UpdatedAt,
+	if err := tx.Commit(); err != nil {
+		return nil, "", err
+	}
+
+	return todo, token, nil
+}
+
+// MoveTODO moves the TODO on DB in the manual order to just before the TODO
+// before, or just after the TODO after when before is 0. Only the position
+// of the moved TODO changes. Each user has a manual order of its own.
+func (s *TODOService) MoveTODO(ctx context.Context, id, before, after int64) (*model.TODO, error) {
+	todo, _, err := s.MoveTODOUndoable(ctx, id, before, after)
+	return todo, err
+}
+
+// MoveTODOUndoable is like MoveTODO, but also returns the token that moves the
+// TODO back with UndoTODO.
+func (s *TODOService) MoveTODOUndoable(ctx context.Context, id, before, after int64) (_ *model.TODO, undoToken string, err error) {
+	defer logFailure(ctx, "MoveTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, "", err
+	}
+
+	const (
+		position = `SELECT position FROM todos WHERE id = ? AND deleted_at IS NULL AND ` + owned
+		previous = `SELECT IFNULL(MAX(position), '') FROM todos WHERE position < ? AND id <> ? AND ` + owned
+		next     = `SELECT IFNULL(MIN(position), '') FROM todos WHERE position > ? AND id <> ? AND ` + owned
+		update   = `UPDATE todos SET position = ? WHERE id = ?`
 	)
+
+	field, ref := "before", before
+	if before == 0 {
+		field, ref = "after", after
+	}
+	if ref == id {
+		verr := &model.ErrValidation{}
+		verr.Add(field, "must not be the moved TODO")
+		return nil, "", verr
+	}
+
+	tx, err := s.db.BeginTx(ctx, nil)
 	if err != nil {
+		return nil, "", err
+	}
+	defer tx.Rollback()
+
+	since, err := lastRevision(ctx, tx)
+	if err != nil {
+		return nil, "", err
+	}
+
+	old, err := snapshotTODO(ctx, tx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var refPosition string
+	if err := tx.QueryRowContext(ctx, position, ref, ownerID(ctx)).Scan(&refPosition); err != nil {
 		if err == sql.ErrNoRows {
-			// 対象 ID のレコードが存在しない場合
-			return nil, &model.ErrNotFound{}
+			verr := &model.ErrValidation{}
+			verr.Add(field, "must be the id of an existing TODO")
+			return nil, "", verr
 		}
-		return nil, err
+		return nil, "", err
+	}
+
+	// 移動先の前後の位置の間に新しい位置を割り当てる
+	// ゴミ箱の TODO も復元に備えて位置を持ち続けるので、隣の TODO として数える
+	var a, b string
+	if field == "before" {
+		b = refPosition
+		err = tx.QueryRowContext(ctx, previous, refPosition, id, ownerID(ctx)).Scan(&a)
+	} else {
+		a = refPosition
+		err = tx.QueryRowContext(ctx, next, refPosition, id, ownerID(ctx)).Scan(&b)
+	}
+	if err != nil {
+		return nil, "", err
+	}
+
+	p, err := positionBetween(a, b)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := tx.ExecContext(ctx, update, p, id); err != nil {
+		return nil, "", err
+	}
+
+	if err := recordEvent(ctx, tx, ActionMove, id, old); err != nil {
+		return nil, "", err
+	}
+
+	todo, err := getTODO(ctx, tx, id)
+	if err != nil {
+		return nil, "", err
+	}
+
+	token, err := s.issueUndo(ctx, tx, since)
+	if err != nil {
+		return nil, "", err
 	}
 
-	// トランザクションをコミット
 	if err := tx.Commit(); err != nil {
-		return nil, err
+		return nil, "", err
 	}
 
-	return &todo, nil
+	return todo, token, nil
+}
+
+// CompleteTODO marks the TODO on DB as completed.
+// Completing an already completed TODO keeps its original completed_at.
+func (s *TODOService) CompleteTODO(ctx context.Context, id int64) (*model.TODO, error) {
+	completed := true
+	return s.PatchTODO(ctx, id, &TODOPatch{Completed: &completed})
+}
+
+// ReopenTODO marks the TODO on DB as not completed.
+func (s *TODOService) ReopenTODO(ctx context.Context, id int64) (*model.TODO, error) {
+	completed := false
+	return s.PatchTODO(ctx, id, &TODOPatch{Completed: &completed})
 }
 
+// A ChildPolicy tells DeleteTODOWith what to do with the subtasks of the deleted TODOs.
+type ChildPolicy string
 
-// DeleteTODO deletes TODOs on DB by ids.
+const (
+	// ChildrenReject refuses to delete TODOs with subtasks that are not deleted too.
+	ChildrenReject ChildPolicy = "reject"
+	// ChildrenCascade deletes all the subtasks of the deleted TODOs too.
+	ChildrenCascade ChildPolicy = "cascade"
+	// ChildrenOrphan makes the subtasks of the deleted TODOs top-level.
+	ChildrenOrphan ChildPolicy = "orphan"
+)
+
+// A TODODeletion describes the TODOs DeleteTODOWith deletes.
+type TODODeletion struct {
+	IDs []int64
+	// Children defaults to ChildrenReject.
+	Children ChildPolicy
+
+	// IfMatch, if not empty, holds the entity tags one of which the TODO
+	// must match to be deleted. It is only honored when a single TODO is deleted.
+	IfMatch []string
+}
+
+// DeleteTODO moves TODOs on DB by ids to the trash.
+// TODOs with subtasks that are not deleted too are not deleted.
 func (s *TODOService) DeleteTODO(ctx context.Context, ids []int64) error {
-	const deleteFmt = `DELETE FROM todos WHERE id IN (?%s)`
+	_, err := s.DeleteTODOWith(ctx, &TODODeletion{IDs: ids})
+	return err
+}
 
-    if len(ids) == 0 {
-        return nil
-    }
+// DeleteTODOWith moves the TODOs on DB described by d to the trash, returning
+// model.ErrNotFound if none of them exists and model.ErrConflict if
+// ChildrenReject refuses to delete them. The TODOs deleted together share
+// their deleted_at, so that RestoreTODO brings back cascaded subtasks too.
+// The returned token undoes the deletion with UndoTODO.
+func (s *TODOService) DeleteTODOWith(ctx context.Context, d *TODODeletion) (_ string, err error) {
+	defer logFailure(ctx, "DeleteTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return "", err
+	}
 
-    // 削除用のプレースホルダ（"?"）を生成
-	// 例: idリストが3つなら → "?%s" の %s 部分が ",?,?" に変換される
-	query := fmt.Sprintf(deleteFmt, strings.Repeat(",?", len(ids)-1))
+	const (
+		ownedFmt       = `SELECT id FROM todos WHERE id IN (%s) AND ` + owned
+		trashFmt       = `UPDATE todos SET deleted_at = ? WHERE id IN (%s) AND deleted_at IS NULL`
+		childrenFmt    = `SELECT COUNT(*) FROM todos WHERE parent_id IN (%s) AND id NOT IN (%s) AND deleted_at IS NULL`
+		orphansFmt     = `SELECT id FROM todos WHERE parent_id IN (%s) AND id NOT IN (%s) AND deleted_at IS NULL`
+		orphanFmt      = `UPDATE todos SET parent_id = NULL WHERE id IN (%s)`
+		descendantsFmt = `WITH RECURSIVE tree(tree_id) AS (
+			SELECT id FROM todos WHERE id IN (%s) AND deleted_at IS NULL
+			UNION
+			SELECT todos.id FROM todos JOIN tree ON todos.parent_id = tree.tree_id WHERE todos.deleted_at IS NULL
+		) SELECT tree_id FROM tree`
+	)
 
+	if len(d.IDs) == 0 {
+		return "", nil
+	}
 
-    args := make([]interface{}, 0, len(ids))
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return "", err
+	}
+	defer tx.Rollback()
 
-    for _, id := range ids {
-        args = append(args, id)
-    }
+	since, err := lastRevision(ctx, tx)
+	if err != nil {
+		return "", err
+	}
 
-    res, err := s.db.ExecContext(ctx, query, args...)
+	if len(d.IDs) == 1 {
+		if err := checkIfMatch(ctx, tx, d.IDs[0], d.IfMatch); err != nil {
+			return "", err
+		}
+	}
+
+	// 他のユーザーの TODO は存在しないものとして扱う
+	query, args := inClause(ownedFmt, d.IDs)
+	ids, err := queryIDs(ctx, tx, query, append(args, ownerID(ctx))...)
 	if err != nil {
-		return err
+		return "", err
+	}
+	if len(ids) == 0 {
+		return "", &model.ErrNotFound{}
 	}
 
-    rowsAffected, err := res.RowsAffected()
+	switch d.Children {
+	case ChildrenReject, "":
+		query, args := inClause(childrenFmt, ids)
+		var n int
+		if err := tx.QueryRowContext(ctx, query, args...).Scan(&n); err != nil {
+			return "", err
+		}
+		if n > 0 {
+			return "", &model.ErrConflict{Reason: "TODO has subtasks; delete them too with children=cascade or keep them with children=orphan"}
+		}
+	case ChildrenCascade:
+		// 子孫の TODO もまとめて削除する
+		query, args := inClause(descendantsFmt, ids)
+		if ids, err = queryIDs(ctx, tx, query, args...); err != nil {
+			return "", err
+		}
+		if len(ids) == 0 {
+			return "", &model.ErrNotFound{}
+		}
+	case ChildrenOrphan:
+		query, args := inClause(orphansFmt, ids)
+		orphans, err := queryIDs(ctx, tx, query, args...)
+		if err != nil {
+			return "", err
+		}
+		if len(orphans) > 0 {
+			olds, err := snapshotTODOs(ctx, tx, orphans)
+			if err != nil {
+				return "", err
+			}
+			query, args = inClause(orphanFmt, orphans)
+			if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+				return "", err
+			}
+			if err := recordEvents(ctx, tx, ActionUpdate, olds); err != nil {
+				return "", err
+			}
+		}
+	default:
+		return "", fmt.Errorf("unknown child policy %q", d.Children)
+	}
+
+	// 既にゴミ箱にある TODO は変わらないので履歴に残らない
+	olds, err := snapshotTODOs(ctx, tx, ids)
+	if err != nil {
+		return "", err
+	}
+
+	query, args = inClause(trashFmt, ids)
+	res, err := tx.ExecContext(ctx, query, append([]interface{}{time.Now().UTC()}, args...)...)
+	if err != nil {
+		return "", err
+	}
+
+	rowsAffected, err := res.RowsAffected()
+	if err != nil {
+		return "", err
+	}
+	if rowsAffected == 0 {
+		return "", &model.ErrNotFound{}
+	}
+
+	if err := recordEvents(ctx, tx, ActionDelete, olds); err != nil {
+		return "", err
+	}
+
+	token, err := s.issueUndo(ctx, tx, since)
+	if err != nil {
+		return "", err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return "", err
+	}
+
+	return token, nil
+}
+
+// GetTODOTree reads the TODO on DB by id with all its subtasks, each level
+// in the manual order.
+func (s *TODOService) GetTODOTree(ctx context.Context, id int64) (_ *model.TODONode, err error) {
+	defer logFailure(ctx, "GetTODOTree", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const read = `WITH RECURSIVE tree(tree_id) AS (
+		SELECT id FROM todos WHERE id = ? AND ` + owned + `
+		UNION
+		SELECT todos.id FROM todos JOIN tree ON todos.parent_id = tree.tree_id WHERE todos.deleted_at IS NULL
+	) SELECT ` + todoColumns + ` FROM tree JOIN todos ON todos.id = tree.tree_id WHERE deleted_at IS NULL ORDER BY position`
+
+	rows, err := s.db.QueryContext(ctx, read, id, ownerID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var (
+		root     *model.TODO
+		children = map[int64][]*model.TODO{}
+	)
+	for rows.Next() {
+		todo, err := scanTODO(rows)
+		if err != nil {
+			return nil, err
+		}
+		if todo.ID == id {
+			root = todo
+		} else if todo.ParentID != nil {
+			children[*todo.ParentID] = append(children[*todo.ParentID], todo)
+		}
+	}
+
+	if err := rows.Err(); err != nil {
+		return nil, err
+	}
+	if root == nil {
+		return nil, &model.ErrNotFound{}
+	}
+
+	// 親子関係を循環がないものとして木を組み立てる
+	var build func(todo *model.TODO) model.TODONode
+	build = func(todo *model.TODO) model.TODONode {
+		node := model.TODONode{TODO: *todo, Children: []model.TODONode{}}
+		for _, child := range children[todo.ID] {
+			node.Children = append(node.Children, build(child))
+		}
+		return node
+	}
+	tree := build(root)
+	return &tree, nil
+}
+
+// checkIfMatch returns model.ErrPreconditionFailed unless the TODO matches one of
+// the entity tags using the strong comparison. "*" matches any existing TODO and
+// no entity tags means no precondition.
+func checkIfMatch(ctx context.Context, q queryer, id int64, etags []string) error {
+	if len(etags) == 0 {
+		return nil
+	}
+
+	todo, err := getTODO(ctx, q, id)
 	if err != nil {
 		return err
 	}
 
-    if rowsAffected == 0 {
-		return &model.ErrNotFound{}
+	current := todo.ETag()
+	for _, etag := range etags {
+		if etag == "*" || etag == current {
+			return nil
+		}
+	}
+	return &model.ErrPreconditionFailed{}
+}
+
+// checkParent returns a model.ErrValidation of parent_id unless the TODO
+// parentID exists and is neither the TODO id nor one of its subtasks, so that
+// making it the parent does not create a cycle. id is 0 for a new TODO.
+func checkParent(ctx context.Context, q queryer, id, parentID int64) error {
+	const ancestors = `WITH RECURSIVE ancestors(ancestor_id) AS (
+		SELECT id FROM todos WHERE id = ? AND deleted_at IS NULL AND ` + owned + `
+		UNION
+		SELECT parent_id FROM todos JOIN ancestors ON todos.id = ancestors.ancestor_id WHERE parent_id IS NOT NULL
+	) SELECT COUNT(*), TOTAL(ancestor_id = ?) FROM ancestors`
+
+	var (
+		n     int
+		cycle float64
+	)
+	if err := q.QueryRowContext(ctx, ancestors, parentID, ownerID(ctx), id).Scan(&n, &cycle); err != nil {
+		return err
+	}
+
+	verr := &model.ErrValidation{}
+	switch {
+	case n == 0:
+		verr.Add("parent_id", "must be the id of an existing TODO")
+	case cycle > 0:
+		verr.Add("parent_id", "must not be the TODO itself or one of its subtasks")
+	default:
+		return nil
+	}
+	return verr
+}
+
+// logFailure logs the error of the operation with the request ID of ctx,
+// unless it is an expected outcome that is reported to the caller.
+func logFailure(ctx context.Context, op string, errp *error) {
+	err := *errp
+	if err == nil {
+		return
+	}
+
+	var serr sqlite3.Error
+	switch {
+	case errors.Is(err, &model.ErrNotFound{}),
+		errors.Is(err, &model.ErrPreconditionFailed{}),
+		errors.Is(err, &model.ErrValidation{}),
+		errors.Is(err, &model.ErrConflict{}),
+		errors.Is(err, &model.ErrUnauthorized{}),
+		errors.Is(err, &model.ErrForbidden{}),
+		errors.Is(err, &model.ErrSearchUnavailable{}),
+		errors.As(err, &serr) && serr.Code == sqlite3.ErrConstraint:
+		return
 	}
 
+	log.Printf("request_id=%s %s failed: %v\n", requestid.FromContext(ctx), op, err)
+}
+
+// noDueAt sorts after every stored due_at, so that TODOs without a due date come last.
+const noDueAt = `9999-12-31`
+
+// dueAt returns the value stored in the due_at column for due.
+// Due dates are stored in UTC with a precision of seconds, so that they are
+// compared in SQL as text in chronological order whatever time.Local is.
+func dueAt(due *time.Time) interface{} {
+	if due == nil {
+		return nil
+	}
+	return due.UTC().Truncate(time.Second)
+}
+
+// firstPosition returns a position before all the TODOs of the user of ctx,
+// as new TODOs are placed first in the manual order. Trashed TODOs count, as
+// they keep their positions to be restored to.
+func firstPosition(ctx context.Context, q queryer) (string, error) {
+	const first = `SELECT IFNULL(MIN(position), '') FROM todos WHERE ` + owned
+
+	var next string
+	if err := q.QueryRowContext(ctx, first, ownerID(ctx)).Scan(&next); err != nil {
+		return "", err
+	}
+	return positionBetween("", next)
+}
+
+// inClause replaces each %s in format with the placeholders of ids and
+// returns the query with ids repeated as the arguments of each of them.
+// 例: ids が 3 つなら %s は "?,?,?" に変換される
+func inClause(format string, ids []int64) (string, []interface{}) {
+	placeholders := "?" + strings.Repeat(",?", len(ids)-1)
+	n := strings.Count(format, "%s")
+
+	args := make([]interface{}, 0, n*len(ids))
+	for i := 0; i < n; i++ {
+		for _, id := range ids {
+			args = append(args, id)
+		}
+	}
+	return strings.ReplaceAll(format, "%s", placeholders), args
+}
+
+// queryIDs returns the ids selected by the query.
+func queryIDs(ctx context.Context, q queryer, query string, args ...interface{}) ([]int64, error) {
+	rows, err := q.QueryContext(ctx, query, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var ids []int64
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+	return ids, rows.Err()
+}
+
+// A queryer is implemented by both *sql.DB and *sql.Tx.
+type queryer interface {
+	QueryRowContext(ctx context.Context, query string, args ...interface{}) *sql.Row
+	QueryContext(ctx context.Context, query string, args ...interface{}) (*sql.Rows, error)
+	ExecContext(ctx context.Context, query string, args ...interface{}) (sql.Result, error)
+}
+
+// getTODO reads the TODO by id, returning model.ErrNotFound if there is none,
+// it is in the trash or it belongs to another user than the one of ctx.
+func getTODO(ctx context.Context, q queryer, id int64) (*model.TODO, error) {
+	const read = `SELECT ` + todoColumns + ` FROM todos WHERE id = ? AND deleted_at IS NULL AND ` + owned
+
+	todo, err := scanTODO(q.QueryRowContext(ctx, read, id, ownerID(ctx)))
+	if err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+	return todo, nil
+}
+
+// A rowScanner is implemented by both *sql.Row and *sql.Rows.
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+// scanTODO scans a row selected with todoColumns into a TODO.
+// Columns selected after todoColumns are scanned into extra.
+func scanTODO(row rowScanner, extra ...interface{}) (*model.TODO, error) {
+	var (
+		todo     model.TODO
+		tags     sql.NullString
+		priority int
+		progress string
+		blockers sql.NullString
+		rrule    sql.NullString
+	)
+	dest := append([]interface{}{
+		&todo.ID,
+		&todo.Subject,
+		&todo.Description,
+		&todo.Completed,
+		&todo.CompletedAt,
+		&todo.DueAt,
+		&tags,
+		&priority,
+		&todo.ParentID,
+		&todo.ListID,
+		&rrule,
+		&progress,
+		&blockers,
+		&todo.DeletedAt,
+		&todo.CreatedAt,
+		&todo.UpdatedAt,
+	}, extra...)
+	if err := row.Scan(dest...); err != nil {
+		return nil, err
+	}
+	todo.Tags = splitTags(tags)
+	todo.BlockedBy = splitIDs(blockers)
+	todo.RRule = rrule.String
+	if priority >= 0 && priority < len(priorities) {
+		todo.Priority = priorities[priority]
+	}
+	// progress は「全体数 完了数」の形で集計される
+	var p model.Progress
+	if _, err := fmt.Sscan(progress, &p.Total, &p.Completed); err == nil && p.Total > 0 {
+		todo.Progress = &p
+	}
+	return &todo, nil
+}
+
+// priorityLevel returns the value of the priority column for p.
+func priorityLevel(p model.Priority) (int, error) {
+	for level, priority := range priorities {
+		if p == priority {
+			return level, nil
+		}
+	}
 
-	return nil
+	verr := &model.ErrValidation{}
+	verr.Add("priority", "must be one of low, medium or high")
+	return 0, verr
 }
@@ -0,0 +1,236 @@
+package service
+
+import (
+	"context"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// ReadTrash reads the trashed TODOs on DB from the most recently deleted.
+// Pass the id of the last TODO of the previous page as prevID to read the next page.
+func (s *TODOService) ReadTrash(ctx context.Context, prevID, size int64) (_ []*model.TODO, err error) {
+	defer logFailure(ctx, "ReadTrash", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const (
+		read  = `SELECT ` + todoColumns + ` FROM todos WHERE deleted_at IS NOT NULL AND ` + owned
+		after = ` AND (deleted_at, id) < (SELECT deleted_at, id FROM todos WHERE id = ? AND deleted_at IS NOT NULL AND ` + owned + `)`
+		order = ` ORDER BY deleted_at DESC, id DESC LIMIT ?`
+	)
+
+	query, args := read, []interface{}{ownerID(ctx)}
+	if prevID > 0 {
+		query += after
+		args = append(args, prevID, ownerID(ctx))
+	}
+	query += order
+	args = append(args, size)
+
+	return queryTODOs(ctx, s.db, query, args...)
+}
+
+// RestoreTODO takes the TODO on DB out of the trash, together with the
+// subtasks deleted along with it, returning model.ErrNotFound if it is not in
+// the trash. The TODO becomes top-level if its parent is still in the trash.
+func (s *TODOService) RestoreTODO(ctx context.Context, id int64) (_ *model.TODO, err error) {
+	defer logFailure(ctx, "RestoreTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const (
+		// 一緒に削除された (deleted_at が同じ) 子孫だけを復元する
+		subtree = `WITH RECURSIVE tree(tree_id, deleted_at) AS (
+			SELECT id, deleted_at FROM todos WHERE id = ? AND deleted_at IS NOT NULL AND ` + owned + `
+			UNION
+			SELECT todos.id, todos.deleted_at FROM todos JOIN tree ON todos.parent_id = tree.tree_id
+			WHERE todos.deleted_at = tree.deleted_at
+		) SELECT tree_id FROM tree`
+		restoreFmt = `UPDATE todos SET deleted_at = NULL WHERE id IN (%s)`
+		detach     = `UPDATE todos SET parent_id = NULL
+			WHERE id = ? AND parent_id IN (SELECT id FROM todos WHERE deleted_at IS NOT NULL)`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIDs(ctx, tx, subtree, id, ownerID(ctx))
+	if err != nil {
+		return nil, err
+	}
+	if len(ids) == 0 {
+		return nil, &model.ErrNotFound{}
+	}
+
+	olds, err := snapshotTODOs(ctx, tx, ids)
+	if err != nil {
+		return nil, err
+	}
+
+	query, args := inClause(restoreFmt, ids)
+	if _, err := tx.ExecContext(ctx, query, args...); err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, detach, id); err != nil {
+		return nil, err
+	}
+
+	if err := recordEvents(ctx, tx, ActionRestore, olds); err != nil {
+		return nil, err
+	}
+
+	todo, err := getTODO(ctx, tx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return todo, nil
+}
+
+// PurgeTODO permanently deletes the TODO on DB in the trash with all its
+// trashed subtasks, returning model.ErrNotFound if it is not in the trash.
+func (s *TODOService) PurgeTODO(ctx context.Context, id int64) (err error) {
+	defer logFailure(ctx, "PurgeTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return err
+	}
+
+	const trashed = `SELECT id FROM todos WHERE id = ? AND deleted_at IS NOT NULL AND ` + owned
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIDs(ctx, tx, trashed, id, ownerID(ctx))
+	if err != nil {
+		return err
+	}
+	if len(ids) == 0 {
+		return &model.ErrNotFound{}
+	}
+
+	if _, err := purgeTODOs(ctx, tx, ids); err != nil {
+		return err
+	}
+
+	return tx.Commit()
+}
+
+// EmptyTrash permanently deletes all the trashed TODOs on DB and returns how
+// many TODOs were deleted.
+func (s *TODOService) EmptyTrash(ctx context.Context) (_ int64, err error) {
+	defer logFailure(ctx, "EmptyTrash", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return 0, err
+	}
+
+	const trashed = `SELECT id FROM todos WHERE deleted_at IS NOT NULL AND ` + owned
+
+	return s.purgeTrashed(ctx, trashed, ownerID(ctx))
+}
+
+// PurgeTrash permanently deletes the TODOs on DB that were moved to the trash
+// before the time, or all of them if it is zero, and returns how many TODOs
+// were deleted. Unlike the other methods, it purges the trash of every user
+// for the retention of the trash.
+func (s *TODOService) PurgeTrash(ctx context.Context, before time.Time) (_ int64, err error) {
+	defer logFailure(ctx, "PurgeTrash", &err)
+
+	const (
+		trashed = `SELECT id FROM todos WHERE deleted_at IS NOT NULL`
+		older   = ` AND deleted_at < ?`
+	)
+
+	query, args := trashed, []interface{}{}
+	if !before.IsZero() {
+		query += older
+		args = append(args, before.UTC())
+	}
+
+	return s.purgeTrashed(ctx, query, args...)
+}
+
+// purgeTrashed permanently deletes the trashed TODOs selected by the query
+// with all their trashed subtasks and returns how many TODOs were deleted.
+func (s *TODOService) purgeTrashed(ctx context.Context, query string, args ...interface{}) (int64, error) {
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return 0, err
+	}
+	defer tx.Rollback()
+
+	ids, err := queryIDs(ctx, tx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+	if len(ids) == 0 {
+		return 0, nil
+	}
+
+	n, err := purgeTODOs(ctx, tx, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return 0, err
+	}
+
+	return n, nil
+}
+
+// purgeTODOs permanently deletes the TODOs ids with all their trashed subtasks
+// and returns how many TODOs were deleted. Their tags and dependencies are
+// removed by ON DELETE CASCADE.
+func purgeTODOs(ctx context.Context, q queryer, ids []int64) (int64, error) {
+	const (
+		descendantsFmt = `WITH RECURSIVE tree(tree_id) AS (
+			SELECT id FROM todos WHERE id IN (%s)
+			UNION
+			SELECT todos.id FROM todos JOIN tree ON todos.parent_id = tree.tree_id WHERE todos.deleted_at IS NOT NULL
+		) SELECT tree_id FROM tree`
+		detachFmt = `UPDATE todos SET parent_id = NULL WHERE parent_id IN (%s) AND id NOT IN (%s)`
+		deleteFmt = `DELETE FROM todos WHERE id IN (%s)`
+	)
+
+	query, args := inClause(descendantsFmt, ids)
+	ids, err := queryIDs(ctx, q, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	olds, err := snapshotTODOs(ctx, q, ids)
+	if err != nil {
+		return 0, err
+	}
+
+	// ゴミ箱にない TODO から参照されないよう、念のため親子関係を外す
+	query, args = inClause(detachFmt, ids)
+	if _, err := q.ExecContext(ctx, query, args...); err != nil {
+		return 0, err
+	}
+
+	query, args = inClause(deleteFmt, ids)
+	res, err := q.ExecContext(ctx, query, args...)
+	if err != nil {
+		return 0, err
+	}
+
+	// 削除後も履歴は残る
+	if err := recordEvents(ctx, q, ActionPurge, olds); err != nil {
+		return 0, err
+	}
+	return res.RowsAffected()
+}
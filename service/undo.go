@@ -0,0 +1,253 @@
+package service
+
+import (
+	"context"
+	"crypto/rand"
+	"database/sql"
+	"encoding/hex"
+	"fmt"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// DefaultUndoWindow is how long an undo token is valid unless set otherwise
+// with SetUndoWindow.
+const DefaultUndoWindow = 10 * time.Minute
+
+// sqliteDateTime is the format of DATETIME('now'), in which timestamps are
+// restored so that they compare with the others as strings.
+const sqliteDateTime = "2006-01-02 15:04:05"
+
+// SetUndoWindow sets how long the undo tokens issued from now on are valid.
+// A window of 0 or less stops issuing them.
+func (s *TODOService) SetUndoWindow(d time.Duration) {
+	s.undoWindow = d
+}
+
+// UndoTODO reverses the delete, update or move that issued the undo token,
+// restoring the TODOs as they were before it with their original ids,
+// timestamps and places in the manual order, and returns them. It returns
+// model.ErrNotFound if the token is unknown, already used, expired or issued
+// to another user, and model.ErrConflict if any of the TODOs has changed
+// since, or has been purged from the trash, in which case nothing is undone.
+func (s *TODOService) UndoTODO(ctx context.Context, token string) (_ []*model.TODO, err error) {
+	defer logFailure(ctx, "UndoTODO", &err)
+	if err := checkUser(ctx, s.requireUser); err != nil {
+		return nil, err
+	}
+
+	const (
+		read = `SELECT first_revision, last_revision FROM todo_undos WHERE token = ? AND expires_at > ? AND ` + owned
+		// 新しい変更から順に取り消す
+		events = `SELECT id, todo_id, action, old_value, new_value, actor, created_at FROM todo_events
+			WHERE id BETWEEN ? AND ? ORDER BY id DESC`
+		deleteByToken = `DELETE FROM todo_undos WHERE token = ?`
+		readFmt       = `SELECT ` + todoColumns + ` FROM todos WHERE id IN (%s) AND deleted_at IS NULL ORDER BY position`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	var first, last int64
+	if err := tx.QueryRowContext(ctx, read, token, time.Now().UTC(), ownerID(ctx)).Scan(&first, &last); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+
+	undone, err := queryEvents(ctx, tx, events, first, last)
+	if err != nil {
+		return nil, err
+	}
+
+	var ids []int64
+	for _, event := range undone {
+		current, err := snapshotTODOs(ctx, tx, []int64{event.TODOID})
+		if err != nil {
+			return nil, err
+		}
+		// 完全に削除された TODO は元の id で作り直せない
+		if len(current) == 0 {
+			return nil, &model.ErrConflict{Reason: fmt.Sprintf("TODO %d has been permanently deleted since; it can no longer be undone", event.TODOID)}
+		}
+		if !unchanged(current[0], event.New) {
+			return nil, &model.ErrConflict{Reason: fmt.Sprintf("TODO %d has changed since; it can no longer be undone", event.TODOID)}
+		}
+
+		// 操作で作られた TODO (完了で作られた次の回など) は削除する
+		if event.Old == nil {
+			if err := unspawnTODO(ctx, tx, event.TODOID); err != nil {
+				return nil, err
+			}
+		} else if err := restoreTODO(ctx, tx, event.Old); err != nil {
+			return nil, err
+		}
+		if err := recordEvents(ctx, tx, ActionUndo, current); err != nil {
+			return nil, err
+		}
+		ids = append(ids, event.TODOID)
+	}
+
+	if _, err := tx.ExecContext(ctx, deleteByToken, token); err != nil {
+		return nil, err
+	}
+
+	todos := []*model.TODO{}
+	if len(ids) > 0 {
+		query, args := inClause(readFmt, ids)
+		if todos, err = queryTODOs(ctx, tx, query, args...); err != nil {
+			return nil, err
+		}
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return todos, nil
+}
+
+// lastRevision returns the revision of the latest event of all the TODOs, or
+// 0 if there is none.
+func lastRevision(ctx context.Context, q queryer) (int64, error) {
+	const read = `SELECT IFNULL(MAX(id), 0) FROM todo_events`
+
+	var revision int64
+	err := q.QueryRowContext(ctx, read).Scan(&revision)
+	return revision, err
+}
+
+// issueUndo issues the token that undoes the events recorded after the
+// revision since, or returns "" if there are none or undo is disabled.
+// Expired tokens are removed at the same time.
+func (s *TODOService) issueUndo(ctx context.Context, q queryer, since int64) (string, error) {
+	const (
+		deleteExpired = `DELETE FROM todo_undos WHERE expires_at <= ?`
+		insert        = `INSERT INTO todo_undos(token, first_revision, last_revision, expires_at, owner_id) VALUES(?, ?, ?, ?, ?)`
+	)
+
+	if s.undoWindow <= 0 {
+		return "", nil
+	}
+
+	last, err := lastRevision(ctx, q)
+	if err != nil {
+		return "", err
+	}
+	if last == since {
+		return "", nil
+	}
+
+	now := time.Now().UTC()
+	if _, err := q.ExecContext(ctx, deleteExpired, now); err != nil {
+		return "", err
+	}
+
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(b[:])
+
+	if _, err := q.ExecContext(ctx, insert, token, since+1, last, now.Add(s.undoWindow), ownerID(ctx)); err != nil {
+		return "", err
+	}
+	return token, nil
+}
+
+// unspawnTODO permanently deletes the TODO id created by an undone change,
+// returning model.ErrConflict if it has got subtasks since.
+func unspawnTODO(ctx context.Context, q queryer, id int64) error {
+	const (
+		children   = `SELECT COUNT(*) FROM todos WHERE parent_id = ?`
+		deleteByID = `DELETE FROM todos WHERE id = ?`
+	)
+
+	var n int
+	if err := q.QueryRowContext(ctx, children, id).Scan(&n); err != nil {
+		return err
+	}
+	if n > 0 {
+		return &model.ErrConflict{Reason: fmt.Sprintf("TODO %d has subtasks; it can no longer be undone", id)}
+	}
+
+	_, err := q.ExecContext(ctx, deleteByID, id)
+	return err
+}
+
+// restoreTODO writes the snapshot of a TODO taken before a change back to
+// DB, including its place in the manual order, whether it is trashed and when
+// it was last updated.
+func restoreTODO(ctx context.Context, q queryer, todo *model.TODO) error {
+	const update = `UPDATE todos SET subject = ?, description = ?, completed = ?, completed_at = ?, due_at = ?,
+		priority = ?, parent_id = ?, list_id = ?, rrule = ?, deleted_at = ?, updated_at = ?, position = ? WHERE id = ?`
+
+	priority, err := priorityLevel(todo.Priority)
+	if err != nil {
+		return err
+	}
+
+	position, err := restorePosition(ctx, q, todo)
+	if err != nil {
+		return err
+	}
+
+	var completedAt, deletedAt, parentID, listID interface{}
+	if todo.CompletedAt != nil {
+		completedAt = todo.CompletedAt.UTC()
+	}
+	if todo.DeletedAt != nil {
+		deletedAt = todo.DeletedAt.UTC()
+	}
+	if todo.ParentID != nil {
+		parentID = *todo.ParentID
+	}
+	if todo.ListID != nil {
+		listID = *todo.ListID
+	}
+
+	_, err = q.ExecContext(ctx, update, todo.Subject, todo.Description, todo.Completed, completedAt, dueAt(todo.DueAt),
+		priority, parentID, listID, nullableString(todo.RRule), deletedAt, todo.UpdatedAt.UTC().Format(sqliteDateTime), position, todo.ID)
+	if err != nil {
+		return err
+	}
+
+	return setTODOTags(ctx, q, todo.ID, todo.Tags)
+}
+
+// restorePosition returns the position the TODO is restored to: the one of
+// the snapshot, or right after it if another TODO of the owner has taken it
+// since. A snapshot recorded before positions were kept in the history keeps
+// the current position.
+func restorePosition(ctx context.Context, q queryer, todo *model.TODO) (string, error) {
+	const (
+		current = `SELECT position FROM todos WHERE id = ?`
+		taken   = `SELECT COUNT(*) FROM todos WHERE position = ? AND id <> ? AND owner_id IS (SELECT owner_id FROM todos WHERE id = ?)`
+		next    = `SELECT IFNULL(MIN(position), '') FROM todos WHERE position > ? AND id <> ? AND owner_id IS (SELECT owner_id FROM todos WHERE id = ?)`
+	)
+
+	var position string
+	if todo.Position == "" {
+		err := q.QueryRowContext(ctx, current, todo.ID).Scan(&position)
+		return position, err
+	}
+
+	var n int
+	if err := q.QueryRowContext(ctx, taken, todo.Position, todo.ID, todo.ID).Scan(&n); err != nil {
+		return "", err
+	}
+	if n == 0 {
+		return todo.Position, nil
+	}
+
+	// 元の位置を使っている TODO のすぐ後ろに戻す
+	if err := q.QueryRowContext(ctx, next, todo.Position, todo.ID, todo.ID).Scan(&position); err != nil {
+		return "", err
+	}
+	return positionBetween(todo.Position, position)
+}
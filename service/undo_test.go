@@ -0,0 +1,75 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/TechBowl-japan/go-stations/db"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+// TestUndo checks that undo puts a moved TODO back in its place, and explains
+// why a purged TODO cannot be brought back.
+func TestUndo(t *testing.T) {
+	todoDB, err := db.NewDB(filepath.Join(t.TempDir(), "undo_test.db"))
+	if err != nil {
+		t.Fatal("failed to open db, err =", err)
+	}
+	defer todoDB.Close()
+
+	ctx := context.Background()
+	svc := NewTODOService(todoDB)
+
+	var ids []int64
+	for _, subject := range []string{"first", "second", "third"} {
+		todo, err := svc.InsertTODO(ctx, &TODOInput{Subject: subject})
+		if err != nil {
+			t.Fatal("failed to create TODO, err =", err)
+		}
+		ids = append(ids, todo.ID)
+	}
+	position := func(id int64) string {
+		var p string
+		if err := todoDB.QueryRowContext(ctx, `SELECT position FROM todos WHERE id = ?`, id).Scan(&p); err != nil {
+			t.Fatal("failed to read position, err =", err)
+		}
+		return p
+	}
+
+	t.Run("move", func(t *testing.T) {
+		before := position(ids[2])
+		_, token, err := svc.MoveTODOUndoable(ctx, ids[2], ids[0], 0)
+		if err != nil {
+			t.Fatal("failed to move TODO, err =", err)
+		}
+		if position(ids[2]) == before {
+			t.Fatal("position has not changed by the move")
+		}
+		if _, err := svc.UndoTODO(ctx, token); err != nil {
+			t.Fatal("failed to undo the move, err =", err)
+		}
+		if got := position(ids[2]); got != before {
+			t.Errorf("position after undo = %q, want %q", got, before)
+		}
+	})
+
+	t.Run("purged", func(t *testing.T) {
+		token, err := svc.DeleteTODOWith(ctx, &TODODeletion{IDs: []int64{ids[1]}})
+		if err != nil {
+			t.Fatal("failed to delete TODO, err =", err)
+		}
+		if err := svc.PurgeTODO(ctx, ids[1]); err != nil {
+			t.Fatal("failed to purge TODO, err =", err)
+		}
+
+		_, err = svc.UndoTODO(ctx, token)
+		var cerr *model.ErrConflict
+		if !errors.As(err, &cerr) || !strings.Contains(cerr.Reason, "permanently deleted") {
+			t.Errorf("undo after purge, err = %v, want a conflict about the purge", err)
+		}
+	})
+
+}
@@ -0,0 +1,210 @@
+package service
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"unicode"
+
+	"github.com/TechBowl-japan/go-stations/auth"
+	"github.com/TechBowl-japan/go-stations/model"
+)
+
+const (
+	// maxUserNameLength is the maximum number of characters in a user name.
+	maxUserNameLength = 100
+	// identityUserPrefix starts the names of the users created by
+	// EnsureIdentity. It contains a colon, which the user names of Basic
+	// authentication cannot (RFC 7617), so that EnsureUser never resolves a
+	// user of Basic authentication to them.
+	identityUserPrefix = "oidc:"
+)
+
+// owned matches the TODOs of the owner given by ownerID as its argument.
+// IS compares NULL with NULL as equal, so the same condition matches the TODOs
+// of no user, which only the services that do not require a user serve, see
+// checkUser.
+const owned = `owner_id IS ?`
+
+// A UserService implements the accounts TODOs belong to.
+type UserService struct {
+	db *sql.DB
+}
+
+// NewUserService returns new UserService.
+func NewUserService(db *sql.DB) *UserService {
+	return &UserService{
+		db: db,
+	}
+}
+
+// GetUser reads the User on DB by id.
+func (s *UserService) GetUser(ctx context.Context, id int64) (_ *model.User, err error) {
+	defer logFailure(ctx, "GetUser", &err)
+
+	const read = `SELECT id, name, created_at, updated_at FROM users WHERE id = ?`
+
+	return scanUser(s.db.QueryRowContext(ctx, read, id))
+}
+
+// EnsureUser reads the User on DB by name, ignoring case, and creates it if
+// there is none, so that users known to an external authority get an
+// account on their first request.
+func (s *UserService) EnsureUser(ctx context.Context, name string) (_ *model.User, err error) {
+	defer logFailure(ctx, "EnsureUser", &err)
+
+	const (
+		insert = `INSERT OR IGNORE INTO users(name) VALUES(?)`
+		read   = `SELECT id, name, created_at, updated_at FROM users WHERE name = ?`
+	)
+
+	name, err = normalizeName("name", name, maxUserNameLength)
+	if err != nil {
+		return nil, err
+	}
+
+	// 既にいれば INSERT しない (INSERT OR IGNORE でも AUTOINCREMENT の値が進むため)
+	u, err := scanUser(s.db.QueryRowContext(ctx, read, name))
+	if !errors.Is(err, &model.ErrNotFound{}) {
+		return u, err
+	}
+
+	if _, err := s.db.ExecContext(ctx, insert, name); err != nil {
+		return nil, err
+	}
+
+	return scanUser(s.db.QueryRowContext(ctx, read, name))
+}
+
+// EnsureIdentity reads the User linked to the subject of the OpenID Connect
+// issuer, and creates it if there is none. A new User is named "oidc:" and
+// name, with a number appended if the name is taken: an identity is never
+// linked to an existing User by name, which its provider may let anyone choose.
+func (s *UserService) EnsureIdentity(ctx context.Context, issuer, subject, name string) (_ *model.User, err error) {
+	defer logFailure(ctx, "EnsureIdentity", &err)
+
+	const (
+		read = `SELECT users.id, users.name, users.created_at, users.updated_at
+			FROM user_identities JOIN users ON users.id = user_identities.user_id
+			WHERE user_identities.issuer = ? AND user_identities.subject = ?`
+		exists = `SELECT EXISTS(SELECT 1 FROM users WHERE name = ?)`
+		insert = `INSERT INTO users(name) VALUES(?)`
+		link   = `INSERT INTO user_identities(user_id, issuer, subject) VALUES(?, ?, ?)`
+	)
+
+	tx, err := s.db.BeginTx(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	defer tx.Rollback()
+
+	u, err := scanUser(tx.QueryRowContext(ctx, read, issuer, subject))
+	if !errors.Is(err, &model.ErrNotFound{}) {
+		return u, err
+	}
+
+	base := identityUserName(name)
+	candidate := base
+	for n := 2; ; n++ {
+		var taken bool
+		if err := tx.QueryRowContext(ctx, exists, candidate).Scan(&taken); err != nil {
+			return nil, err
+		}
+		if !taken {
+			break
+		}
+		candidate = fmt.Sprintf("%s-%d", base, n)
+	}
+
+	res, err := tx.ExecContext(ctx, insert, candidate)
+	if err != nil {
+		return nil, err
+	}
+	id, err := res.LastInsertId()
+	if err != nil {
+		return nil, err
+	}
+	if _, err := tx.ExecContext(ctx, link, id, issuer, subject); err != nil {
+		return nil, err
+	}
+
+	u, err = scanUser(tx.QueryRowContext(ctx, read, issuer, subject))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := tx.Commit(); err != nil {
+		return nil, err
+	}
+
+	return u, nil
+}
+
+// identityUserName returns name made valid as the name of a User created by
+// EnsureIdentity, leaving room for the number it may append.
+func identityUserName(name string) string {
+	const (
+		suffixLength = 10
+		maxLength    = maxUserNameLength - suffixLength - len(identityUserPrefix)
+	)
+
+	name = strings.Map(func(r rune) rune {
+		if unicode.IsControl(r) {
+			return -1
+		}
+		return r
+	}, strings.TrimSpace(name))
+	if name == "" {
+		name = "user"
+	}
+	if r := []rune(name); len(r) > maxLength {
+		name = strings.TrimSpace(string(r[:maxLength]))
+	}
+	return identityUserPrefix + name
+}
+
+// scanUser scans a row of users into a User, returning model.ErrNotFound if
+// there is none.
+func scanUser(row rowScanner) (*model.User, error) {
+	var u model.User
+	if err := row.Scan(&u.ID, &u.Name, &u.CreatedAt, &u.UpdatedAt); err != nil {
+		if err == sql.ErrNoRows {
+			return nil, &model.ErrNotFound{}
+		}
+		return nil, err
+	}
+	return &u, nil
+}
+
+// ownerID returns the owner_id of the TODOs the request of ctx may see: the
+// id of its user, or nil for the TODOs of no user if it is not authenticated.
+// The services call checkUser first, so that nil is only returned when the
+// anonymous requests are allowed.
+func ownerID(ctx context.Context) interface{} {
+	if u := auth.FromContext(ctx); u != nil {
+		return u.ID
+	}
+	return nil
+}
+
+// checkUser returns model.ErrUnauthorized if requireUser is set and the
+// request of ctx is not authenticated. A service that does not require a user
+// serves such a request as no user, with the TODOs, lists and tags it created.
+func checkUser(ctx context.Context, requireUser bool) error {
+	if requireUser && auth.FromContext(ctx) == nil {
+		return &model.ErrUnauthorized{}
+	}
+	return nil
+}
+
+// currentUser returns the user of ctx, or model.ErrUnauthorized if the
+// request is not authenticated.
+func currentUser(ctx context.Context) (*model.User, error) {
+	u := auth.FromContext(ctx)
+	if u == nil {
+		return nil, &model.ErrUnauthorized{}
+	}
+	return u, nil
+}
@@ -0,0 +1,40 @@
+package main
+
+import (
+	"context"
+	"log"
+	"time"
+
+	"github.com/TechBowl-japan/go-stations/service"
+)
+
+// trashPurgeInterval is how often purgeTrash empties the trash at most.
+const trashPurgeInterval = time.Hour
+
+// purgeTrash permanently deletes the TODOs that have been in the trash longer
+// than retention, once on start and then periodically until ctx is done.
+func purgeTrash(ctx context.Context, svc *service.TODOService, retention time.Duration) {
+	interval := trashPurgeInterval
+	if retention < interval {
+		interval = retention
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		n, err := svc.PurgeTrash(ctx, time.Now().Add(-retention))
+		if err != nil && ctx.Err() == nil {
+			log.Printf("failed to purge the trash: %v\n", err)
+		}
+		if n > 0 {
+			log.Printf("Purged %d TODOs deleted more than %s ago\n", n, retention)
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}